@@ -0,0 +1,564 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultAlertWindow batches matches for this long before notifying.
+	defaultAlertWindow = 300
+	maxAlertHistory    = 500
+)
+
+var errAlertNotFound = errors.New("alert not found")
+
+// Alert watches new articles for keywords or a regular expression.
+type Alert struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// Keywords match as whole words, case-insensitively; any one is enough.
+	Keywords []string `json:"keywords"`
+	Regex    string   `json:"regex,omitempty"`
+	// Feed limits the alert to one feed URL; empty means all feeds.
+	Feed       string `json:"feed,omitempty"`
+	ChannelIDs []int  `json:"channel_ids"`
+	// WindowSeconds is how long matches are collected after the first one
+	// before a single notification is sent for all of them.
+	WindowSeconds int  `json:"window_seconds"`
+	Enabled       bool `json:"enabled"`
+
+	user int // owner, whose channels notify
+	res  []*regexp.Regexp
+}
+
+// AlertMatch records an article that matched an alert and what became of
+// the notification: pending, sent, failed or recorded (no channels).
+type AlertMatch struct {
+	ID        int    `json:"id"`
+	AlertID   int    `json:"alert_id"`
+	Link      string `json:"link"`
+	Title     string `json:"title"`
+	Feed      string `json:"feed"`
+	Matched   string `json:"matched"`
+	CreatedAt string `json:"created_at"`
+	SentAt    string `json:"sent_at,omitempty"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// migrateAlerts creates the alert tables. Alerts and channels belong to a
+// user; those from before accounts existed to defaultUserID.
+func migrateAlerts(db *sql.DB) error {
+	createAlerts := `
+	CREATE TABLE IF NOT EXISTS alerts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		config TEXT NOT NULL
+	);`
+	if _, err := db.Exec(createAlerts); err != nil {
+		return err
+	}
+	if err := addColumn(db, "alerts", "user_id", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	createChannels := `
+	CREATE TABLE IF NOT EXISTS notification_channels (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		config TEXT NOT NULL
+	);`
+	if _, err := db.Exec(createChannels); err != nil {
+		return err
+	}
+	if err := addColumn(db, "notification_channels", "user_id", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	createMatches := `
+	CREATE TABLE IF NOT EXISTS alert_matches (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		alert_id INTEGER NOT NULL,
+		link TEXT NOT NULL,
+		title TEXT,
+		feed TEXT,
+		matched TEXT,
+		created_at TEXT NOT NULL,
+		sent_at TEXT,
+		status TEXT NOT NULL DEFAULT 'pending',
+		error TEXT,
+		UNIQUE (alert_id, link)
+	);
+	CREATE INDEX IF NOT EXISTS alert_matches_status ON alert_matches(status);`
+	_, err := db.Exec(createMatches)
+	return err
+}
+
+// ListAlerts returns the user's alerts.
+func (s *sqliteDB) ListAlerts() ([]Alert, error) {
+	return s.listAlerts("WHERE user_id = ?", s.userID())
+}
+
+// ListAllAlerts returns the alerts of every user, for dispatching.
+func (s *sqliteDB) ListAllAlerts() ([]Alert, error) {
+	return s.listAlerts("")
+}
+
+func (s *sqliteDB) listAlerts(where string, args ...interface{}) ([]Alert, error) {
+	rows, err := s.db.Query("SELECT id, user_id, config FROM alerts "+where+" ORDER BY name COLLATE NOCASE, id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	alerts := []Alert{}
+	for rows.Next() {
+		var id, user int
+		var config string
+		if err := rows.Scan(&id, &user, &config); err != nil {
+			return nil, err
+		}
+		var a Alert
+		if err := json.Unmarshal([]byte(config), &a); err != nil {
+			return nil, err
+		}
+		a.ID, a.user = id, user
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+func (s *sqliteDB) CreateAlert(a Alert) (Alert, error) {
+	config, err := json.Marshal(a)
+	if err != nil {
+		return a, err
+	}
+	res, err := s.db.Exec("INSERT INTO alerts (user_id, name, config) VALUES (?, ?, ?)", s.userID(), a.Name, string(config))
+	if err != nil {
+		return a, err
+	}
+	id, err := res.LastInsertId()
+	a.ID, a.user = int(id), s.userID()
+	return a, err
+}
+
+func (s *sqliteDB) UpdateAlert(a Alert) error {
+	config, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec("UPDATE alerts SET name = ?, config = ? WHERE id = ? AND user_id = ?", a.Name, string(config), a.ID, s.userID())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errAlertNotFound
+	}
+	return nil
+}
+
+// DeleteAlert removes one of the user's alerts and its history.
+func (s *sqliteDB) DeleteAlert(id int) error {
+	res, err := s.db.Exec("DELETE FROM alerts WHERE id = ? AND user_id = ?", id, s.userID())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errAlertNotFound
+	}
+	_, err = s.db.Exec("DELETE FROM alert_matches WHERE alert_id = ?", id)
+	return err
+}
+
+// RecordAlertMatch stores a pending match. An article matches an alert at
+// most once.
+func (s *sqliteDB) RecordAlertMatch(m AlertMatch) error {
+	_, err := s.db.Exec(`INSERT OR IGNORE INTO alert_matches (alert_id, link, title, feed, matched, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		m.AlertID, m.Link, m.Title, m.Feed, m.Matched, time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+// PendingAlertMatches returns undelivered matches, oldest first.
+func (s *sqliteDB) PendingAlertMatches() ([]AlertMatch, error) {
+	return s.alertMatches("WHERE status = 'pending' ORDER BY created_at, id")
+}
+
+// FinishAlertMatches sets the outcome of delivering matches.
+func (s *sqliteDB) FinishAlertMatches(ids []int, status, errMsg string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := []interface{}{status, errMsg, time.Now().UTC().Format(time.RFC3339)}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	_, err := s.db.Exec("UPDATE alert_matches SET status = ?, error = NULLIF(?, ''), sent_at = ? WHERE id IN (?"+
+		strings.Repeat(", ?", len(ids)-1)+")", args...)
+	return err
+}
+
+// AlertHistory returns the latest matches of the user's alerts, of one
+// alert or (alertID 0) all.
+func (s *sqliteDB) AlertHistory(alertID, limit int) ([]AlertMatch, error) {
+	where := "WHERE alert_id IN (SELECT id FROM alerts WHERE user_id = ?) "
+	args := []interface{}{s.userID()}
+	if alertID != 0 {
+		where += "AND alert_id = ? "
+		args = append(args, alertID)
+	}
+	args = append(args, limit)
+	return s.alertMatches(where+"ORDER BY created_at DESC, id DESC LIMIT ?", args...)
+}
+
+func (s *sqliteDB) alertMatches(clause string, args ...interface{}) ([]AlertMatch, error) {
+	rows, err := s.db.Query(`SELECT id, alert_id, link, title, feed, matched, created_at, sent_at, status, error
+		FROM alert_matches `+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	matches := []AlertMatch{}
+	for rows.Next() {
+		var m AlertMatch
+		var title, feed, matched, sentAt, errMsg sql.NullString
+		if err := rows.Scan(&m.ID, &m.AlertID, &m.Link, &title, &feed, &matched, &m.CreatedAt, &sentAt, &m.Status, &errMsg); err != nil {
+			return nil, err
+		}
+		m.Title, m.Feed, m.Matched, m.SentAt, m.Error = title.String, feed.String, matched.String, sentAt.String, errMsg.String
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
+
+// compile validates the alert and prepares its regular expressions.
+func (a *Alert) compile() error {
+	a.Name = strings.TrimSpace(a.Name)
+	if a.Name == "" {
+		return errors.New("name is required")
+	}
+	if a.WindowSeconds < 0 {
+		return errors.New("window_seconds must not be negative")
+	}
+	a.res = nil
+	keywords := []string{}
+	for _, kw := range a.Keywords {
+		if kw = strings.TrimSpace(kw); kw == "" {
+			continue
+		}
+		re, err := keywordRegexp(kw)
+		if err != nil {
+			return err
+		}
+		keywords = append(keywords, kw)
+		a.res = append(a.res, re)
+	}
+	a.Keywords = keywords
+	if a.Regex != "" {
+		re, err := regexp.Compile(a.Regex)
+		if err != nil {
+			return fmt.Errorf("regex: %v", err)
+		}
+		a.res = append(a.res, re)
+	}
+	if len(a.res) == 0 {
+		return errors.New("an alert needs keywords or a regex")
+	}
+	if a.ChannelIDs == nil {
+		a.ChannelIDs = []int{}
+	}
+	return nil
+}
+
+// match returns the text in p, from feedURL, that triggers the alert.
+func (a *Alert) match(p Post, feedURL string) (string, bool) {
+	if a.Feed != "" && a.Feed != feedURL {
+		return "", false
+	}
+	text := p.Title + "\n" + plainText(p.Description) + "\n" + plainText(p.Content)
+	for _, re := range a.res {
+		if m := re.FindString(text); m != "" {
+			return m, true
+		}
+	}
+	return "", false
+}
+
+// loadAlerts returns the enabled alerts of the users subscribed to feedURL,
+// ready to match. Invalid stored alerts are logged and skipped.
+func loadAlerts(feedURL string) ([]Alert, error) {
+	users, err := feedSubscribers(feedURL)
+	if err != nil {
+		return nil, err
+	}
+	var enabled []Alert
+	for _, u := range users {
+		alerts, err := db.ForUser(u).ListAlerts()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range alerts {
+			if !a.Enabled {
+				continue
+			}
+			if err := a.compile(); err != nil {
+				log.Printf("Skipping alert %d (%s): %v", a.ID, a.Name, err)
+				continue
+			}
+			enabled = append(enabled, a)
+		}
+	}
+	return enabled, nil
+}
+
+// recordAlerts records a pending match for every alert the new article p
+// triggers. Delivery happens later, in dispatchAlerts.
+func recordAlerts(alerts []Alert, p Post, feedURL string) error {
+	for i := range alerts {
+		matched, ok := alerts[i].match(p, feedURL)
+		if !ok {
+			continue
+		}
+		err := db.RecordAlertMatch(AlertMatch{AlertID: alerts[i].ID, Link: p.Link, Title: p.Title, Feed: feedURL, Matched: matched})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runAlertDispatcher delivers batched alert notifications until the
+// process exits.
+func runAlertDispatcher() {
+	for range time.Tick(config.Scheduler.AlertInterval) {
+		if err := dispatchAlerts(); err != nil {
+			log.Println("Failed to dispatch alerts:", err)
+		}
+	}
+}
+
+// dispatchAlerts sends one notification per alert whose oldest pending
+// match has waited for the alert's window, through the channels of the
+// alert's owner.
+func dispatchAlerts() error {
+	pending, err := db.PendingAlertMatches()
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+	alerts, err := db.ListAllAlerts()
+	if err != nil {
+		return err
+	}
+	alertsByID := map[int]Alert{}
+	for _, a := range alerts {
+		alertsByID[a.ID] = a
+	}
+	// Channels by user, then ID.
+	channelsByID := map[int]map[int]NotifyChannel{}
+	batches := map[int][]AlertMatch{}
+	var order []int
+	for _, m := range pending {
+		if _, ok := batches[m.AlertID]; !ok {
+			order = append(order, m.AlertID)
+		}
+		batches[m.AlertID] = append(batches[m.AlertID], m)
+	}
+
+	now := time.Now()
+	for _, alertID := range order {
+		batch := batches[alertID]
+		alert := alertsByID[alertID]
+		// Matches are oldest first, so the batch is due once the first is.
+		first, err := time.Parse(time.RFC3339, batch[0].CreatedAt)
+		if err == nil && now.Sub(first) < time.Duration(alert.WindowSeconds)*time.Second {
+			continue
+		}
+		ids := make([]int, len(batch))
+		for i, m := range batch {
+			ids[i] = m.ID
+		}
+		if len(alert.ChannelIDs) == 0 {
+			if err := db.FinishAlertMatches(ids, "recorded", ""); err != nil {
+				return err
+			}
+			continue
+		}
+		if channelsByID[alert.user] == nil {
+			channels, err := db.ForUser(alert.user).ListNotifyChannels()
+			if err != nil {
+				return err
+			}
+			channelsByID[alert.user] = map[int]NotifyChannel{}
+			for _, ch := range channels {
+				channelsByID[alert.user][ch.ID] = ch
+			}
+		}
+		n := alertNotification(alert, batch)
+		var failures []string
+		for _, id := range alert.ChannelIDs {
+			ch, ok := channelsByID[alert.user][id]
+			if !ok {
+				failures = append(failures, fmt.Sprintf("channel %d: %v", id, errChannelNotFound))
+				continue
+			}
+			if err := ch.send(n); err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", ch.Name, err))
+			}
+		}
+		status := "sent"
+		if len(failures) > 0 {
+			status = "failed"
+			log.Printf("Alert %q delivery failed: %s", alert.Name, strings.Join(failures, "; "))
+		}
+		if err := db.FinishAlertMatches(ids, status, strings.Join(failures, "; ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// alertNotification builds the notification for a batch of matches.
+func alertNotification(a Alert, matches []AlertMatch) Notification {
+	n := Notification{Alert: a.Name, Matches: matches}
+	if len(matches) == 1 {
+		n.Title = fmt.Sprintf("%s: %s", a.Name, matches[0].Title)
+	} else {
+		n.Title = fmt.Sprintf("%s: %d new articles", a.Name, len(matches))
+	}
+	var sb strings.Builder
+	for _, m := range matches {
+		fmt.Fprintf(&sb, "- %s (%q)\n  %s\n", m.Title, m.Matched, m.Link)
+	}
+	n.Text = strings.TrimRight(sb.String(), "\n")
+	return n
+}
+
+// alertsHandler lists (GET), creates (POST), replaces (PATCH) and deletes
+// (DELETE) the user's alerts.
+func alertsHandler(w http.ResponseWriter, r *http.Request) {
+	db := userDB(r)
+	switch r.Method {
+	case http.MethodGet:
+		alerts, err := db.ListAlerts()
+		if err != nil {
+			http.Error(w, "Failed to list alerts", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(alerts)
+	case http.MethodPost, http.MethodPatch:
+		alert := Alert{Enabled: true, WindowSeconds: defaultAlertWindow}
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil || (r.Method == http.MethodPatch && alert.ID == 0) {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := alert.compile(); err != nil {
+			http.Error(w, "Invalid alert: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := checkChannelIDs(db, alert.ChannelIDs); err != nil {
+			if errors.Is(err, errChannelNotFound) {
+				http.Error(w, "Invalid alert: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			alertError(w, err)
+			return
+		}
+		if r.Method == http.MethodPatch {
+			if err := db.UpdateAlert(alert); err != nil {
+				alertError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		alert, err := db.CreateAlert(alert)
+		if err != nil {
+			alertError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(alert)
+	case http.MethodDelete:
+		var req struct {
+			ID int `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == 0 {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := db.DeleteAlert(req.ID); err != nil {
+			alertError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// alertHistoryHandler handles GET /alerts/history with optional alert_id
+// and limit.
+func alertHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	alertID := 0
+	if v := r.URL.Query().Get("alert_id"); v != "" {
+		var err error
+		if alertID, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "Invalid alert_id", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			limit = min(n, maxAlertHistory)
+		}
+	}
+	matches, err := userDB(r).AlertHistory(alertID, limit)
+	if err != nil {
+		http.Error(w, "Failed to list alert history", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(matches)
+}
+
+// checkChannelIDs returns errChannelNotFound unless all ids are channels of
+// the user of d.
+func checkChannelIDs(d DB, ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	channels, err := d.ListNotifyChannels()
+	if err != nil {
+		return err
+	}
+	own := map[int]bool{}
+	for _, ch := range channels {
+		own[ch.ID] = true
+	}
+	for _, id := range ids {
+		if !own[id] {
+			return fmt.Errorf("%w: %d", errChannelNotFound, id)
+		}
+	}
+	return nil
+}
+
+func alertError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errAlertNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Failed to update alerts", http.StatusInternalServerError)
+}
@@ -0,0 +1,788 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// The versioned API lives under /api/v1. Feeds and articles are resources
+// addressed by ID (apiV1Routes); every other JSON endpoint is served there
+// too, as an alias of its unversioned route, e.g. /api/v1/folders. Under
+// /api/v1 every error has the same JSON body:
+//
+//	{"error": {"code": "not_found", "message": "feed not found"}}
+//
+// The unversioned routes keep their plain-text errors for older clients.
+const apiV1Prefix = "/api/v1"
+
+const (
+	// maxRequestBody limits the JSON bodies read by the v1 handlers.
+	maxRequestBody = 1 << 20
+	// apiPageSize is the page size of GET /api/v1/articles without limit;
+	// unlike /posts, it always pages.
+	apiPageSize = 50
+)
+
+// apiV1NotAliased are the unversioned routes not served under /api/v1:
+// protocols of their own, with their own errors and authentication.
+var apiV1NotAliased = []string{feverPath, greaderLoginPath, greaderPrefix, outputFeedPrefix, apiV1Prefix + "/"}
+
+// apiError is the body of error responses under /api/v1. Fields names the
+// invalid request fields, with what is wrong with each.
+type apiError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// apiErrorCodes are the codes of errors that only have a status, such as
+// the plain-text errors of the unversioned handlers.
+var apiErrorCodes = map[int]string{
+	http.StatusBadRequest:            "invalid_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusGone:                  "gone",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusUnprocessableEntity:   "validation_failed",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusNotImplemented:        "not_implemented",
+	http.StatusBadGateway:            "upstream_failed",
+}
+
+func errorCode(status int) string {
+	if code, ok := apiErrorCodes[status]; ok {
+		return code
+	}
+	if status >= 500 {
+		return "internal_error"
+	}
+	return "error"
+}
+
+// writeAPIError writes an error response; an empty code is derived from
+// the status.
+func writeAPIError(w http.ResponseWriter, status int, code, message string, fields map[string]string) {
+	if code == "" {
+		code = errorCode(status)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiErrorResponse{apiError{Code: code, Message: message, Fields: fields}})
+}
+
+// jsonErrorWriter holds back a plain-text error response, as written by
+// http.Error, to be rewritten as JSON by finish.
+type jsonErrorWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *jsonErrorWriter) WriteHeader(status int) {
+	if status >= 400 && strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		w.status = status
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *jsonErrorWriter) Write(p []byte) (int, error) {
+	if w.status != 0 {
+		return w.body.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *jsonErrorWriter) finish() {
+	if w.status != 0 {
+		writeAPIError(w.ResponseWriter, w.status, "", strings.TrimSpace(w.body.String()), nil)
+	}
+}
+
+// withJSONErrors rewrites the plain-text errors of requests under /api/v1,
+// from the unversioned handlers, the mux (404, 405) and withAuth (401),
+// as JSON.
+func withJSONErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != apiV1Prefix && !strings.HasPrefix(r.URL.Path, apiV1Prefix+"/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		jw := &jsonErrorWriter{ResponseWriter: w}
+		next.ServeHTTP(jw, r)
+		jw.finish()
+	})
+}
+
+// apiV1AliasHandler serves the unversioned routes under /api/v1.
+func apiV1AliasHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, apiV1Prefix)
+	for _, p := range apiV1NotAliased {
+		if path == p || path == strings.TrimSuffix(p, "/") || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	http.StripPrefix(apiV1Prefix, http.DefaultServeMux).ServeHTTP(w, r)
+}
+
+// decodeJSON reads a JSON request body into v, refusing unknown fields,
+// trailing data and bodies over maxRequestBody. On failure it writes the
+// error response and returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil && dec.More() {
+		err = errors.New("unexpected data after the JSON value")
+	}
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeAPIError(w, http.StatusRequestEntityTooLarge, "", "Request body too large", nil)
+	case err != nil:
+		writeAPIError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON body: "+strings.TrimPrefix(err.Error(), "json: "), nil)
+	}
+	return err == nil
+}
+
+// pathID parses the {id} of a resource route.
+func pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_id", "id must be a positive integer", nil)
+		return 0, false
+	}
+	return id, true
+}
+
+// nullableID is an optional ID field where null is a value of its own:
+// Set reports whether the field was present, ID is nil when it was null.
+type nullableID struct {
+	Set bool
+	ID  *int
+}
+
+func (n *nullableID) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	return json.Unmarshal(data, &n.ID)
+}
+
+type apiFeedList struct {
+	Feeds []Feed `json:"feeds"`
+}
+
+type apiFeedCreate struct {
+	URL      string `json:"url"`
+	Name     string `json:"name,omitempty"`
+	FolderID *int   `json:"folder_id,omitempty"`
+}
+
+type apiFeedUpdate struct {
+	Name *string `json:"name,omitempty"`
+	// FolderID moves the feed; null moves it to the top level.
+	FolderID nullableID `json:"folder_id"`
+}
+
+// apiArticle is an article with its read, starred and hidden state.
+type apiArticle struct {
+	Post
+	Read    bool `json:"read"`
+	Starred bool `json:"starred"`
+	Hidden  bool `json:"hidden"`
+}
+
+type apiArticleList struct {
+	Total      int          `json:"total"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+	Articles   []apiArticle `json:"articles"`
+}
+
+type apiArticleUpdate struct {
+	Read    *bool `json:"read,omitempty"`
+	Starred *bool `json:"starred,omitempty"`
+	Hidden  *bool `json:"hidden,omitempty"`
+	// Seconds spent reading, recorded in the history with "read": true.
+	Seconds int `json:"seconds,omitempty"`
+}
+
+// apiParam documents a query parameter in the OpenAPI document.
+type apiParam struct {
+	Name, Type, Description string
+}
+
+// apiRoute is a v1 resource route. The mux patterns and the OpenAPI
+// document are both built from apiV1Routes.
+type apiRoute struct {
+	Method, Path, Summary string
+	Handler               http.HandlerFunc
+	Query                 []apiParam
+	Request               interface{} // request body, nil for none
+	Status                int
+	Response              interface{} // response body, nil for none
+}
+
+var formatParam = apiParam{"format", "string", "html (default), markdown or text"}
+
+var articleQuery = []apiParam{
+	{"feed", "string", "feed URL"},
+	{"folder", "integer", "folder ID, including its subfolders"},
+	{"tag", "string", "tag name"},
+	{"unread", "boolean", "only unread articles"},
+	{"read", "boolean", "only read articles"},
+	{"starred", "boolean", "only starred articles"},
+	{"hidden", "boolean", "only hidden articles"},
+	{"has_enclosure", "boolean", "only articles with an enclosure"},
+	{"since", "string", "published at or after (RFC3339 or YYYY-MM-DD)"},
+	{"until", "string", "published before (RFC3339 or YYYY-MM-DD)"},
+	{"sort", "string", "newest (default) or oldest"},
+	{"limit", "integer", "page size, 1 to " + strconv.Itoa(maxPageSize) + " (default " + strconv.Itoa(apiPageSize) + ")"},
+	{"cursor", "string", "next_cursor of the previous page"},
+	formatParam,
+}
+
+func apiV1Routes() []apiRoute {
+	return []apiRoute{
+		{Method: "GET", Path: "/feeds", Summary: "List feeds with their article counts",
+			Handler: apiListFeeds, Status: http.StatusOK, Response: apiFeedList{}},
+		{Method: "POST", Path: "/feeds", Summary: "Subscribe to a feed, named after its title unless name is given",
+			Handler: apiCreateFeed, Request: apiFeedCreate{}, Status: http.StatusCreated, Response: Feed{}},
+		{Method: "GET", Path: "/feeds/{id}", Summary: "Get a feed",
+			Handler: apiGetFeed, Status: http.StatusOK, Response: Feed{}},
+		{Method: "PATCH", Path: "/feeds/{id}", Summary: "Rename a feed or move it to another folder",
+			Handler: apiUpdateFeed, Request: apiFeedUpdate{}, Status: http.StatusOK, Response: Feed{}},
+		{Method: "DELETE", Path: "/feeds/{id}", Summary: "Unsubscribe from a feed",
+			Handler: apiDeleteFeed, Status: http.StatusNoContent},
+		{Method: "GET", Path: "/articles", Summary: "List articles, newest first, a page at a time",
+			Handler: apiListArticles, Query: articleQuery, Status: http.StatusOK, Response: apiArticleList{}},
+		{Method: "GET", Path: "/articles/{id}", Summary: "Get an article",
+			Handler: apiGetArticle, Query: []apiParam{formatParam}, Status: http.StatusOK, Response: apiArticle{}},
+		{Method: "PATCH", Path: "/articles/{id}", Summary: "Mark an article read or unread, star or hide it",
+			Handler: apiUpdateArticle, Request: apiArticleUpdate{}, Status: http.StatusOK, Response: apiArticle{}},
+		{Method: "GET", Path: "/openapi.json", Summary: "This document",
+			Handler: openAPIHandler, Status: http.StatusOK},
+	}
+}
+
+// registerAPIV1 adds the v1 routes to the default mux. Other methods on
+// their paths get 405; other paths fall through to the unversioned
+// handlers.
+func registerAPIV1() {
+	allowed := map[string][]string{}
+	var paths []string
+	for _, rt := range apiV1Routes() {
+		http.HandleFunc(rt.Method+" "+apiV1Prefix+rt.Path, rt.Handler)
+		if allowed[rt.Path] == nil {
+			paths = append(paths, rt.Path)
+		}
+		allowed[rt.Path] = append(allowed[rt.Path], rt.Method)
+	}
+	for _, path := range paths {
+		allow := strings.Join(allowed[path], ", ")
+		http.HandleFunc(apiV1Prefix+path, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Allow", allow)
+			writeAPIError(w, http.StatusMethodNotAllowed, "", "Method not allowed", nil)
+		})
+	}
+	http.HandleFunc(apiV1Prefix+"/", apiV1AliasHandler)
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// withFeedCounts fills in the article counts of feeds.
+func withFeedCounts(d DB, feeds ...Feed) ([]Feed, error) {
+	counts, err := d.CountsBySource()
+	if err != nil {
+		return nil, err
+	}
+	for i := range feeds {
+		feeds[i].Unread, feeds[i].Total = counts[feeds[i].URL].Unread, counts[feeds[i].URL].Total
+	}
+	return feeds, nil
+}
+
+func apiListFeeds(w http.ResponseWriter, r *http.Request) {
+	d := userDB(r)
+	feeds, err := d.ListFeeds()
+	if err == nil {
+		feeds, err = withFeedCounts(d, feeds...)
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "", "Failed to list feeds", nil)
+		return
+	}
+	if feeds == nil {
+		feeds = []Feed{}
+	}
+	writeAPIJSON(w, http.StatusOK, apiFeedList{Feeds: feeds})
+}
+
+// writeFeed responds with the feed with the given ID and its counts.
+func writeFeed(w http.ResponseWriter, d DB, status, id int) {
+	feed, err := d.GetFeed(id)
+	var feeds []Feed
+	if err == nil {
+		feeds, err = withFeedCounts(d, feed)
+	}
+	switch {
+	case errors.Is(err, errFeedNotFound):
+		writeAPIError(w, http.StatusNotFound, "", err.Error(), nil)
+	case err != nil:
+		writeAPIError(w, http.StatusInternalServerError, "", "Failed to get feed", nil)
+	default:
+		writeAPIJSON(w, status, feeds[0])
+	}
+}
+
+// feedByURL returns the user's subscription to url, if any.
+func feedByURL(d DB, url string) (Feed, bool, error) {
+	feeds, err := d.ListFeeds()
+	if err != nil {
+		return Feed{}, false, err
+	}
+	for _, f := range feeds {
+		if f.URL == url {
+			return f, true, nil
+		}
+	}
+	return Feed{}, false, nil
+}
+
+// checkFolder validates a folder_id field into fields.
+func checkFolder(d DB, id *int, fields map[string]string) error {
+	if id == nil {
+		return nil
+	}
+	_, err := d.(*sqliteDB).getFolder(*id)
+	if errors.Is(err, errFolderNotFound) {
+		fields["folder_id"] = "no such folder"
+		return nil
+	}
+	return err
+}
+
+func apiCreateFeed(w http.ResponseWriter, r *http.Request) {
+	d := userDB(r)
+	var req apiFeedCreate
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	req.URL, req.Name = strings.TrimSpace(req.URL), strings.TrimSpace(req.Name)
+	fields := map[string]string{}
+	if !validFeedURL(req.URL) {
+		fields["url"] = "must be an http(s) URL"
+	}
+	if err := checkFolder(d, req.FolderID, fields); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "", "Failed to check folder", nil)
+		return
+	}
+	if len(fields) > 0 {
+		writeAPIError(w, http.StatusUnprocessableEntity, "", "Invalid feed", fields)
+		return
+	}
+	if existing, ok, err := feedByURL(d, req.URL); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "", "Failed to add feed", nil)
+		return
+	} else if ok {
+		w.Header().Set("Location", apiV1Prefix+"/feeds/"+strconv.Itoa(existing.ID))
+		writeAPIError(w, http.StatusConflict, "already_subscribed", "Already subscribed to this feed", nil)
+		return
+	}
+	if req.Name == "" {
+		req.Name = FetchFeedTitle(req.URL)
+	}
+	err := d.AddFeed(req.URL, req.Name)
+	if err == nil && req.FolderID != nil {
+		err = d.MoveFeed(req.URL, req.FolderID)
+	}
+	var feed Feed
+	if err == nil {
+		feed, _, err = feedByURL(d, req.URL)
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "", "Failed to add feed", nil)
+		return
+	}
+	emitEvent(d, EventFeedAdded, feedEventData{URL: req.URL, Name: req.Name})
+	w.Header().Set("Location", apiV1Prefix+"/feeds/"+strconv.Itoa(feed.ID))
+	writeFeed(w, d, http.StatusCreated, feed.ID)
+}
+
+func apiGetFeed(w http.ResponseWriter, r *http.Request) {
+	if id, ok := pathID(w, r); ok {
+		writeFeed(w, userDB(r), http.StatusOK, id)
+	}
+}
+
+func apiUpdateFeed(w http.ResponseWriter, r *http.Request) {
+	d := userDB(r)
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var req apiFeedUpdate
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	feed, err := d.GetFeed(id)
+	if errors.Is(err, errFeedNotFound) {
+		writeAPIError(w, http.StatusNotFound, "", err.Error(), nil)
+		return
+	}
+	fields := map[string]string{}
+	if err == nil {
+		err = checkFolder(d, req.FolderID.ID, fields)
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "", "Failed to update feed", nil)
+		return
+	}
+	if req.Name != nil && strings.TrimSpace(*req.Name) == "" {
+		fields["name"] = "must not be empty"
+	}
+	if len(fields) > 0 {
+		writeAPIError(w, http.StatusUnprocessableEntity, "", "Invalid feed", fields)
+		return
+	}
+	if req.Name != nil {
+		err = d.RenameFeed(feed.URL, strings.TrimSpace(*req.Name))
+	}
+	if err == nil && req.FolderID.Set {
+		err = d.MoveFeed(feed.URL, req.FolderID.ID)
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "", "Failed to update feed", nil)
+		return
+	}
+	writeFeed(w, d, http.StatusOK, id)
+}
+
+func apiDeleteFeed(w http.ResponseWriter, r *http.Request) {
+	d := userDB(r)
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	feed, err := d.GetFeed(id)
+	if err == nil {
+		err = d.RemoveFeed(feed.URL)
+	}
+	switch {
+	case errors.Is(err, errFeedNotFound):
+		writeAPIError(w, http.StatusNotFound, "", err.Error(), nil)
+	case err != nil:
+		writeAPIError(w, http.StatusInternalServerError, "", "Failed to remove feed", nil)
+	default:
+		emitEvent(d, EventFeedRemoved, feedEventData{URL: feed.URL, Name: feed.FeedName})
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// withState adds the read and hidden state of the user to articles, after
+// rendering them in format.
+func withState(posts []Post, user int, format string) ([]apiArticle, error) {
+	articles := make([]apiArticle, len(posts))
+	if len(posts) == 0 {
+		return articles, nil
+	}
+	if err := attachTags(db.ForUser(user), posts); err != nil {
+		return nil, err
+	}
+	ids := make([]int, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+	}
+	read, err := articleIDs(ArticleFilter{User: user, AnyFeed: true, IDs: ids, Read: true, WithHidden: true})
+	if err != nil {
+		return nil, err
+	}
+	hidden, err := articleIDs(ArticleFilter{User: user, AnyFeed: true, IDs: ids, Hidden: true})
+	if err != nil {
+		return nil, err
+	}
+	in := func(ids []int, id int) bool {
+		for _, i := range ids {
+			if i == id {
+				return true
+			}
+		}
+		return false
+	}
+	for i, p := range posts {
+		renderPost(&p, format)
+		articles[i] = apiArticle{Post: p, Read: in(read, p.ID), Starred: p.Starred, Hidden: in(hidden, p.ID)}
+	}
+	return articles, nil
+}
+
+func apiListArticles(w http.ResponseWriter, r *http.Request) {
+	format, err := formatFromRequest(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_parameter", err.Error(), nil)
+		return
+	}
+	filter, err := parsePostsQuery(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_parameter", err.Error(), nil)
+		return
+	}
+	filter.User = requestUserID(r)
+	if filter.Limit == 0 {
+		filter.Limit = apiPageSize
+	}
+	posts, total, next, err := articlePage(filter)
+	var articles []apiArticle
+	if err == nil {
+		articles, err = withState(posts, filter.User, format)
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "", "Failed to list articles", nil)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, apiArticleList{Total: total, NextCursor: next, Articles: articles})
+}
+
+// writeArticle responds with the article with the given ID, hidden or not,
+// if the user can see it.
+func writeArticle(w http.ResponseWriter, user, id int, format string) {
+	posts, err := QueryArticles(ArticleFilter{User: user, IDs: []int{id}, WithHidden: true})
+	var articles []apiArticle
+	if err == nil {
+		articles, err = withState(posts, user, format)
+	}
+	switch {
+	case err != nil:
+		writeAPIError(w, http.StatusInternalServerError, "", "Failed to get article", nil)
+	case len(articles) == 0:
+		writeAPIError(w, http.StatusNotFound, "", "article not found", nil)
+	default:
+		writeAPIJSON(w, http.StatusOK, articles[0])
+	}
+}
+
+func apiGetArticle(w http.ResponseWriter, r *http.Request) {
+	format, err := formatFromRequest(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_parameter", err.Error(), nil)
+		return
+	}
+	if id, ok := pathID(w, r); ok {
+		writeArticle(w, requestUserID(r), id, format)
+	}
+}
+
+func apiUpdateArticle(w http.ResponseWriter, r *http.Request) {
+	d, user := userDB(r), requestUserID(r)
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var req apiArticleUpdate
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	fields := map[string]string{}
+	if req.Seconds < 0 {
+		fields["seconds"] = "must not be negative"
+	}
+	if req.Seconds > 0 && (req.Read == nil || !*req.Read) {
+		fields["seconds"] = `only goes with "read": true`
+	}
+	if len(fields) > 0 {
+		writeAPIError(w, http.StatusUnprocessableEntity, "", "Invalid article update", fields)
+		return
+	}
+	posts, err := QueryArticles(ArticleFilter{User: user, IDs: []int{id}, WithHidden: true})
+	if err == nil && len(posts) == 0 {
+		writeAPIError(w, http.StatusNotFound, "", "article not found", nil)
+		return
+	}
+	if err == nil && req.Read != nil {
+		if *req.Read {
+			err = markRead(d, posts[0].Link, req.Seconds)
+		} else {
+			err = d.MarkUnread(posts[0].Link)
+		}
+	}
+	if err == nil && req.Starred != nil {
+		if *req.Starred {
+			err = d.Star(posts[0].Link)
+		} else {
+			err = d.Unstar(posts[0].Link)
+		}
+	}
+	if err == nil && req.Hidden != nil {
+		if *req.Hidden {
+			err = d.Hide(posts[0].Link)
+		} else {
+			err = d.Unhide(posts[0].Link)
+		}
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "", "Failed to update article", nil)
+		return
+	}
+	writeArticle(w, user, id, FormatHTML)
+}
+
+// openAPIHandler serves the OpenAPI 3 document of the v1 resource routes.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	writeAPIJSON(w, http.StatusOK, openAPIDocument(baseURL(r)))
+}
+
+func openAPIDocument(server string) map[string]interface{} {
+	schemas := map[string]interface{}{}
+	errorRef := schemaOf(reflect.TypeOf(apiErrorResponse{}), schemas)
+	paths := map[string]map[string]interface{}{}
+	for _, rt := range apiV1Routes() {
+		op := map[string]interface{}{"summary": rt.Summary}
+		var params []interface{}
+		if strings.Contains(rt.Path, "{id}") {
+			params = append(params, map[string]interface{}{
+				"name": "id", "in": "path", "required": true, "schema": map[string]string{"type": "integer"},
+			})
+		}
+		for _, p := range rt.Query {
+			params = append(params, map[string]interface{}{
+				"name": p.Name, "in": "query", "description": p.Description, "schema": map[string]string{"type": p.Type},
+			})
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		if rt.Request != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": schemaOf(reflect.TypeOf(rt.Request), schemas)}},
+			}
+		}
+		success := map[string]interface{}{"description": http.StatusText(rt.Status)}
+		if rt.Response != nil {
+			success["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": schemaOf(reflect.TypeOf(rt.Response), schemas)}}
+		}
+		op["responses"] = map[string]interface{}{
+			strconv.Itoa(rt.Status): success,
+			"default": map[string]interface{}{
+				"description": "Error",
+				"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": errorRef}},
+			},
+		}
+		if paths[rt.Path] == nil {
+			paths[rt.Path] = map[string]interface{}{}
+		}
+		paths[rt.Path][strings.ToLower(rt.Method)] = op
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":   "RSS Reader API",
+			"version": "1",
+			"description": "Resource routes of the v1 API. The other endpoints (folders, tags, searches, ...) " +
+				"are served under /api/v1 as well, with the same JSON errors.",
+		},
+		"servers":  []map[string]string{{"url": server + apiV1Prefix}},
+		"security": []map[string][]string{{"bearer": {}}, {"session": {}}},
+		"paths":    paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearer":  map[string]string{"type": "http", "scheme": "bearer"},
+				"session": map[string]string{"type": "apiKey", "in": "cookie", "name": sessionCookie},
+			},
+		},
+	}
+}
+
+// schemaOf returns the JSON schema of t, following encoding/json's rules,
+// with named structs added to schemas and referenced.
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	if t == reflect.TypeOf(nullableID{}) {
+		return map[string]interface{}{"type": "integer", "nullable": true}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := schemaOf(t.Elem(), schemas)
+		if _, ref := s["$ref"]; ref {
+			return map[string]interface{}{"allOf": []interface{}{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		name := strings.TrimPrefix(t.Name(), "api")
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + name}
+		if _, ok := schemas[name]; ok {
+			return ref
+		}
+		schemas[name] = nil // placeholder against recursion
+		props := map[string]interface{}{}
+		var required []string
+		structFields(t, schemas, props, &required)
+		s := map[string]interface{}{"type": "object", "properties": props}
+		if required != nil {
+			s["required"] = required
+		}
+		schemas[name] = s
+		return ref
+	}
+	return map[string]interface{}{}
+}
+
+// structFields adds the JSON fields of struct t to props. Fields of
+// embedded structs are added after t's own, which take precedence.
+func structFields(t reflect.Type, schemas, props map[string]interface{}, required *[]string) {
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			embedded = append(embedded, f.Type)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if _, ok := props[name]; ok {
+			continue
+		}
+		props[name] = schemaOf(f.Type, schemas)
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Ptr && f.Type != reflect.TypeOf(nullableID{}) {
+			*required = append(*required, name)
+		}
+	}
+	for _, e := range embedded {
+		structFields(e, schemas, props, required)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// undoWindow is how long a bulk read operation can be undone. Operations
+// are kept for twice as long, so a late undo gets errOperationExpired
+// rather than errOperationNotFound.
+const undoWindow = 10 * time.Minute
+
+var (
+	errOperationNotFound = errors.New("operation not found")
+	errOperationExpired  = errors.New("operation can no longer be undone")
+)
+
+// ReadOperation records the articles a bulk operation changed, so that
+// exactly those can be restored: Links were unread before a mark-read (or
+// read before a mark-unread when Unread is set).
+type ReadOperation struct {
+	Token     string
+	Unread    bool
+	Links     []string
+	CreatedAt time.Time
+}
+
+func migrateReadOperations(db *sql.DB) error {
+	createOperations := `
+	CREATE TABLE IF NOT EXISTS read_operations (
+		token TEXT PRIMARY KEY,
+		unread INTEGER NOT NULL,
+		links TEXT NOT NULL,
+		created_at TEXT NOT NULL
+	);`
+	if _, err := db.Exec(createOperations); err != nil {
+		return err
+	}
+	return addColumn(db, "read_operations", "user_id", "INTEGER NOT NULL DEFAULT 1")
+}
+
+// ApplyReadOperation marks op.Links read (or unread) and stores op for undo.
+func (s *sqliteDB) ApplyReadOperation(op ReadOperation) error {
+	links, err := json.Marshal(op.Links)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := setRead(tx, s.userID(), op.Links, !op.Unread); err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO read_operations (token, user_id, unread, links, created_at) VALUES (?, ?, ?, ?, ?)",
+		op.Token, s.userID(), op.Unread, string(links), op.CreatedAt.UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetRead marks links read, or unread when read is false, without
+// recording an operation to undo.
+func (s *sqliteDB) SetRead(links []string, read bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := setRead(tx, s.userID(), links, read); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UndoReadOperation reverts the operation with this token, if it was made
+// after notBefore, and forgets it.
+func (s *sqliteDB) UndoReadOperation(token string, notBefore time.Time) (ReadOperation, error) {
+	op := ReadOperation{Token: token}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return op, err
+	}
+	defer tx.Rollback()
+	var links, createdAt string
+	err = tx.QueryRow("SELECT unread, links, created_at FROM read_operations WHERE token = ? AND user_id = ?", token, s.userID()).
+		Scan(&op.Unread, &links, &createdAt)
+	if err == sql.ErrNoRows {
+		return op, errOperationNotFound
+	}
+	if err != nil {
+		return op, err
+	}
+	if err := json.Unmarshal([]byte(links), &op.Links); err != nil {
+		return op, err
+	}
+	if op.CreatedAt, err = time.Parse(time.RFC3339, createdAt); err != nil {
+		return op, err
+	}
+	if op.CreatedAt.Before(notBefore) {
+		return op, errOperationExpired
+	}
+	if err := setRead(tx, s.userID(), op.Links, op.Unread); err != nil {
+		return op, err
+	}
+	if _, err := tx.Exec("DELETE FROM read_operations WHERE token = ?", token); err != nil {
+		return op, err
+	}
+	return op, tx.Commit()
+}
+
+// PruneReadOperations forgets operations made before t.
+func (s *sqliteDB) PruneReadOperations(t time.Time) error {
+	_, err := s.db.Exec("DELETE FROM read_operations WHERE created_at < ?", t.UTC().Format(time.RFC3339))
+	return err
+}
+
+// setRead marks links read for user, or unread when read is false.
+func setRead(tx *sql.Tx, user int, links []string, read bool) error {
+	query := "DELETE FROM read_articles WHERE user_id = ? AND link = ?"
+	if read {
+		query = "INSERT OR IGNORE INTO read_articles (user_id, link) VALUES (?, ?)"
+	}
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, link := range links {
+		if _, err := stmt.Exec(user, link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchingLinks returns the links of the articles matching f.
+func matchingLinks(f ArticleFilter) ([]string, error) {
+	where, args := f.where()
+	return db.(*sqliteDB).listLinks("SELECT link FROM articles "+where, args...)
+}
+
+// bulkReadHandler handles POST /read/bulk. The body selects articles by
+// any combination of feed, folder, before (RFC3339 or YYYY-MM-DD,
+// exclusive) and ids, or all of them with {"all": true}; "unread": true
+// marks them unread instead. The response carries a token for POST
+// /read/undo.
+func bulkReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Feed   string `json:"feed"`
+		Folder int    `json:"folder"`
+		Before string `json:"before"`
+		IDs    []int  `json:"ids"`
+		All    bool   `json:"all"`
+		Unread bool   `json:"unread"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	f := ArticleFilter{User: requestUserID(r), Feed: req.Feed, Folder: req.Folder, IDs: req.IDs}
+	var err error
+	if f.Until, err = parseDateParam(req.Before); err != nil {
+		http.Error(w, "Invalid before", http.StatusBadRequest)
+		return
+	}
+	// An empty selection would match everything; catching up must be asked
+	// for explicitly.
+	if f.empty() && !req.All {
+		http.Error(w, "Select articles with feed, folder, before or ids, or set all", http.StatusBadRequest)
+		return
+	}
+	// Only articles that change are recorded, so undo leaves the others alone.
+	f.Unread, f.Read = !req.Unread, req.Unread
+	links, err := matchingLinks(f)
+	if err != nil {
+		http.Error(w, "Failed to update read articles", http.StatusInternalServerError)
+		return
+	}
+	token, err := randomToken(16)
+	if err != nil {
+		http.Error(w, "Failed to update read articles", http.StatusInternalServerError)
+		return
+	}
+	op := ReadOperation{Token: token, Unread: req.Unread, Links: links, CreatedAt: time.Now()}
+	if err := db.PruneReadOperations(op.CreatedAt.Add(-2 * undoWindow)); err == nil {
+		err = userDB(r).ApplyReadOperation(op)
+	}
+	if err != nil {
+		http.Error(w, "Failed to update read articles", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":      op.Token,
+		"count":      len(op.Links),
+		"expires_at": op.CreatedAt.Add(undoWindow).UTC().Format(time.RFC3339),
+	})
+}
+
+// undoReadHandler handles POST /read/undo with {"token"}, restoring the
+// read state from before the bulk operation.
+func undoReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	op, err := userDB(r).UndoReadOperation(req.Token, time.Now().Add(-undoWindow))
+	switch {
+	case errors.Is(err, errOperationNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errOperationExpired):
+		http.Error(w, err.Error(), http.StatusGone)
+	case err != nil:
+		http.Error(w, "Failed to update read articles", http.StatusInternalServerError)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"count": len(op.Links)})
+	}
+}
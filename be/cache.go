@@ -5,18 +5,32 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 )
 
+// fetchURL gets url with the fetch.timeout and fetch.user_agent settings.
+func fetchURL(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if config.Fetch.UserAgent != "" {
+		req.Header.Set("User-Agent", config.Fetch.UserAgent)
+	}
+	client := &http.Client{Timeout: config.Fetch.Timeout}
+	return client.Do(req)
+}
+
 // fetchAndParseRSS fetches the feed and parses it using ParseFeed from parser.go
 func fetchAndParseRSS(url string) ([]Post, error) {
 	var lastErr error
-	for attempt := 1; attempt <= 3; attempt++ {
-		resp, err := http.Get(url)
+	for attempt := 1; attempt <= config.Fetch.Attempts; attempt++ {
+		resp, err := fetchURL(url)
 		if err != nil {
 			lastErr = err
 			log.Printf("Fetch attempt %d failed for %s: %v", attempt, url, err)
-			time.Sleep(500 * time.Millisecond)
+			time.Sleep(config.Fetch.RetryDelay)
 			continue
 		}
 		body, err := io.ReadAll(resp.Body)
@@ -24,14 +38,14 @@ func fetchAndParseRSS(url string) ([]Post, error) {
 		if err != nil {
 			lastErr = err
 			log.Printf("Read attempt %d failed for %s: %v", attempt, url, err)
-			time.Sleep(500 * time.Millisecond)
+			time.Sleep(config.Fetch.RetryDelay)
 			continue
 		}
 		posts, err := ParseFeed(body)
 		if err != nil {
 			lastErr = err
 			log.Printf("Parse attempt %d failed for %s: %v", attempt, url, err)
-			time.Sleep(500 * time.Millisecond)
+			time.Sleep(config.Fetch.RetryDelay)
 			continue
 		}
 		return posts, nil // Success!
@@ -45,10 +59,45 @@ func FetchAndCacheFeed(feedURL string) error {
 	if err != nil {
 		return err
 	}
+	rules, err := loadRules(feedURL)
+	if err != nil {
+		return err
+	}
+	alerts, err := loadAlerts(feedURL)
+	if err != nil {
+		return err
+	}
 	for _, post := range posts {
-		err := upsertArticle(post, feedURL)
-		if err != nil {
+		// Rules and alerts only apply to articles seen for the first time.
+		stored, exists, err := cachedArticle(post.Link)
+		isNew := err == nil && !exists
+		changed := exists && (stored.Title != post.Title || stored.Description != post.Description || stored.Content != post.Content)
+		var actions map[int][]RuleAction
+		if isNew {
+			post.Source = feedURL
+			actions = rules.actions(post)
+		}
+		if rules.deleted(actions) {
+			continue
+		}
+		if err := upsertArticle(post, feedURL); err != nil {
 			log.Printf("Failed to upsert article %s: %v", post.Link, err)
+			continue
+		}
+		if err := applyRuleActions(post.Link, actions); err != nil {
+			log.Printf("Failed to apply rules to article %s: %v", post.Link, err)
+		}
+		if isNew {
+			if err := recordAlerts(alerts, post, feedURL); err != nil {
+				log.Printf("Failed to record alerts for article %s: %v", post.Link, err)
+			}
+			if stored, _, err := cachedArticle(post.Link); err == nil {
+				post.ID = stored.ID
+			}
+			emitFeedEvent(feedURL, EventArticleNew, articleEvent(post, feedURL))
+		} else if changed {
+			post.ID = stored.ID
+			emitFeedEvent(feedURL, EventArticleUpdated, articleEvent(post, feedURL))
 		}
 	}
 	return nil
@@ -56,7 +105,7 @@ func FetchAndCacheFeed(feedURL string) error {
 
 // RefreshAllFeeds fetches and caches all feeds in the DB.
 func RefreshAllFeeds() error {
-	feeds, err := db.ListFeeds()
+	feeds, err := db.ListAllFeeds()
 	if err != nil {
 		return err
 	}
@@ -64,8 +113,10 @@ func RefreshAllFeeds() error {
 		log.Println("Refreshing feed:", feed.URL)
 		if err := FetchAndCacheFeed(feed.URL); err != nil {
 			log.Println("Failed to refresh feed:", feed.URL, err)
+			emitFeedEvent(feed.URL, EventFeedFailed, feedEventData{URL: feed.URL, Name: feed.FeedName, Error: err.Error()})
 		}
 	}
+	refreshFavicons(feeds)
 	return nil
 }
 
@@ -80,36 +131,66 @@ func GetCachedArticles(db interface{}) ([]Post, error) {
 	}
 
 	// Query the articles table, including enclosure fields
-	rows, err := sqliteDB.db.Query(`
-		SELECT title, link, description, content, source, pubdate, enclosure_url, enclosure_type, enclosure_length
-		FROM articles
-	`)
+	where, args := ArticleFilter{User: sqliteDB.userID()}.where()
+	args = append([]interface{}{sqliteDB.userID()}, args...)
+	rows, err := sqliteDB.db.Query(`SELECT `+articleColumns+` FROM articles `+where, args...)
 	if err != nil {
 		log.Printf("DB query error in GetCachedArticles: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
 
-	var posts []Post
+	return scanArticles(rows), nil
+}
+
+// notHidden excludes articles hidden by the user or by rules; its one
+// parameter is the user.
+const notHidden = `link NOT IN (SELECT link FROM hidden_articles WHERE user_id = ?)`
+
+// articleColumns lists the columns read by scanArticles, in scan order.
+// Its one parameter is the user whose stars are returned.
+const articleColumns = `id, title, link, description, content, source, author, categories, pubdate, fetched_at,
+	enclosure_url, enclosure_type, enclosure_length,
+	link IN (SELECT link FROM starred_articles WHERE user_id = ?)`
+
+// articleDateExpr is the date used for filtering and sorting: the publish
+// date when the feed provided one, otherwise the time it was fetched.
+const articleDateExpr = `COALESCE(NULLIF(pubdate, ''), fetched_at)`
+
+// scanArticles reads Posts selected with articleColumns.
+// Rows that fail to scan are logged and skipped.
+func scanArticles(rows *sql.Rows) []Post {
+	posts := []Post{}
 	for rows.Next() {
 		var post Post
-		var enclosureURL, enclosureType, enclosureLength sql.NullString
+		var author, categories, pubDate, fetchedAt, enclosureURL, enclosureType, enclosureLength sql.NullString
 
 		err := rows.Scan(
+			&post.ID,
 			&post.Title,
 			&post.Link,
 			&post.Description,
 			&post.Content,
 			&post.Source,
-			&post.PubDate,
+			&author,
+			&categories,
+			&pubDate,
+			&fetchedAt,
 			&enclosureURL,
 			&enclosureType,
 			&enclosureLength,
+			&post.Starred,
 		)
 		if err != nil {
-			log.Printf("Row scan error in GetCachedArticles: %v", err)
+			log.Printf("Row scan error in scanArticles: %v", err)
 			continue // Skip this row, but keep going
 		}
+		post.Author = author.String
+		if categories.String != "" {
+			post.Categories = strings.Split(categories.String, "\n")
+		}
+		post.PubDate = pubDate.String
+		post.FetchedAt = fetchedAt.String
 
 		// Only set Enclosure if URL is present
 		if enclosureURL.Valid && enclosureURL.String != "" {
@@ -122,23 +203,225 @@ func GetCachedArticles(db interface{}) ([]Post, error) {
 
 		posts = append(posts, post)
 	}
+	return posts
+}
+
+// ArticleFilter narrows the articles returned by QueryArticles.
+// Zero values mean no restriction.
+type ArticleFilter struct {
+	// User whose subscriptions and read/star state apply; 0 means
+	// defaultUserID. Only articles of the user's feeds, and articles the
+	// user starred, are selected unless AnyFeed is set.
+	User    int
+	AnyFeed bool
+	IDs     []int
+	// SinceID and MaxID keep only articles with a higher or lower ID.
+	SinceID int
+	MaxID   int
+	Feed    string // feed URL, matched against articles.source
+	Folder  int    // folder ID; includes feeds in its subfolders
+	Tag     string // tag name
+	Match   string // FTS5 match expression; requires searchEnabled
+	Since   time.Time
+	Until   time.Time
+	Unread  bool
+	Read    bool
+	Starred bool
+	// Enclosure keeps only articles with an enclosure (podcasts, videos).
+	Enclosure bool
+	// Hidden articles are left out unless Hidden (only hidden articles)
+	// or WithHidden (hidden or not) is set.
+	Hidden     bool
+	WithHidden bool
+	// Oldest sorts oldest first instead of newest first.
+	Oldest bool
+	// ByID sorts by article ID, i.e. the order articles were first cached,
+	// instead of by date.
+	ByID bool
+	// After continues a listing in the same sort order after this article.
+	After *articleCursor
+	Limit int
+}
+
+// where builds the SQL WHERE clause (including the keyword) and its arguments.
+func (f ArticleFilter) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if len(f.IDs) > 0 {
+		conds = append(conds, "id IN (?"+strings.Repeat(", ?", len(f.IDs)-1)+")")
+		for _, id := range f.IDs {
+			args = append(args, id)
+		}
+	}
+	if f.SinceID != 0 {
+		conds = append(conds, "id > ?")
+		args = append(args, f.SinceID)
+	}
+	if f.MaxID != 0 {
+		conds = append(conds, "id < ?")
+		args = append(args, f.MaxID)
+	}
+	if f.Feed != "" {
+		conds = append(conds, "source = ?")
+		args = append(args, f.Feed)
+	}
+	if f.Folder != 0 {
+		conds = append(conds, `source IN (SELECT url FROM feeds WHERE user_id = ? AND folder_id IN (
+			WITH RECURSIVE sub(id) AS (
+				SELECT ? UNION SELECT folders.id FROM folders JOIN sub ON folders.parent_id = sub.id
+			) SELECT id FROM sub))`)
+		args = append(args, f.user(), f.Folder)
+	}
+	if f.Tag != "" {
+		conds = append(conds, "link IN (SELECT link FROM article_tags JOIN tags ON tags.id = article_tags.tag_id WHERE tags.user_id = ? AND tags.name = ?)")
+		args = append(args, f.user(), f.Tag)
+	}
+	if f.Match != "" {
+		conds = append(conds, "id IN (SELECT rowid FROM articles_fts WHERE articles_fts MATCH ?)")
+		args = append(args, f.Match)
+	}
+	if !f.Since.IsZero() {
+		conds = append(conds, articleDateExpr+" >= ?")
+		args = append(args, f.Since.UTC().Format(time.RFC3339))
+	}
+	if !f.Until.IsZero() {
+		conds = append(conds, articleDateExpr+" < ?")
+		args = append(args, f.Until.UTC().Format(time.RFC3339))
+	}
+	if f.Unread {
+		conds = append(conds, "link NOT IN (SELECT link FROM read_articles WHERE user_id = ?)")
+		args = append(args, f.user())
+	}
+	if f.Read {
+		conds = append(conds, "link IN (SELECT link FROM read_articles WHERE user_id = ?)")
+		args = append(args, f.user())
+	}
+	if f.Starred {
+		conds = append(conds, "link IN (SELECT link FROM starred_articles WHERE user_id = ?)")
+		args = append(args, f.user())
+	}
+	if !f.AnyFeed {
+		conds = append(conds, `(source IN (SELECT url FROM feeds WHERE user_id = ?)
+			OR link IN (SELECT link FROM starred_articles WHERE user_id = ?))`)
+		args = append(args, f.user(), f.user())
+	}
+	if f.Enclosure {
+		conds = append(conds, "COALESCE(enclosure_url, '') != ''")
+	}
+	if f.After != nil {
+		op := "<"
+		if f.Oldest {
+			op = ">"
+		}
+		conds = append(conds, "("+articleDateExpr+" "+op+" ? OR ("+articleDateExpr+" = ? AND id "+op+" ?))")
+		args = append(args, f.After.Date, f.After.Date, f.After.ID)
+	}
+	switch {
+	case f.Hidden:
+		conds = append(conds, "link IN (SELECT link FROM hidden_articles WHERE user_id = ?)")
+		args = append(args, f.user())
+	case !f.WithHidden:
+		conds = append(conds, notHidden)
+		args = append(args, f.user())
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conds, " AND "), args
+}
+
+func (f ArticleFilter) user() int {
+	if f.User == 0 {
+		return defaultUserID
+	}
+	return f.User
+}
+
+// empty reports whether the filter selects every visible article, as
+// GetCachedArticles does.
+func (f ArticleFilter) empty() bool {
+	where, _ := f.where()
+	all, _ := ArticleFilter{}.where()
+	return where == all && f.Limit == 0 && !f.Oldest && !f.ByID
+}
+
+// orderBy returns the ORDER BY clause for f.
+func (f ArticleFilter) orderBy() string {
+	order := "DESC"
+	if f.Oldest {
+		order = "ASC"
+	}
+	if f.ByID {
+		return ` ORDER BY id ` + order
+	}
+	return ` ORDER BY ` + articleDateExpr + ` ` + order + `, id ` + order
+}
 
-	return posts, nil
+// QueryArticles returns the cached articles matching f, newest first
+// unless f.Oldest is set.
+func QueryArticles(f ArticleFilter) ([]Post, error) {
+	where, args := f.where()
+	args = append([]interface{}{f.user()}, args...)
+	query := `SELECT ` + articleColumns + ` FROM articles ` + where + f.orderBy()
+	if f.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, f.Limit)
+	}
+	rows, err := db.(*sqliteDB).db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanArticles(rows), nil
+}
+
+// CountArticles returns the number of cached articles matching f.
+func CountArticles(f ArticleFilter) (int, error) {
+	where, args := f.where()
+	var n int
+	err := db.(*sqliteDB).db.QueryRow(`SELECT COUNT(*) FROM articles `+where, args...).Scan(&n)
+	return n, err
+}
+
+// articleIDs returns the IDs of the articles matching f, in f's order.
+func articleIDs(f ArticleFilter) ([]int, error) {
+	where, args := f.where()
+	query := `SELECT id FROM articles ` + where + f.orderBy()
+	if f.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, f.Limit)
+	}
+	rows, err := db.(*sqliteDB).db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
 }
 
 // upsertArticle inserts or updates an article in the DB.
 func upsertArticle(p Post, source string) error {
 	_, err := db.(*sqliteDB).db.Exec(`
 	INSERT INTO articles (
-		title, link, description, content, source, pubdate, fetched_at,
+		title, link, description, content, source, author, categories, pubdate, fetched_at,
 		enclosure_url, enclosure_type, enclosure_length
 	)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	ON CONFLICT(link) DO UPDATE SET
 		title=excluded.title,
 		description=excluded.description,
 		content=excluded.content,
 		source=excluded.source,
+		author=excluded.author,
+		categories=excluded.categories,
 		pubdate=excluded.pubdate,
 		fetched_at=excluded.fetched_at,
 		enclosure_url=excluded.enclosure_url,
@@ -150,8 +433,10 @@ func upsertArticle(p Post, source string) error {
 		p.Description,
 		p.Content,
 		source,
+		p.Author,
+		strings.Join(p.Categories, "\n"),
 		p.PubDate,
-		time.Now().Format(time.RFC3339),
+		time.Now().UTC().Format(time.RFC3339),
 		// Enclosure fields: use empty string if nil
 		func() string {
 			if p.Enclosure != nil {
@@ -175,5 +460,8 @@ func upsertArticle(p Post, source string) error {
 			}
 		}(),
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	return indexArticle(p)
 }
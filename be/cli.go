@@ -0,0 +1,507 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// command is a subcommand of the binary. Commands work on the database
+// directly, so they can run from cron or a shell while the server is
+// stopped (or running: SQLite handles the concurrent access).
+type command struct {
+	name, args, help string
+	run              func(args []string) error
+}
+
+// commands lists the subcommands; serve, the default, is run by main.
+var commands = []command{
+	{"serve", "[-addr ADDR] [-no-auth]", "run the HTTP server (the default)", nil},
+	{"feeds", "add URL [-name NAME] [-folder PATH] | rm URL|ID | ls [-json]", "manage subscriptions", runFeeds},
+	{"refresh", "[-feed URL]", "fetch all feeds, or one", runRefresh},
+	{"import-opml", "FILE|- [-json]", "subscribe to the feeds of an OPML file", runImportOPML},
+	{"export-opml", "[FILE]", "write the subscriptions as OPML (default: to stdout)", runExportOPML},
+	{"posts", "ls [-unread] [-starred] [-feed URL] [-tag TAG] [-since DATE] [-until DATE] [-oldest] [-limit N] [-json]", "list articles", runPosts},
+	{"mark-read", "[-unread] ID|LINK... | -feed URL | -before DATE | -all", "mark articles read (or unread)", runMarkRead},
+	{"db", "vacuum|check", "compact the database, or check its integrity", runDB},
+	{"config", "print", "show the effective configuration and where each setting comes from", runConfig},
+	{"user", "create -name USERNAME", "create an account, reading the password from stdin; the first becomes the admin", runUser},
+	{"token", "create -name NAME | list | revoke ID", "manage API tokens", runToken},
+	{"export-epub", "[-o FILE] [-feed URL] [-date DAY] ...", "write articles as an EPUB book", runExportEPUB},
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [-config FILE] [-db FILE] [COMMAND] [ARGS]\n\nCommands:\n", os.Args[0])
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.name, c.help)
+	}
+	tw.Flush()
+	fmt.Fprint(out, "\nArguments:\n")
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %s %s\n", c.name, c.args)
+	}
+	fmt.Fprint(out, "\nPer-user commands take -user USERNAME (default: the first user).\n\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// parseArgs parses the flags of a command, which may come before, between
+// or after its other arguments, and returns those arguments.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var rest []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return rest
+		}
+		rest = append(rest, args[0])
+		args = args[1:]
+	}
+}
+
+// userFlag adds the -user flag of per-user commands.
+func userFlag(fs *flag.FlagSet) *string {
+	return fs.String("user", "", "account to act as (default: the first user)")
+}
+
+// cliUser returns the database as the named user, or as the first user.
+func cliUser(username string) (DB, int, error) {
+	if username == "" {
+		return db, defaultUserID, nil
+	}
+	u, _, err := db.UserByName(username)
+	if errors.Is(err, errUserNotFound) {
+		return nil, 0, fmt.Errorf("no user named %q", username)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return db.ForUser(u.ID), u.ID, nil
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// runFeeds implements feeds add, rm and ls.
+func runFeeds(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: feeds add URL [-name NAME] [-folder PATH] | rm URL|ID | ls [-json]")
+	}
+	fs := flag.NewFlagSet("feeds "+args[0], flag.ExitOnError)
+	username := userFlag(fs)
+	name := fs.String("name", "", "feed name (default: the feed's title)")
+	folder := fs.String("folder", "", `folder path such as "Tech/Go", created as needed`)
+	asJSON := fs.Bool("json", false, "print JSON")
+	rest := parseArgs(fs, args[1:])
+	d, _, err := cliUser(*username)
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "add":
+		if len(rest) != 1 || !validFeedURL(rest[0]) {
+			return errors.New("usage: feeds add URL [-name NAME] [-folder PATH], with an http(s) URL")
+		}
+		feedURL := rest[0]
+		if _, ok, err := feedByURL(d, feedURL); err != nil {
+			return err
+		} else if ok {
+			return fmt.Errorf("already subscribed to %s", feedURL)
+		}
+		if *name == "" {
+			*name = FetchFeedTitle(feedURL)
+		}
+		if err := d.AddFeed(feedURL, *name); err != nil {
+			return err
+		}
+		if *folder != "" {
+			if err := d.SetFeedFolder(feedURL, *folder); err != nil {
+				return err
+			}
+		}
+		emitEvent(d, EventFeedAdded, feedEventData{URL: feedURL, Name: *name})
+		fmt.Printf("Added %s (%s)\n", *name, feedURL)
+	case "rm":
+		if len(rest) != 1 {
+			return errors.New("usage: feeds rm URL|ID")
+		}
+		var feed Feed
+		var ok bool
+		if id, err := strconv.Atoi(rest[0]); err == nil {
+			feed, err = d.GetFeed(id)
+			if err != nil && !errors.Is(err, errFeedNotFound) {
+				return err
+			}
+			ok = err == nil
+		} else if feed, ok, err = feedByURL(d, rest[0]); err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("not subscribed to %s", rest[0])
+		}
+		if err := d.RemoveFeed(feed.URL); err != nil {
+			return err
+		}
+		emitEvent(d, EventFeedRemoved, feedEventData{URL: feed.URL, Name: feed.FeedName})
+		fmt.Printf("Removed %s (%s)\n", feed.FeedName, feed.URL)
+	case "ls":
+		feeds, err := d.ListFeeds()
+		if err == nil {
+			feeds, err = withFeedCounts(d, feeds...)
+		}
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			if feeds == nil {
+				feeds = []Feed{}
+			}
+			return printJSON(feeds)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tUNREAD\tTOTAL\tFOLDER\tNAME\tURL")
+		for _, f := range feeds {
+			fmt.Fprintf(tw, "%d\t%d\t%d\t%s\t%s\t%s\n", f.ID, f.Unread, f.Total, f.Folder, f.FeedName, f.URL)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown feeds command %q", args[0])
+	}
+	return nil
+}
+
+// runRefresh fetches every feed anyone subscribes to, or only -feed.
+func runRefresh(args []string) error {
+	fs := flag.NewFlagSet("refresh", flag.ExitOnError)
+	feedURL := fs.String("feed", "", "only refresh this feed URL")
+	if rest := parseArgs(fs, args); len(rest) > 0 {
+		return errors.New("usage: refresh [-feed URL]")
+	}
+	if *feedURL == "" {
+		return RefreshAllFeeds()
+	}
+	feeds, err := db.ListAllFeeds()
+	if err != nil {
+		return err
+	}
+	for _, feed := range feeds {
+		if feed.URL != *feedURL {
+			continue
+		}
+		if err := FetchAndCacheFeed(feed.URL); err != nil {
+			emitFeedEvent(feed.URL, EventFeedFailed, feedEventData{URL: feed.URL, Name: feed.FeedName, Error: err.Error()})
+			return fmt.Errorf("refreshing %s: %w", feed.URL, err)
+		}
+		refreshFavicons([]Feed{feed})
+		return nil
+	}
+	return fmt.Errorf("nobody subscribes to %s", *feedURL)
+}
+
+// runImportOPML subscribes to the feeds of an OPML file, or of stdin
+// with "-".
+func runImportOPML(args []string) error {
+	fs := flag.NewFlagSet("import-opml", flag.ExitOnError)
+	username := userFlag(fs)
+	asJSON := fs.Bool("json", false, "print the import report as JSON")
+	rest := parseArgs(fs, args)
+	if len(rest) != 1 {
+		return errors.New("usage: import-opml FILE|-")
+	}
+	d, _, err := cliUser(*username)
+	if err != nil {
+		return err
+	}
+	var in io.Reader = os.Stdin
+	if rest[0] != "-" {
+		f, err := os.Open(rest[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	report, err := ImportOPML(d, io.LimitReader(in, maxOPMLSize))
+	if err != nil {
+		return fmt.Errorf("invalid OPML: %w", err)
+	}
+	if *asJSON {
+		return printJSON(report)
+	}
+	for _, res := range report.Feeds {
+		if res.Status == "invalid" {
+			fmt.Fprintf(os.Stderr, "Skipped %q: %s\n", res.URL, res.Reason)
+		}
+	}
+	fmt.Printf("Added %d, already subscribed %d, invalid %d\n", report.Added, report.Skipped, report.Invalid)
+	return nil
+}
+
+// runExportOPML writes the subscriptions to a file, or to stdout.
+func runExportOPML(args []string) error {
+	fs := flag.NewFlagSet("export-opml", flag.ExitOnError)
+	username := userFlag(fs)
+	rest := parseArgs(fs, args)
+	if len(rest) > 1 {
+		return errors.New("usage: export-opml [FILE]")
+	}
+	d, _, err := cliUser(*username)
+	if err != nil {
+		return err
+	}
+	feeds, err := d.ListFeeds()
+	if err != nil {
+		return err
+	}
+	folders, err := d.ListFolders()
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 || rest[0] == "-" {
+		return WriteOPML(os.Stdout, folders, feeds)
+	}
+	f, err := os.Create(rest[0])
+	if err != nil {
+		return err
+	}
+	if err := WriteOPML(f, folders, feeds); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runPosts implements posts ls, with the filters of GET /posts.
+func runPosts(args []string) error {
+	if len(args) == 0 || args[0] != "ls" {
+		return errors.New("usage: posts ls [-unread] [-starred] [-feed URL] [-tag TAG] [-since DATE] [-until DATE] [-oldest] [-limit N] [-json]")
+	}
+	fs := flag.NewFlagSet("posts ls", flag.ExitOnError)
+	username := userFlag(fs)
+	unread := fs.Bool("unread", false, "only unread articles")
+	starred := fs.Bool("starred", false, "only starred articles")
+	feed := fs.String("feed", "", "only articles from this feed URL")
+	tag := fs.String("tag", "", "only articles with this tag")
+	since := fs.String("since", "", "only articles on or after this date (YYYY-MM-DD or RFC3339)")
+	until := fs.String("until", "", "only articles before this date (YYYY-MM-DD or RFC3339)")
+	oldest := fs.Bool("oldest", false, "oldest first")
+	limit := fs.Int("limit", apiPageSize, "number of articles, 0 for all")
+	format := fs.String("format", FormatText, "content format with -json: html, markdown or text")
+	asJSON := fs.Bool("json", false, "print JSON, with content")
+	if rest := parseArgs(fs, args[1:]); len(rest) > 0 {
+		return fmt.Errorf("unexpected argument %q", rest[0])
+	}
+	_, user, err := cliUser(*username)
+	if err != nil {
+		return err
+	}
+
+	q := url.Values{}
+	q.Set("unread", strconv.FormatBool(*unread))
+	q.Set("starred", strconv.FormatBool(*starred))
+	q.Set("feed", *feed)
+	q.Set("tag", *tag)
+	q.Set("since", *since)
+	q.Set("until", *until)
+	if *oldest {
+		q.Set("sort", "oldest")
+	}
+	f, err := parsePostsQuery(q)
+	if err != nil {
+		return err
+	}
+	if *limit < 0 {
+		return errors.New("-limit must not be negative")
+	}
+	f.User, f.Limit = user, *limit
+	posts, err := QueryArticles(f)
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case FormatHTML, FormatMarkdown, FormatText:
+	default:
+		return fmt.Errorf("unknown format %q: use html, markdown or text", *format)
+	}
+	articles, err := withState(posts, user, *format)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(articles)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tDATE\tSTATE\tTITLE\tLINK")
+	for _, a := range articles {
+		date := ""
+		if t, ok := articleTime(a.Post); ok {
+			date = t.Local().Format("2006-01-02 15:04")
+		}
+		state := ""
+		if !a.Read {
+			state += "unread "
+		}
+		if a.Starred {
+			state += "starred"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", a.ID, date, strings.TrimSpace(state), a.Title, a.Link)
+	}
+	return tw.Flush()
+}
+
+// runMarkRead marks the articles given by ID or link read, or those
+// selected like POST /read/bulk. Unlike opening an article, it is not
+// recorded in the reading history.
+func runMarkRead(args []string) error {
+	fs := flag.NewFlagSet("mark-read", flag.ExitOnError)
+	username := userFlag(fs)
+	unread := fs.Bool("unread", false, "mark unread instead")
+	feed := fs.String("feed", "", "all articles of this feed URL")
+	before := fs.String("before", "", "all articles published before this date (YYYY-MM-DD or RFC3339)")
+	all := fs.Bool("all", false, "all articles")
+	rest := parseArgs(fs, args)
+	d, user, err := cliUser(*username)
+	if err != nil {
+		return err
+	}
+	f := ArticleFilter{User: user, Feed: *feed}
+	if f.Until, err = parseDateParam(*before); err != nil {
+		return fmt.Errorf("invalid -before: %w", err)
+	}
+	var links []string
+	for _, arg := range rest {
+		if id, err := strconv.Atoi(arg); err == nil {
+			f.IDs = append(f.IDs, id)
+		} else {
+			links = append(links, arg)
+		}
+	}
+	if f.empty() && len(links) == 0 && !*all {
+		return errors.New("usage: mark-read [-unread] ID|LINK... | -feed URL | -before DATE | -all")
+	}
+	if !f.empty() || *all {
+		// Only count the articles that change.
+		f.Unread, f.Read = !*unread, *unread
+		matched, err := matchingLinks(f)
+		if err != nil {
+			return err
+		}
+		links = append(links, matched...)
+	}
+	seen := map[string]bool{}
+	unique := links[:0]
+	for _, link := range links {
+		if !seen[link] {
+			seen[link] = true
+			unique = append(unique, link)
+		}
+	}
+	links = unique
+	if err := d.SetRead(links, !*unread); err != nil {
+		return err
+	}
+	state := "read"
+	if *unread {
+		state = "unread"
+	}
+	fmt.Printf("Marked %d articles %s\n", len(links), state)
+	return nil
+}
+
+// runDB implements db vacuum and db check.
+func runDB(args []string) error {
+	s := db.(*sqliteDB)
+	if len(args) != 1 {
+		return errors.New("usage: db vacuum|check")
+	}
+	switch args[0] {
+	case "vacuum":
+		before, err := s.size()
+		if err != nil {
+			return err
+		}
+		if _, err := s.db.Exec("VACUUM"); err != nil {
+			return err
+		}
+		after, err := s.size()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Vacuumed: %d KiB -> %d KiB\n", before/1024, after/1024)
+	case "check":
+		problems, err := s.integrityCheck()
+		if err != nil {
+			return err
+		}
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("found %d problems", len(problems))
+		}
+		fmt.Println("ok")
+	default:
+		return fmt.Errorf("unknown db command %q", args[0])
+	}
+	return nil
+}
+
+// size returns the size of the database file in bytes.
+func (s *sqliteDB) size() (int64, error) {
+	var pages, pageSize int64
+	if err := s.db.QueryRow("PRAGMA page_count").Scan(&pages); err != nil {
+		return 0, err
+	}
+	err := s.db.QueryRow("PRAGMA page_size").Scan(&pageSize)
+	return pages * pageSize, err
+}
+
+// integrityCheck runs SQLite's integrity check, and that of the search
+// index when there is one, and returns the problems found.
+func (s *sqliteDB) integrityCheck() ([]string, error) {
+	rows, err := s.db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return nil, err
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if searchEnabled {
+		if _, err := s.db.Exec("INSERT INTO articles_fts(articles_fts) VALUES ('integrity-check')"); err != nil {
+			problems = append(problems, "search index: "+err.Error())
+		}
+	}
+	return problems, nil
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the settings of the server and the commands. It is built
+// in layers, each overriding the one before: defaultConfig, a TOML or YAML
+// file, RSS_READER_* environment variables and command-line flags. Keys
+// are the toml tags, joined with dots for sections, e.g. fetch.timeout;
+// the environment variable of a key is RSS_READER_ followed by the key in
+// upper case with dots as underscores, e.g. RSS_READER_FETCH_TIMEOUT.
+type Config struct {
+	Addr        string            `toml:"addr"`
+	DB          string            `toml:"db"`
+	NoAuth      bool              `toml:"no_auth"`
+	Fetch       FetchConfig       `toml:"fetch"`
+	Scheduler   SchedulerConfig   `toml:"scheduler"`
+	SampleFeeds SampleFeedsConfig `toml:"sample_feeds"`
+	LLM         LLMConfig         `toml:"llm"`
+}
+
+// FetchConfig controls how feeds and favicons are downloaded.
+type FetchConfig struct {
+	Timeout    time.Duration `toml:"timeout"`
+	Attempts   int           `toml:"attempts"`
+	RetryDelay time.Duration `toml:"retry_delay"`
+	// UserAgent is sent with feed requests; empty keeps Go's default.
+	UserAgent     string        `toml:"user_agent"`
+	FaviconMaxAge time.Duration `toml:"favicon_max_age"`
+}
+
+// SchedulerConfig sets how often the background jobs run.
+type SchedulerConfig struct {
+	// AlertInterval is how often pending alert matches are checked for
+	// batches that are due.
+	AlertInterval time.Duration `toml:"alert_interval"`
+	// WebhookInterval is how often due webhook deliveries are sent;
+	// WebhookRetry is the delay before the first retry, doubling after
+	// each failure.
+	WebhookInterval time.Duration `toml:"webhook_interval"`
+	WebhookRetry    time.Duration `toml:"webhook_retry"`
+}
+
+// SampleFeedsConfig controls the two built-in demo feeds.
+type SampleFeedsConfig struct {
+	Enabled bool  `toml:"enabled"`
+	Ports   []int `toml:"ports"`
+}
+
+// LLMConfig points at an OpenAI-compatible completions endpoint, such as
+// llama.cpp's server, used for summaries.
+type LLMConfig struct {
+	URL   string `toml:"url"`
+	Model string `toml:"model"`
+}
+
+// configEnvPrefix starts the environment variables of settings.
+const configEnvPrefix = "RSS_READER_"
+
+// configFiles are looked for in the working directory when neither
+// -config nor RSS_READER_CONFIG names a file.
+var configFiles = []string{"rss-reader.toml", "rss-reader.yaml", "rss-reader.yml"}
+
+var (
+	config Config // Effective configuration, set by main
+	// configSources tells where each setting of config came from, for
+	// config print.
+	configSources map[string]string
+	configFile    string
+)
+
+func defaultConfig() Config {
+	return Config{
+		Addr: ":8080",
+		DB:   "./posts.db",
+		Fetch: FetchConfig{
+			Timeout:       30 * time.Second,
+			Attempts:      3,
+			RetryDelay:    500 * time.Millisecond,
+			FaviconMaxAge: 7 * 24 * time.Hour,
+		},
+		Scheduler: SchedulerConfig{
+			AlertInterval:   30 * time.Second,
+			WebhookInterval: 5 * time.Second,
+			WebhookRetry:    30 * time.Second,
+		},
+		SampleFeeds: SampleFeedsConfig{Enabled: true, Ports: []int{8081, 8082}},
+		LLM:         LLMConfig{URL: "http://localhost:8083/v1/completions", Model: "your-model-name"},
+	}
+}
+
+// configSetting is one value from the file, the environment or a flag,
+// in the text form the environment uses.
+type configSetting struct {
+	key, value, source string
+}
+
+// loadConfig builds the configuration from the defaults, the file at
+// path (if empty: $RSS_READER_CONFIG, then configFiles), the environment
+// and flags, and validates it.
+func loadConfig(path string, flags []configSetting) (Config, map[string]string, string, error) {
+	c := defaultConfig()
+	fields, _ := configFields(&c)
+	sources := map[string]string{}
+	for key := range fields {
+		sources[key] = "default"
+	}
+	set := func(s configSetting) error {
+		f, ok := fields[s.key]
+		if !ok {
+			return fmt.Errorf("%s: unknown setting %q", s.source, s.key)
+		}
+		if err := setConfigValue(f, s.value); err != nil {
+			return fmt.Errorf("%s: %s: %v", s.source, s.key, err)
+		}
+		sources[s.key] = s.source
+		return nil
+	}
+
+	if path == "" {
+		path = os.Getenv(configEnvPrefix + "CONFIG")
+	}
+	if path == "" {
+		for _, name := range configFiles {
+			if _, err := os.Stat(name); err == nil {
+				path = name
+				break
+			}
+		}
+	}
+	if path != "" {
+		settings, err := readConfigFile(path)
+		if err != nil {
+			return c, nil, path, err
+		}
+		for _, s := range settings {
+			if err := set(s); err != nil {
+				return c, nil, path, err
+			}
+		}
+	}
+	for key := range fields {
+		name := configEnvName(key)
+		if value, ok := os.LookupEnv(name); ok {
+			if err := set(configSetting{key, value, "env " + name}); err != nil {
+				return c, nil, path, err
+			}
+		}
+	}
+	for _, s := range flags {
+		if err := set(s); err != nil {
+			return c, nil, path, err
+		}
+	}
+	return c, sources, path, c.validate()
+}
+
+func configEnvName(key string) string {
+	return configEnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// readConfigFile reads the settings of a TOML file, or a YAML one if the
+// name ends in .yaml or .yml.
+func readConfigFile(path string) ([]configSetting, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.NewDecoder(f).Decode(&values)
+		if errors.Is(err, io.EOF) {
+			err = nil // an empty file
+		}
+	default:
+		_, err = toml.NewDecoder(f).Decode(&values)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var settings []configSetting
+	var flatten func(prefix string, m map[string]interface{})
+	flatten = func(prefix string, m map[string]interface{}) {
+		for k, v := range m {
+			if sub, ok := v.(map[string]interface{}); ok {
+				flatten(prefix+k+".", sub)
+				continue
+			}
+			settings = append(settings, configSetting{prefix + k, configValueString(v), path})
+		}
+	}
+	flatten("", values)
+	sort.Slice(settings, func(i, j int) bool { return settings[i].key < settings[j].key })
+	return settings, nil
+}
+
+// configValueString turns a value decoded from a file into the text form
+// setConfigValue parses; lists become comma-separated.
+func configValueString(v interface{}) string {
+	if list, ok := v.([]interface{}); ok {
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+// configFields maps the keys of c's settings to their fields, and returns
+// the keys in the order Config declares them.
+func configFields(c *Config) (map[string]reflect.Value, []string) {
+	fields := map[string]reflect.Value{}
+	var keys []string
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			key := prefix + t.Field(i).Tag.Get("toml")
+			if t.Field(i).Type.Kind() == reflect.Struct {
+				walk(key+".", v.Field(i))
+				continue
+			}
+			fields[key] = v.Field(i)
+			keys = append(keys, key)
+		}
+	}
+	walk("", reflect.ValueOf(c).Elem())
+	return fields, keys
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setConfigValue(f reflect.Value, s string) error {
+	s = strings.TrimSpace(s)
+	switch {
+	case f.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q, use e.g. 30s, 5m or 1h", s)
+		}
+		f.SetInt(int64(d))
+	case f.Kind() == reflect.String:
+		f.SetString(s)
+	case f.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		f.SetBool(b)
+	case f.Kind() == reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		f.SetInt(int64(n))
+	case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.Int:
+		var list []int
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			n, err := strconv.Atoi(item)
+			if err != nil {
+				return fmt.Errorf("invalid integer %q in list", item)
+			}
+			list = append(list, n)
+		}
+		f.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported setting type %s", f.Type())
+	}
+	return nil
+}
+
+// validate reports every invalid setting at once.
+func (c Config) validate() error {
+	var problems []string
+	check := func(ok bool, key, msg string) {
+		if !ok {
+			problems = append(problems, key+": "+msg)
+		}
+	}
+	_, _, err := net.SplitHostPort(c.Addr)
+	check(err == nil, "addr", "must be host:port or :port, e.g. :8080")
+	check(!c.NoAuth || loopbackAddr(c.Addr), "no_auth", fmt.Sprintf("needs a loopback addr such as 127.0.0.1:8080, not %q", c.Addr))
+	check(c.DB != "", "db", "must not be empty")
+	check(c.Fetch.Timeout > 0, "fetch.timeout", "must be positive")
+	check(c.Fetch.Attempts >= 1, "fetch.attempts", "must be at least 1")
+	check(c.Fetch.RetryDelay >= 0, "fetch.retry_delay", "must not be negative")
+	check(c.Fetch.FaviconMaxAge > 0, "fetch.favicon_max_age", "must be positive")
+	check(c.Scheduler.AlertInterval > 0, "scheduler.alert_interval", "must be positive")
+	check(c.Scheduler.WebhookInterval > 0, "scheduler.webhook_interval", "must be positive")
+	check(c.Scheduler.WebhookRetry > 0, "scheduler.webhook_retry", "must be positive")
+	if c.SampleFeeds.Enabled {
+		ports := c.SampleFeeds.Ports
+		check(len(ports) == 2, "sample_feeds.ports", "needs two ports, one per sample feed")
+		for _, p := range ports {
+			check(p > 0 && p < 65536, "sample_feeds.ports", fmt.Sprintf("%d is not a port", p))
+		}
+		check(len(ports) != 2 || ports[0] != ports[1], "sample_feeds.ports", "must be different")
+	}
+	check(c.LLM.URL == "" || validFeedURL(c.LLM.URL), "llm.url", "must be an http(s) URL, or empty to turn summaries off")
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// runConfig implements config print, which writes the effective
+// configuration as TOML, each setting commented with where it came from.
+func runConfig(args []string) error {
+	if len(args) != 1 || args[0] != "print" {
+		return errors.New("usage: config print")
+	}
+	file := configFile
+	if file == "" {
+		file = "none"
+	}
+	fmt.Printf("# Effective configuration (file: %s)\n", file)
+	c := config
+	fields, keys := configFields(&c)
+	section := ""
+	for _, key := range keys {
+		name := key
+		if s, k, ok := strings.Cut(key, "."); ok {
+			if s != section {
+				section = s
+				fmt.Printf("\n[%s]\n", s)
+			}
+			name = k
+		}
+		fmt.Printf("%s = %s  # %s\n", name, tomlValue(fields[key]), configSources[key])
+	}
+	return nil
+}
+
+func tomlValue(f reflect.Value) string {
+	switch {
+	case f.Type() == durationType:
+		return strconv.Quote(time.Duration(f.Int()).String())
+	case f.Kind() == reflect.String:
+		return strconv.Quote(f.String())
+	case f.Kind() == reflect.Slice:
+		items := make([]string, f.Len())
+		for i := range items {
+			items[i] = fmt.Sprint(f.Index(i).Interface())
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return fmt.Sprint(f.Interface())
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+)
+
+// FeedCount is the number of visible and unread articles of one feed.
+type FeedCount struct {
+	URL    string `json:"url"`
+	Unread int    `json:"unread"`
+	Total  int    `json:"total"`
+}
+
+// ArticleCounts is returned by GET /counts. Unread and Total also include
+// the user's starred articles kept after their feed was removed.
+type ArticleCounts struct {
+	Unread int         `json:"unread"`
+	Total  int         `json:"total"`
+	Feeds  []FeedCount `json:"feeds"`
+}
+
+func migrateCounts(db *sql.DB) error {
+	// Lets the per-feed counts group articles and look up their read and
+	// hidden state without reading the article rows.
+	_, err := db.Exec(`CREATE INDEX IF NOT EXISTS articles_source ON articles(source, link)`)
+	return err
+}
+
+// CountsBySource returns the user's visible and unread article counts
+// keyed by article source (feed URL). Hidden articles are not counted.
+func (s *sqliteDB) CountsBySource() (map[string]FeedCount, error) {
+	rows, err := s.db.Query(`
+	SELECT articles.source, COUNT(*), COUNT(*) - COUNT(read_articles.link)
+	FROM articles
+	LEFT JOIN read_articles ON read_articles.link = articles.link AND read_articles.user_id = ?
+	LEFT JOIN hidden_articles ON hidden_articles.link = articles.link AND hidden_articles.user_id = ?
+	WHERE hidden_articles.link IS NULL AND (articles.source IN (SELECT url FROM feeds WHERE user_id = ?)
+		OR articles.link IN (SELECT link FROM starred_articles WHERE user_id = ?))
+	GROUP BY articles.source`, s.userID(), s.userID(), s.userID(), s.userID())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := map[string]FeedCount{}
+	for rows.Next() {
+		var source sql.NullString
+		var c FeedCount
+		if err := rows.Scan(&source, &c.Total, &c.Unread); err != nil {
+			return nil, err
+		}
+		c.URL = source.String
+		counts[c.URL] = c
+	}
+	return counts, rows.Err()
+}
+
+// articleCounts returns the counts of every feed the user of d subscribes
+// to, in the order of ListFeeds, and the totals over all their articles.
+func articleCounts(d DB) (ArticleCounts, error) {
+	out := ArticleCounts{Feeds: []FeedCount{}}
+	feeds, err := d.ListFeeds()
+	if err != nil {
+		return out, err
+	}
+	bySource, err := d.CountsBySource()
+	if err != nil {
+		return out, err
+	}
+	for _, c := range bySource {
+		out.Unread += c.Unread
+		out.Total += c.Total
+	}
+	for _, f := range feeds {
+		c := bySource[f.URL]
+		c.URL = f.URL
+		out.Feeds = append(out.Feeds, c)
+	}
+	return out, nil
+}
+
+// countsHandler serves GET /counts.
+func countsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	counts, err := articleCounts(userDB(r))
+	if err != nil {
+		http.Error(w, "Failed to count articles", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(counts)
+}
@@ -2,16 +2,25 @@ package main
 
 import (
 	"database/sql"
+	"errors"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+var errFeedNotFound = errors.New("feed not found")
+
 // Add Feed struct
 // Feed represents an RSS feed source
 type Feed struct {
 	ID       int    `json:"id"`
 	URL      string `json:"url"`
 	FeedName string `json:"feed_name"`
+	FolderID *int   `json:"folder_id"`
+	Folder   string `json:"folder,omitempty"` // folder path, e.g. "Tech/Go"
+	// Article counts, filled in by GET /feeds.
+	Unread int `json:"unread"`
+	Total  int `json:"total"`
 }
 
 // DB is an interface for database operations.
@@ -23,15 +32,129 @@ type DB interface {
 	AddFeed(url string, name string) error
 	RemoveFeed(url string) error
 	ListFeeds() ([]Feed, error)
+	GetFeed(id int) (Feed, error)
+	ListAllFeeds() ([]Feed, error)
+	SetFeedFolder(url, path string) error
+	MoveFeed(url string, folderID *int) error
+	RenameFeed(url, name string) error
+	CountsBySource() (map[string]FeedCount, error)
+	// Folders
+	ListFolders() ([]Folder, error)
+	CreateFolder(name string, parentID *int) (Folder, error)
+	UpdateFolder(f Folder) error
+	ReorderFolders(parentID *int, ids []int) error
+	DeleteFolder(id int) error
+	// Tags
+	TagArticle(link, tag string) error
+	UntagArticle(link, tag string) error
+	ListTags() ([]Tag, error)
+	RenameTag(oldName, newName string) error
+	DeleteTag(name string) error
+	ArticleTags() (map[string][]string, error)
+	// Read-later queue
+	Enqueue(item QueueItem, front bool) (QueueItem, error)
+	ListQueue() ([]QueueItem, error)
+	PopQueue() (QueueItem, error)
+	RemoveFromQueue(id int) error
+	ReorderQueue(ids []int) error
+	// Saved searches
+	ListSavedSearches() ([]SavedSearch, error)
+	GetSavedSearch(id int) (SavedSearch, error)
+	CreateSavedSearch(name, query string) (SavedSearch, error)
+	UpdateSavedSearch(ss SavedSearch) error
+	DeleteSavedSearch(id int) error
+	// Rules applied to new articles
+	ListRules() ([]Rule, error)
+	CreateRule(r Rule) (Rule, error)
+	UpdateRule(r Rule) error
+	DeleteRule(id int) error
+	// Keyword alerts and their notification channels
+	ListAlerts() ([]Alert, error)
+	ListAllAlerts() ([]Alert, error)
+	CreateAlert(a Alert) (Alert, error)
+	UpdateAlert(a Alert) error
+	DeleteAlert(id int) error
+	RecordAlertMatch(m AlertMatch) error
+	PendingAlertMatches() ([]AlertMatch, error)
+	FinishAlertMatches(ids []int, status, errMsg string) error
+	AlertHistory(alertID, limit int) ([]AlertMatch, error)
+	ListNotifyChannels() ([]NotifyChannel, error)
+	CreateNotifyChannel(ch NotifyChannel) (NotifyChannel, error)
+	UpdateNotifyChannel(ch NotifyChannel) error
+	DeleteNotifyChannel(id int) error
+	// Outgoing webhooks and their delivery queue
+	ListWebhooks() ([]Webhook, error)
+	ListAllWebhooks() ([]Webhook, error)
+	CreateWebhook(h Webhook) (Webhook, error)
+	UpdateWebhook(h Webhook) error
+	DeleteWebhook(id int) error
+	QueueWebhookDelivery(webhookID int, event string, payload []byte) error
+	DueWebhookDeliveries(limit int) ([]WebhookDelivery, error)
+	UpdateWebhookDelivery(d WebhookDelivery) error
+	RetryWebhookDelivery(id int) error
+	WebhookDeliveryLog(webhookID, limit int) ([]WebhookDelivery, error)
+	// Highlights and notes
+	AddHighlight(h Highlight) (Highlight, error)
+	SetHighlightNote(id int, note string) error
+	DeleteHighlight(id int) error
+	ListHighlights(link string) ([]Highlight, error)
+	SetArticleNote(link, note string) error
+	ArticleNotes(link string) (map[string]string, error)
 	// Add to DB interface
 	MarkRead(link string) error
 	MarkUnread(link string) error
 	ListRead() ([]string, error)
+	SetRead(links []string, read bool) error
+	ApplyReadOperation(op ReadOperation) error
+	UndoReadOperation(token string, notBefore time.Time) (ReadOperation, error)
+	PruneReadOperations(t time.Time) error
+	// Reading history
+	RecordRead(link string, seconds int, t time.Time) error
+	History(from, to time.Time) ([]HistoryEntry, error)
+	HistoryBounds(t time.Time) (before, after string, err error)
+	DeleteHistory(id int, before time.Time) error
+	// Accounts and sessions; the other methods act for the user given to
+	// ForUser (defaultUserID unless set)
+	ForUser(id int) DB
+	CountUsers() (int, error)
+	CreateUser(username, passwordHash string) (User, error)
+	UserByName(username string) (User, string, error)
+	ListUsers() ([]User, error)
+	SetPassword(id int, passwordHash string) error
+	DeleteUser(id int) error
+	CreateSession(token string, userID int, expires time.Time) error
+	SessionUser(token string) (User, error)
+	DeleteSession(token string) error
+	// API tokens, for clients that can't hold a session cookie
+	CreateAPIToken(name string) (APIToken, error)
+	RotateAPIToken(name string, keep int) (APIToken, error)
+	ListAPITokens() ([]APIToken, error)
+	RevokeAPIToken(id int) error
+	APITokenUser(token string) (User, error)
+	// Fever API
+	SetFeverKey(apiKey string) error
+	FeverKeyUser(apiKey string) (int, error)
+	ListFavicons() ([]Favicon, error)
+	SaveFavicon(host, mimeType string, data []byte) error
+	// Starred articles are kept when their feed is removed
+	Star(link string) error
+	Unstar(link string) error
+	ListStarred() ([]string, error)
+	// Hidden articles are left out of listings unless asked for
+	Hide(link string) error
+	Unhide(link string) error
+	ListHidden() ([]string, error)
+	// Key/value settings
+	GetSetting(key string) (string, error)
+	SetSetting(key, value string) error
 }
 
 // sqliteDB implements DB using SQLite.
 type sqliteDB struct {
 	db *sql.DB
+	// user whose subscriptions and read/star state the methods use; see
+	// userID and ForUser.
+	user int
 }
 
 // NewSQLiteDB creates a new SQLite database and returns a DB interface.
@@ -54,8 +177,10 @@ func NewSQLiteDB(path string) (DB, error) {
 	createFeeds := `
 	CREATE TABLE IF NOT EXISTS feeds (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		url TEXT UNIQUE,
-		feed_name TEXT
+		user_id INTEGER NOT NULL DEFAULT 1,
+		url TEXT,
+		feed_name TEXT,
+		UNIQUE (user_id, url)
 	);`
 	_, err = db.Exec(createFeeds)
 	if err != nil {
@@ -63,12 +188,29 @@ func NewSQLiteDB(path string) (DB, error) {
 	}
 	createRead := `
 	CREATE TABLE IF NOT EXISTS read_articles (
-		link TEXT PRIMARY KEY
+		user_id INTEGER NOT NULL DEFAULT 1,
+		link TEXT NOT NULL,
+		PRIMARY KEY (user_id, link)
 	);`
 	_, err = db.Exec(createRead)
 	if err != nil {
 		return nil, err
 	}
+	createStarred := `
+	CREATE TABLE IF NOT EXISTS starred_articles (
+		user_id INTEGER NOT NULL DEFAULT 1,
+		link TEXT NOT NULL,
+		starred_at TEXT,
+		PRIMARY KEY (user_id, link)
+	);`
+	_, err = db.Exec(createStarred)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(createHidden)
+	if err != nil {
+		return nil, err
+	}
 
 	const createArticlesTableSQL = `
 		CREATE TABLE IF NOT EXISTS articles (
@@ -89,10 +231,117 @@ func NewSQLiteDB(path string) (DB, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err = addColumn(db, "articles", "author", "TEXT"); err != nil {
+		return nil, err
+	}
+	if err = addColumn(db, "articles", "categories", "TEXT"); err != nil {
+		return nil, err
+	}
+	// Matches the sort order of QueryArticles, so pages are read off the index.
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS articles_date ON articles(COALESCE(NULLIF(pubdate, ''), fetched_at), id)`)
+	if err != nil {
+		return nil, err
+	}
+
+	createSettings := `
+	CREATE TABLE IF NOT EXISTS settings (
+		key TEXT PRIMARY KEY,
+		value TEXT
+	);`
+	_, err = db.Exec(createSettings)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = migrateUsers(db); err != nil {
+		return nil, err
+	}
+	if err = migrateAPITokens(db); err != nil {
+		return nil, err
+	}
+	if err = migrateFever(db); err != nil {
+		return nil, err
+	}
+	if err = migrateFolders(db); err != nil {
+		return nil, err
+	}
+	if err = migrateTags(db); err != nil {
+		return nil, err
+	}
+	if err = migrateQueue(db); err != nil {
+		return nil, err
+	}
+	if err = migrateHighlights(db); err != nil {
+		return nil, err
+	}
+	if err = migrateSearch(db); err != nil {
+		return nil, err
+	}
+	if err = migrateSavedSearches(db); err != nil {
+		return nil, err
+	}
+	if err = migrateRules(db); err != nil {
+		return nil, err
+	}
+	if err = migrateAlerts(db); err != nil {
+		return nil, err
+	}
+	if err = migrateWebhooks(db); err != nil {
+		return nil, err
+	}
+	if err = migrateCounts(db); err != nil {
+		return nil, err
+	}
+	if err = migrateReadOperations(db); err != nil {
+		return nil, err
+	}
+	if err = migrateHistory(db); err != nil {
+		return nil, err
+	}
 
 	return &sqliteDB{db: db}, nil
 }
 
+// createHidden is the hidden_articles table; see also migrateUsers.
+const createHidden = `
+	CREATE TABLE IF NOT EXISTS hidden_articles (
+		user_id INTEGER NOT NULL DEFAULT 1,
+		link TEXT NOT NULL,
+		PRIMARY KEY (user_id, link)
+	);`
+
+// hasColumn reports whether table has the named column.
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// addColumn adds a column to an existing table unless it is already there,
+// so databases created by older versions pick up new fields.
+func addColumn(db *sql.DB, table, column, definition string) error {
+	exists, err := hasColumn(db, table, column)
+	if err != nil || exists {
+		return err
+	}
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
 func (s *sqliteDB) InsertPost(post Post) error {
 	_, err := s.db.Exec("INSERT OR IGNORE INTO posts (title, link, description) VALUES (?, ?, ?)", post.Title, post.Link, post.Description)
 	return err
@@ -118,22 +367,58 @@ func (s *sqliteDB) GetAllPosts() ([]Post, error) {
 
 // Feed management methods
 func (s *sqliteDB) AddFeed(url, name string) error {
-	_, err := s.db.Exec("INSERT OR IGNORE INTO feeds (url, feed_name) VALUES (?, ?)", url, name)
+	_, err := s.db.Exec("INSERT OR IGNORE INTO feeds (user_id, url, feed_name) VALUES (?, ?, ?)", s.userID(), url, name)
 	return err
 }
 
 func (s *sqliteDB) RemoveFeed(url string) error {
-	_, err := s.db.Exec("DELETE FROM feeds WHERE url = ?", url)
+	_, err := s.db.Exec("DELETE FROM feeds WHERE user_id = ? AND url = ?", s.userID(), url)
 	if err != nil {
 		return err
 	}
-	// Also delete articles from this feed
-	_, err = s.db.Exec("DELETE FROM articles WHERE source = ?", url)
+	// Also delete articles from this feed once nobody subscribes to it,
+	// except ones starred by anyone
+	_, err = s.db.Exec(`DELETE FROM articles WHERE source = ? AND source NOT IN (SELECT url FROM feeds)
+		AND link NOT IN (SELECT link FROM starred_articles)`, url)
 	return err
 }
 
+// ListFeeds returns the feeds the user subscribes to.
 func (s *sqliteDB) ListFeeds() ([]Feed, error) {
-	rows, err := s.db.Query("SELECT id, url, feed_name FROM feeds")
+	return s.listFeeds("SELECT id, url, feed_name, folder_id FROM feeds WHERE user_id = ?", s.userID())
+}
+
+// GetFeed returns one of the user's feeds by ID.
+func (s *sqliteDB) GetFeed(id int) (Feed, error) {
+	feeds, err := s.listFeeds("SELECT id, url, feed_name, folder_id FROM feeds WHERE user_id = ? AND id = ?", s.userID(), id)
+	if err != nil {
+		return Feed{}, err
+	}
+	if len(feeds) == 0 {
+		return Feed{}, errFeedNotFound
+	}
+	return feeds[0], nil
+}
+
+// ListAllFeeds returns every feed subscribed by anyone, once, with the name
+// and folder of its first subscription. This is what gets refreshed.
+func (s *sqliteDB) ListAllFeeds() ([]Feed, error) {
+	return s.listFeeds(`SELECT id, url, feed_name, folder_id FROM feeds
+		WHERE id IN (SELECT MIN(id) FROM feeds GROUP BY url) ORDER BY id`)
+}
+
+func (s *sqliteDB) listFeeds(query string, args ...interface{}) ([]Feed, error) {
+	// Every user's folders, as ListAllFeeds mixes subscriptions.
+	folders, err := s.listFolders("")
+	if err != nil {
+		return nil, err
+	}
+	paths := map[int]string{}
+	for _, f := range folders {
+		paths[f.ID] = f.Path
+	}
+
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -141,24 +426,98 @@ func (s *sqliteDB) ListFeeds() ([]Feed, error) {
 	var feeds []Feed
 	for rows.Next() {
 		var f Feed
-		if err := rows.Scan(&f.ID, &f.URL, &f.FeedName); err != nil {
+		var folderID sql.NullInt64
+		if err := rows.Scan(&f.ID, &f.URL, &f.FeedName, &folderID); err != nil {
 			return nil, err
 		}
+		if folderID.Valid {
+			id := int(folderID.Int64)
+			f.FolderID = &id
+			f.Folder = paths[id]
+		}
 		feeds = append(feeds, f)
 	}
 	return feeds, nil
 }
 
+// SetFeedFolder moves a feed into the folder at the "/"-separated path,
+// creating folders as needed. An empty path moves it to the top level.
+func (s *sqliteDB) SetFeedFolder(url, path string) error {
+	folderID, err := s.ensureFolderPath(path)
+	if err != nil {
+		return err
+	}
+	return s.MoveFeed(url, folderID)
+}
+
+// MoveFeed files a feed in one of the user's folders, or at the top level if
+// folderID is nil.
+func (s *sqliteDB) MoveFeed(url string, folderID *int) error {
+	if folderID != nil {
+		if _, err := s.getFolder(*folderID); err != nil {
+			return err
+		}
+	}
+	_, err := s.db.Exec("UPDATE feeds SET folder_id = ? WHERE user_id = ? AND url = ?", folderID, s.userID(), url)
+	return err
+}
+
+func (s *sqliteDB) RenameFeed(url, name string) error {
+	_, err := s.db.Exec("UPDATE feeds SET feed_name = ? WHERE user_id = ? AND url = ?", name, s.userID(), url)
+	return err
+}
+
 func (s *sqliteDB) MarkRead(link string) error {
-	_, err := s.db.Exec("INSERT OR IGNORE INTO read_articles (link) VALUES (?)", link)
+	_, err := s.db.Exec("INSERT OR IGNORE INTO read_articles (user_id, link) VALUES (?, ?)", s.userID(), link)
 	return err
 }
 func (s *sqliteDB) MarkUnread(link string) error {
-	_, err := s.db.Exec("DELETE FROM read_articles WHERE link = ?", link)
+	_, err := s.db.Exec("DELETE FROM read_articles WHERE user_id = ? AND link = ?", s.userID(), link)
 	return err
 }
 func (s *sqliteDB) ListRead() ([]string, error) {
-	rows, err := s.db.Query("SELECT link FROM read_articles")
+	return s.listLinks("SELECT link FROM read_articles WHERE user_id = ?", s.userID())
+}
+
+func (s *sqliteDB) Star(link string) error {
+	_, err := s.db.Exec("INSERT OR IGNORE INTO starred_articles (user_id, link, starred_at) VALUES (?, ?, ?)",
+		s.userID(), link, time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+// Unstar removes the star. An article kept only because it was starred
+// (nobody subscribes to its feed anymore) is deleted along with the last star.
+func (s *sqliteDB) Unstar(link string) error {
+	_, err := s.db.Exec("DELETE FROM starred_articles WHERE user_id = ? AND link = ?", s.userID(), link)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`DELETE FROM articles WHERE link = ? AND source NOT IN (SELECT url FROM feeds)
+		AND link NOT IN (SELECT link FROM starred_articles)`, link)
+	return err
+}
+
+func (s *sqliteDB) ListStarred() ([]string, error) {
+	return s.listLinks("SELECT link FROM starred_articles WHERE user_id = ? ORDER BY starred_at DESC", s.userID())
+}
+
+func (s *sqliteDB) Hide(link string) error {
+	_, err := s.db.Exec("INSERT OR IGNORE INTO hidden_articles (user_id, link) VALUES (?, ?)", s.userID(), link)
+	return err
+}
+
+func (s *sqliteDB) Unhide(link string) error {
+	_, err := s.db.Exec("DELETE FROM hidden_articles WHERE user_id = ? AND link = ?", s.userID(), link)
+	return err
+}
+
+func (s *sqliteDB) ListHidden() ([]string, error) {
+	return s.listLinks("SELECT link FROM hidden_articles WHERE user_id = ?", s.userID())
+}
+
+// listLinks runs a query selecting a single link column.
+func (s *sqliteDB) listLinks(query string, args ...interface{}) ([]string, error) {
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -174,6 +533,21 @@ func (s *sqliteDB) ListRead() ([]string, error) {
 	return links, nil
 }
 
+// GetSetting returns the stored value for key, or "" if it isn't set.
+func (s *sqliteDB) GetSetting(key string) (string, error) {
+	var value string
+	err := s.db.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+func (s *sqliteDB) SetSetting(key, value string) error {
+	_, err := s.db.Exec("INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value", key, value)
+	return err
+}
+
 func (s *sqliteDB) Close() error {
 	return s.db.Close()
 }
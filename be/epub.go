@@ -0,0 +1,659 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// maxEPUBImageSize caps each embedded image so one huge file can't bloat the book.
+const maxEPUBImageSize = 5 << 20
+
+// errNoArticles is returned when an export selection matches nothing.
+var errNoArticles = errors.New("no articles match the selection")
+
+var (
+	xmlAttrNameRe  = regexp.MustCompile(`^[a-zA-Z_][-a-zA-Z0-9_.]*$`)
+	invalidXMLChar = regexp.MustCompile("[\x00-\x08\x0B\x0C\x0E-\x1F]")
+	slugRe         = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// epubOptions controls which articles go into an exported book and how.
+type epubOptions struct {
+	Title  string
+	Filter ArticleFilter
+	Parse  bool // fetch full text with go-readability instead of stored content
+	Images bool // download and embed images referenced by articles
+}
+
+// parseExportOptions builds epubOptions from query-style parameters. It is
+// shared by GET /export/epub and the export-epub command.
+//
+//	feed   feed URL
+//	since  start date (RFC3339 or YYYY-MM-DD), inclusive
+//	until  end date (RFC3339 or YYYY-MM-DD), exclusive
+//	date   a single day (YYYY-MM-DD); produces a daily digest
+//	unread only unread articles when "1" or "true"
+//	ids    comma-separated article IDs
+//	parse  re-fetch full article text when "1" or "true"
+//	images "0" or "false" to skip embedding images
+//	title  book title
+func parseExportOptions(get func(string) string) (epubOptions, error) {
+	opts := epubOptions{Title: get("title"), Images: true}
+	f := &opts.Filter
+	var err error
+
+	f.Feed = get("feed")
+	if f.IDs, err = parseIDList(get("ids")); err != nil {
+		return opts, err
+	}
+	if f.Since, err = parseDateParam(get("since")); err != nil {
+		return opts, fmt.Errorf("invalid since: %w", err)
+	}
+	if f.Until, err = parseDateParam(get("until")); err != nil {
+		return opts, fmt.Errorf("invalid until: %w", err)
+	}
+	if day := get("date"); day != "" {
+		start, err := time.Parse("2006-01-02", day)
+		if err != nil {
+			return opts, fmt.Errorf("invalid date: %w", err)
+		}
+		f.Since, f.Until = start, start.AddDate(0, 0, 1)
+		if opts.Title == "" {
+			opts.Title = "Daily digest – " + day
+		}
+	}
+	f.Unread = isTrue(get("unread"))
+	opts.Parse = isTrue(get("parse"))
+	if v := get("images"); v == "0" || strings.EqualFold(v, "false") {
+		opts.Images = false
+	}
+	if opts.Title == "" {
+		opts.Title = "RSS Reader export – " + time.Now().Format("2006-01-02")
+	}
+	return opts, nil
+}
+
+// parseIDList parses a comma-separated list of article IDs.
+func parseIDList(s string) ([]int, error) {
+	var ids []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid article id %q", part)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// parseDateParam accepts RFC3339 timestamps or plain YYYY-MM-DD dates.
+func parseDateParam(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+func isTrue(s string) bool {
+	return s == "1" || strings.EqualFold(s, "true")
+}
+
+// exportEPUBHandler serves GET /export/epub.
+func exportEPUBHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	opts, err := parseExportOptions(r.URL.Query().Get)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.Filter.User = requestUserID(r)
+	// Build in memory so failures can still be reported with a status code.
+	var buf bytes.Buffer
+	if err := WriteEPUB(&buf, opts); err != nil {
+		if errors.Is(err, errNoArticles) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to build EPUB: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/epub+zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": epubFilename(opts.Title),
+	}))
+	w.Write(buf.Bytes())
+}
+
+// runExportEPUB implements the export-epub command, writing a book to disk.
+func runExportEPUB(args []string) error {
+	fs := flag.NewFlagSet("export-epub", flag.ExitOnError)
+	username := userFlag(fs)
+	out := fs.String("o", "", "output file (default: derived from the title)")
+	feed := fs.String("feed", "", "only articles from this feed URL")
+	since := fs.String("since", "", "only articles on or after this date (YYYY-MM-DD or RFC3339)")
+	until := fs.String("until", "", "only articles before this date (YYYY-MM-DD or RFC3339)")
+	date := fs.String("date", "", "daily digest for this day (YYYY-MM-DD)")
+	ids := fs.String("ids", "", "comma-separated article IDs")
+	title := fs.String("title", "", "book title")
+	unread := fs.Bool("unread", false, "only unread articles")
+	parse := fs.Bool("parse", false, "fetch full article text instead of stored content")
+	noImages := fs.Bool("no-images", false, "don't download and embed images")
+	if rest := parseArgs(fs, args); len(rest) > 0 {
+		return fmt.Errorf("unexpected argument %q", rest[0])
+	}
+	_, user, err := cliUser(*username)
+	if err != nil {
+		return err
+	}
+
+	params := url.Values{}
+	params.Set("feed", *feed)
+	params.Set("since", *since)
+	params.Set("until", *until)
+	params.Set("date", *date)
+	params.Set("ids", *ids)
+	params.Set("title", *title)
+	params.Set("unread", strconv.FormatBool(*unread))
+	params.Set("parse", strconv.FormatBool(*parse))
+	params.Set("images", strconv.FormatBool(!*noImages))
+	opts, err := parseExportOptions(params.Get)
+	if err != nil {
+		return err
+	}
+	opts.Filter.User = user
+
+	path := *out
+	if path == "" {
+		path = epubFilename(opts.Title)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WriteEPUB(f, opts); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Println("Wrote", path)
+	return nil
+}
+
+func epubFilename(title string) string {
+	slug := strings.Trim(slugRe.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	if slug == "" {
+		slug = "articles"
+	}
+	return slug + ".epub"
+}
+
+// epubItem is an entry in the package manifest.
+type epubItem struct {
+	ID        string
+	Href      string
+	MediaType string
+}
+
+// epubChapter is one article in the book.
+type epubChapter struct {
+	Href  string
+	Title string
+}
+
+// epubSection groups the chapters of one feed for the table of contents.
+type epubSection struct {
+	Name     string
+	Chapters []epubChapter
+}
+
+// epubBook accumulates the files of an EPUB as it is written.
+type epubBook struct {
+	zw       *zip.Writer
+	opts     epubOptions
+	client   *http.Client
+	manifest []epubItem
+	images   map[string]string // source URL -> href relative to OEBPS, "" if unusable
+}
+
+// WriteEPUB writes an EPUB 3 book containing the selected articles to w.
+// Articles are grouped by feed in the table of contents.
+func WriteEPUB(w io.Writer, opts epubOptions) error {
+	articles, err := QueryArticles(opts.Filter)
+	if err != nil {
+		return err
+	}
+	if len(articles) == 0 {
+		return errNoArticles
+	}
+	feedNames := map[string]string{}
+	if feeds, err := db.ListAllFeeds(); err == nil {
+		for _, f := range feeds {
+			feedNames[f.URL] = f.FeedName
+		}
+	}
+
+	b := &epubBook{
+		zw:     zip.NewWriter(w),
+		opts:   opts,
+		client: &http.Client{Timeout: 15 * time.Second},
+		images: map[string]string{},
+	}
+
+	// The mimetype entry must come first and be stored uncompressed.
+	mw, err := b.zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	io.WriteString(mw, "application/epub+zip")
+
+	var sections []*epubSection
+	bySource := map[string]*epubSection{}
+	for i, a := range articles {
+		sec, ok := bySource[a.Source]
+		if !ok {
+			name := feedNames[a.Source]
+			if name == "" {
+				name = a.Source
+			}
+			sec = &epubSection{Name: name}
+			bySource[a.Source] = sec
+			sections = append(sections, sec)
+		}
+		ch := epubChapter{Href: fmt.Sprintf("text/a%04d.xhtml", i+1), Title: a.Title}
+		if ch.Title == "" {
+			ch.Title = a.Link
+		}
+		if err := b.writeChapter(ch, a, sec.Name); err != nil {
+			return err
+		}
+		sec.Chapters = append(sec.Chapters, ch)
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return err
+	}
+	if err := b.writeFile("OEBPS/style.css", epubCSS); err != nil {
+		return err
+	}
+	if err := b.writeFile("OEBPS/nav.xhtml", epubNav(opts.Title, sections)); err != nil {
+		return err
+	}
+	if err := b.writeFile("OEBPS/toc.ncx", epubNCX(id, opts.Title, sections)); err != nil {
+		return err
+	}
+	if err := b.writeFile("OEBPS/content.opf", b.packageDocument(id, len(articles), len(sections))); err != nil {
+		return err
+	}
+	if err := b.writeFile("META-INF/container.xml", epubContainer); err != nil {
+		return err
+	}
+	return b.zw.Close()
+}
+
+func (b *epubBook) writeFile(name, content string) error {
+	fw, err := b.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(fw, content)
+	return err
+}
+
+func (b *epubBook) writeChapter(ch epubChapter, a Post, feedName string) error {
+	content, byline := a.Content, ""
+	if b.opts.Parse {
+		if parsed, err := ParseArticleFromURL(a.Link); err == nil {
+			content, byline = parsed.Content, parsed.Byline
+		} else {
+			log.Printf("EPUB export: could not parse %s, using stored content: %v", a.Link, err)
+		}
+	}
+	if content == "" {
+		content = a.Description
+	}
+	base, _ := url.Parse(a.Link)
+
+	meta := []string{feedName}
+	if byline != "" {
+		meta = append(meta, byline)
+	}
+	if a.PubDate != "" {
+		meta = append(meta, a.PubDate)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(xhtmlHeader(ch.Title, "../style.css"))
+	fmt.Fprintf(&sb, "<h1>%s</h1>\n<p class=\"meta\">%s</p>\n", xmlEscape(ch.Title), xmlEscape(strings.Join(meta, " · ")))
+	sb.WriteString(b.xhtmlFragment(content, base))
+	if a.Link != "" {
+		fmt.Fprintf(&sb, "\n<p class=\"source\"><a href=\"%s\">Original article</a></p>\n", xmlEscape(a.Link))
+	}
+	sb.WriteString("</body>\n</html>\n")
+
+	b.manifest = append(b.manifest, epubItem{
+		ID:        strings.TrimSuffix(path.Base(ch.Href), ".xhtml"),
+		Href:      ch.Href,
+		MediaType: "application/xhtml+xml",
+	})
+	return b.writeFile("OEBPS/"+ch.Href, sb.String())
+}
+
+// xhtmlFragment sanitizes article HTML into well-formed XHTML: scripts, forms
+// and comments are dropped, links are made absolute and images are embedded.
+func (b *epubBook) xhtmlFragment(src string, base *url.URL) string {
+	nodes, err := html.ParseFragment(strings.NewReader(src), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+	if err != nil {
+		return "<p>" + xmlEscape(src) + "</p>"
+	}
+	root := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	for _, n := range nodes {
+		root.AppendChild(n)
+	}
+	b.sanitize(root, base)
+
+	var buf bytes.Buffer
+	for c := root.FirstChild; c != nil; c = c.NextSibling {
+		html.Render(&buf, c)
+	}
+	return buf.String()
+}
+
+func (b *epubBook) sanitize(n *html.Node, base *url.URL) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch c.Type {
+		case html.TextNode:
+			c.Data = invalidXMLChar.ReplaceAllString(c.Data, "")
+		case html.ElementNode:
+			switch c.DataAtom {
+			case atom.Script, atom.Style, atom.Noscript, atom.Iframe, atom.Object, atom.Embed,
+				atom.Form, atom.Input, atom.Button, atom.Select, atom.Textarea, atom.Link,
+				atom.Meta, atom.Source, atom.Template:
+				n.RemoveChild(c)
+				c = next
+				continue
+			}
+			if strings.Contains(c.Data, ":") {
+				// Namespaced tags (e.g. <o:p> from Word) aren't valid XHTML; keep their content.
+				for gc := c.FirstChild; gc != nil; {
+					gnext := gc.NextSibling
+					c.RemoveChild(gc)
+					n.InsertBefore(gc, c)
+					gc = gnext
+				}
+				n.RemoveChild(c)
+				c = next
+				continue
+			}
+			c.Attr = cleanAttrs(c.Attr)
+			switch c.DataAtom {
+			case atom.A:
+				setAttr(c, "href", resolveURL(base, attr(c, "href")))
+			case atom.Img:
+				href, ok := b.embedImage(resolveURL(base, attr(c, "src")))
+				if !ok {
+					n.RemoveChild(c)
+					c = next
+					continue
+				}
+				setAttr(c, "src", href)
+				if attr(c, "alt") == "" {
+					setAttr(c, "alt", "")
+				}
+			}
+			b.sanitize(c, base)
+		default:
+			n.RemoveChild(c)
+		}
+		c = next
+	}
+}
+
+// cleanAttrs drops attributes that aren't valid or safe in XHTML.
+func cleanAttrs(attrs []html.Attribute) []html.Attribute {
+	kept := attrs[:0]
+	for _, a := range attrs {
+		key := strings.ToLower(a.Key)
+		if a.Namespace != "" || !xmlAttrNameRe.MatchString(a.Key) ||
+			strings.HasPrefix(key, "on") || strings.HasPrefix(key, "xmlns") ||
+			key == "srcset" || key == "sizes" || key == "loading" || key == "style" {
+			continue
+		}
+		a.Val = invalidXMLChar.ReplaceAllString(a.Val, "")
+		kept = append(kept, a)
+	}
+	return kept
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i := range n.Attr {
+		if n.Attr[i].Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+func resolveURL(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if base == nil || ref == "" {
+		return ref
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return u.String()
+}
+
+// embedImage downloads an image into the book and returns its href relative
+// to a chapter. Failed downloads are remembered so each URL is tried once.
+func (b *epubBook) embedImage(src string) (string, bool) {
+	if !b.opts.Images || !(strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")) {
+		return "", false
+	}
+	if href, ok := b.images[src]; ok {
+		return "../" + href, href != ""
+	}
+	b.images[src] = ""
+
+	resp, err := b.client.Get(src)
+	if err != nil {
+		log.Printf("EPUB export: image %s: %v", src, err)
+		return "", false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("EPUB export: image %s: %s", src, resp.Status)
+		return "", false
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxEPUBImageSize+1))
+	if err != nil || len(data) > maxEPUBImageSize {
+		log.Printf("EPUB export: image %s skipped (unreadable or too large)", src)
+		return "", false
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "image/") {
+		mediaType = http.DetectContentType(data)
+	}
+	ext, ok := epubImageTypes[mediaType]
+	if !ok {
+		return "", false
+	}
+
+	id := fmt.Sprintf("img%04d", len(b.images))
+	href := "images/" + id + ext
+	if err := b.writeFile("OEBPS/"+href, string(data)); err != nil {
+		return "", false
+	}
+	b.manifest = append(b.manifest, epubItem{ID: id, Href: href, MediaType: mediaType})
+	b.images[src] = href
+	return "../" + href, true
+}
+
+// epubImageTypes are the core media types EPUB readers must support.
+var epubImageTypes = map[string]string{
+	"image/jpeg":    ".jpg",
+	"image/png":     ".png",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+	"image/svg+xml": ".svg",
+}
+
+func (b *epubBook) packageDocument(id string, articles, feeds int) string {
+	now := time.Now().UTC()
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid" xml:lang="en">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+`)
+	fmt.Fprintf(&sb, "    <dc:identifier id=\"bookid\">urn:uuid:%s</dc:identifier>\n", id)
+	fmt.Fprintf(&sb, "    <dc:title>%s</dc:title>\n", xmlEscape(b.opts.Title))
+	sb.WriteString("    <dc:language>en</dc:language>\n")
+	sb.WriteString("    <dc:creator>RSS Reader Go</dc:creator>\n")
+	fmt.Fprintf(&sb, "    <dc:description>%d articles from %d feeds</dc:description>\n", articles, feeds)
+	fmt.Fprintf(&sb, "    <dc:date>%s</dc:date>\n", now.Format("2006-01-02"))
+	fmt.Fprintf(&sb, "    <meta property=\"dcterms:modified\">%s</meta>\n", now.Format("2006-01-02T15:04:05Z"))
+	sb.WriteString(`  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+    <item id="css" href="style.css" media-type="text/css"/>
+`)
+	for _, it := range b.manifest {
+		fmt.Fprintf(&sb, "    <item id=\"%s\" href=\"%s\" media-type=\"%s\"/>\n", it.ID, it.Href, it.MediaType)
+	}
+	sb.WriteString("  </manifest>\n  <spine toc=\"ncx\">\n    <itemref idref=\"nav\"/>\n")
+	for _, it := range b.manifest {
+		if it.MediaType == "application/xhtml+xml" {
+			fmt.Fprintf(&sb, "    <itemref idref=\"%s\"/>\n", it.ID)
+		}
+	}
+	sb.WriteString("  </spine>\n</package>\n")
+	return sb.String()
+}
+
+func epubNav(title string, sections []*epubSection) string {
+	var sb strings.Builder
+	sb.WriteString(xhtmlHeader(title, "style.css"))
+	sb.WriteString("<nav epub:type=\"toc\" id=\"toc\">\n<h1>Contents</h1>\n<ol>\n")
+	for _, sec := range sections {
+		fmt.Fprintf(&sb, "<li><span>%s</span>\n<ol>\n", xmlEscape(sec.Name))
+		for _, ch := range sec.Chapters {
+			fmt.Fprintf(&sb, "<li><a href=\"%s\">%s</a></li>\n", ch.Href, xmlEscape(ch.Title))
+		}
+		sb.WriteString("</ol></li>\n")
+	}
+	sb.WriteString("</ol>\n</nav>\n</body>\n</html>\n")
+	return sb.String()
+}
+
+// epubNCX builds the EPUB 2 table of contents, still used by older readers.
+func epubNCX(id, title string, sections []*epubSection) string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <head>
+`)
+	fmt.Fprintf(&sb, "    <meta name=\"dtb:uid\" content=\"urn:uuid:%s\"/>\n", id)
+	sb.WriteString("    <meta name=\"dtb:depth\" content=\"2\"/>\n  </head>\n")
+	fmt.Fprintf(&sb, "  <docTitle><text>%s</text></docTitle>\n  <navMap>\n", xmlEscape(title))
+	order := 0
+	for i, sec := range sections {
+		order++
+		fmt.Fprintf(&sb, "    <navPoint id=\"feed%d\" playOrder=\"%d\">\n", i+1, order)
+		fmt.Fprintf(&sb, "      <navLabel><text>%s</text></navLabel>\n", xmlEscape(sec.Name))
+		fmt.Fprintf(&sb, "      <content src=\"%s\"/>\n", sec.Chapters[0].Href)
+		for _, ch := range sec.Chapters {
+			order++
+			fmt.Fprintf(&sb, "      <navPoint id=\"np%d\" playOrder=\"%d\">\n", order, order)
+			fmt.Fprintf(&sb, "        <navLabel><text>%s</text></navLabel>\n", xmlEscape(ch.Title))
+			fmt.Fprintf(&sb, "        <content src=\"%s\"/>\n      </navPoint>\n", ch.Href)
+		}
+		sb.WriteString("    </navPoint>\n")
+	}
+	sb.WriteString("  </navMap>\n</ncx>\n")
+	return sb.String()
+}
+
+func xhtmlHeader(title, css string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="en" lang="en">
+<head>
+<title>%s</title>
+<link rel="stylesheet" type="text/css" href="%s"/>
+</head>
+<body>
+`, xmlEscape(title), css)
+}
+
+// xmlEscape escapes text for use in XML content and attribute values.
+func xmlEscape(s string) string {
+	return html.EscapeString(invalidXMLChar.ReplaceAllString(s, ""))
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		return "", err
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:]), nil
+}
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+const epubCSS = `body { font-family: serif; line-height: 1.5; }
+h1 { font-size: 1.4em; margin-bottom: 0.2em; }
+p.meta { color: #666; font-size: 0.85em; margin-top: 0; }
+p.source { font-size: 0.85em; margin-top: 2em; }
+img { max-width: 100%; height: auto; }
+pre { white-space: pre-wrap; font-size: 0.85em; }
+blockquote { margin-left: 1em; padding-left: 0.8em; border-left: 3px solid #ccc; }
+`
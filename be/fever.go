@@ -0,0 +1,594 @@
+package main
+
+import (
+	"crypto/md5"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The Fever API (https://feedafever.com/api) lets mobile readers such as
+// Reeder, Unread and ReadKit sync with the server. Clients POST to
+// /fever/?api with api_key = md5("username:password") and name what they
+// want as parameters: groups, feeds, favicons, items, links,
+// unread_item_ids, saved_item_ids, and mark/as/id/before to change state.
+const (
+	feverPath       = "/fever/"
+	feverAPIVersion = 3
+	// feverPageSize is how many items one items request returns, as in Fever.
+	feverPageSize = 50
+)
+
+// Favicons are looked up at /favicon.ico of each feed's host when feeds
+// are refreshed, and again after fetch.favicon_max_age.
+const maxFaviconSize = 100 << 10
+
+var (
+	errFeverKeyNotFound = errors.New("fever api key not found")
+	errFeverKeyTaken    = errors.New("these fever credentials are used by another account")
+)
+
+// Favicon is a feed site's icon, shared by all feeds on the same host.
+// Data is empty when the site has none.
+type Favicon struct {
+	ID   int
+	Host string
+	MIME string
+	Data []byte
+}
+
+func migrateFever(db *sql.DB) error {
+	createFever := `
+	CREATE TABLE IF NOT EXISTS fever_keys (
+		user_id INTEGER PRIMARY KEY,
+		api_key TEXT NOT NULL UNIQUE
+	);
+	CREATE TABLE IF NOT EXISTS favicons (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		host TEXT NOT NULL UNIQUE,
+		mime TEXT NOT NULL DEFAULT '',
+		data BLOB,
+		fetched_at TEXT NOT NULL
+	);`
+	_, err := db.Exec(createFever)
+	return err
+}
+
+// feverAPIKey is the key Fever clients derive from the credentials typed
+// into them.
+func feverAPIKey(username, password string) string {
+	sum := md5.Sum([]byte(username + ":" + password))
+	return hex.EncodeToString(sum[:])
+}
+
+// SetFeverKey sets the user's Fever API key; "" turns Fever access off.
+func (s *sqliteDB) SetFeverKey(apiKey string) error {
+	if apiKey == "" {
+		_, err := s.db.Exec("DELETE FROM fever_keys WHERE user_id = ?", s.userID())
+		return err
+	}
+	var other int
+	err := s.db.QueryRow("SELECT user_id FROM fever_keys WHERE api_key = ? AND user_id != ?", apiKey, s.userID()).Scan(&other)
+	if err == nil {
+		return errFeverKeyTaken
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO fever_keys (user_id, api_key) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET api_key = excluded.api_key`, s.userID(), apiKey)
+	return err
+}
+
+// FeverKeyUser returns the user whose Fever API key is apiKey.
+func (s *sqliteDB) FeverKeyUser(apiKey string) (int, error) {
+	var user int
+	err := s.db.QueryRow("SELECT user_id FROM fever_keys WHERE api_key = ?", apiKey).Scan(&user)
+	if err == sql.ErrNoRows {
+		return 0, errFeverKeyNotFound
+	}
+	return user, err
+}
+
+// ListFavicons returns the stored favicons, including empty ones for sites
+// without an icon.
+func (s *sqliteDB) ListFavicons() ([]Favicon, error) {
+	rows, err := s.db.Query("SELECT id, host, mime, COALESCE(data, '') FROM favicons ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	icons := []Favicon{}
+	for rows.Next() {
+		var f Favicon
+		if err := rows.Scan(&f.ID, &f.Host, &f.MIME, &f.Data); err != nil {
+			return nil, err
+		}
+		icons = append(icons, f)
+	}
+	return icons, rows.Err()
+}
+
+// SaveFavicon stores the icon of host; an empty data records that the
+// site has none, so it isn't asked again before fetch.favicon_max_age.
+func (s *sqliteDB) SaveFavicon(host, mimeType string, data []byte) error {
+	_, err := s.db.Exec(`INSERT INTO favicons (host, mime, data, fetched_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(host) DO UPDATE SET mime = excluded.mime, data = excluded.data, fetched_at = excluded.fetched_at`,
+		host, mimeType, data, time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+// faviconFetchedSince returns the hosts whose favicon was looked up after t.
+func (s *sqliteDB) faviconFetchedSince(t time.Time) (map[string]bool, error) {
+	rows, err := s.db.Query("SELECT host FROM favicons WHERE fetched_at >= ?", t.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hosts := map[string]bool{}
+	for rows.Next() {
+		var host string
+		if err := rows.Scan(&host); err != nil {
+			return nil, err
+		}
+		hosts[host] = true
+	}
+	return hosts, rows.Err()
+}
+
+// feedUpdateTimes returns when each feed's articles were last refreshed,
+// keyed by feed URL.
+func (s *sqliteDB) feedUpdateTimes() (map[string]time.Time, error) {
+	rows, err := s.db.Query("SELECT source, MAX(fetched_at) FROM articles GROUP BY source")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	times := map[string]time.Time{}
+	for rows.Next() {
+		var source, fetchedAt sql.NullString
+		if err := rows.Scan(&source, &fetchedAt); err != nil {
+			return nil, err
+		}
+		if t, err := time.Parse(time.RFC3339, fetchedAt.String); err == nil {
+			times[source.String] = t
+		}
+	}
+	return times, rows.Err()
+}
+
+// refreshFavicons looks up the favicons of the feeds' hosts that have none
+// stored, or an old one. Failures are stored as "no icon".
+func refreshFavicons(feeds []Feed) {
+	fresh, err := db.(*sqliteDB).faviconFetchedSince(time.Now().Add(-config.Fetch.FaviconMaxAge))
+	if err != nil {
+		log.Println("Failed to read favicons:", err)
+		return
+	}
+	for _, f := range feeds {
+		u, err := url.Parse(f.URL)
+		if err != nil || u.Host == "" || fresh[u.Host] {
+			continue
+		}
+		fresh[u.Host] = true
+		mimeType, data := fetchFavicon(u.Scheme + "://" + u.Host + "/favicon.ico")
+		if err := db.SaveFavicon(u.Host, mimeType, data); err != nil {
+			log.Println("Failed to save favicon of", u.Host, err)
+		}
+	}
+}
+
+// fetchFavicon downloads an icon, returning no data if it isn't an image.
+func fetchFavicon(iconURL string) (string, []byte) {
+	resp, err := fetchURL(iconURL)
+	if err != nil {
+		return "", nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFaviconSize+1))
+	if err != nil || len(data) == 0 || len(data) > maxFaviconSize {
+		return "", nil
+	}
+	mimeType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mimeType, "image/") {
+		mimeType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(mimeType, "image/") {
+		return "", nil
+	}
+	return mimeType, data
+}
+
+type feverGroup struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+}
+
+type feverFeedsGroup struct {
+	GroupID int    `json:"group_id"`
+	FeedIDs string `json:"feed_ids"`
+}
+
+type feverFeed struct {
+	ID                int    `json:"id"`
+	FaviconID         int    `json:"favicon_id"`
+	Title             string `json:"title"`
+	URL               string `json:"url"`
+	SiteURL           string `json:"site_url"`
+	IsSpark           int    `json:"is_spark"`
+	LastUpdatedOnTime int64  `json:"last_updated_on_time"`
+}
+
+type feverFavicon struct {
+	ID   int    `json:"id"`
+	Data string `json:"data"`
+}
+
+type feverItem struct {
+	ID            int    `json:"id"`
+	FeedID        int    `json:"feed_id"`
+	Title         string `json:"title"`
+	Author        string `json:"author"`
+	HTML          string `json:"html"`
+	URL           string `json:"url"`
+	IsSaved       int    `json:"is_saved"`
+	IsRead        int    `json:"is_read"`
+	CreatedOnTime int64  `json:"created_on_time"`
+}
+
+// feverBool is how Fever encodes booleans.
+func feverBool(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// joinIDs formats IDs as Fever's comma-separated lists.
+func joinIDs(ids []int) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.Itoa(id)
+	}
+	return strings.Join(s, ",")
+}
+
+// feverHandler serves the Fever API at /fever/?api. Every response is JSON
+// with api_version and auth; auth is 0 when api_key is wrong, and nothing
+// else is returned then.
+func feverHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := r.URL.Query()["api"]; !ok {
+		http.NotFound(w, r)
+		return
+	}
+	resp := map[string]interface{}{"api_version": feverAPIVersion, "auth": 0}
+	w.Header().Set("Content-Type", "application/json")
+	user, err := db.FeverKeyUser(strings.ToLower(r.FormValue("api_key")))
+	if err != nil {
+		if !errors.Is(err, errFeverKeyNotFound) {
+			log.Println("Failed to check fever api key:", err)
+		}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	resp["auth"] = 1
+	if err := fever(r.Form, db.ForUser(user), user, resp); err != nil {
+		log.Println("Fever request failed:", err)
+		http.Error(w, "Fever request failed", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// fever answers an authenticated request for user into resp.
+func fever(form url.Values, d DB, user int, resp map[string]interface{}) error {
+	has := func(key string) bool {
+		_, ok := form[key]
+		return ok
+	}
+	feeds, err := d.ListFeeds()
+	if err != nil {
+		return err
+	}
+	updated, err := db.(*sqliteDB).feedUpdateTimes()
+	if err != nil {
+		return err
+	}
+	var lastRefreshed int64
+	feedIDs := map[string]int{}
+	for _, f := range feeds {
+		feedIDs[f.URL] = f.ID
+		if t, ok := updated[f.URL]; ok && t.Unix() > lastRefreshed {
+			lastRefreshed = t.Unix()
+		}
+	}
+	resp["last_refreshed_on_time"] = lastRefreshed
+
+	if has("mark") {
+		if err := feverMark(form, d, user, feeds); err != nil {
+			return err
+		}
+	}
+
+	if has("groups") || has("feeds") {
+		folders, err := d.ListFolders()
+		if err != nil {
+			return err
+		}
+		groups := []feverGroup{}
+		members := map[int][]int{}
+		for _, f := range folders {
+			groups = append(groups, feverGroup{ID: f.ID, Title: f.Path})
+		}
+		for _, f := range feeds {
+			if f.FolderID != nil {
+				members[*f.FolderID] = append(members[*f.FolderID], f.ID)
+			}
+		}
+		feedsGroups := []feverFeedsGroup{}
+		for _, g := range groups {
+			if ids := members[g.ID]; len(ids) > 0 {
+				feedsGroups = append(feedsGroups, feverFeedsGroup{GroupID: g.ID, FeedIDs: joinIDs(ids)})
+			}
+		}
+		if has("groups") {
+			resp["groups"] = groups
+		}
+		resp["feeds_groups"] = feedsGroups
+	}
+
+	if has("feeds") {
+		icons, err := d.ListFavicons()
+		if err != nil {
+			return err
+		}
+		iconIDs := map[string]int{}
+		for _, icon := range icons {
+			if len(icon.Data) > 0 {
+				iconIDs[icon.Host] = icon.ID
+			}
+		}
+		list := []feverFeed{}
+		for _, f := range feeds {
+			ff := feverFeed{ID: f.ID, Title: f.FeedName, URL: f.URL}
+			if u, err := url.Parse(f.URL); err == nil && u.Host != "" {
+				ff.SiteURL = u.Scheme + "://" + u.Host + "/"
+				ff.FaviconID = iconIDs[u.Host]
+			}
+			if t, ok := updated[f.URL]; ok {
+				ff.LastUpdatedOnTime = t.Unix()
+			}
+			list = append(list, ff)
+		}
+		resp["feeds"] = list
+	}
+
+	if has("favicons") {
+		icons, err := d.ListFavicons()
+		if err != nil {
+			return err
+		}
+		list := []feverFavicon{}
+		for _, icon := range icons {
+			if len(icon.Data) > 0 {
+				list = append(list, feverFavicon{
+					ID:   icon.ID,
+					Data: icon.MIME + ";base64," + base64.StdEncoding.EncodeToString(icon.Data),
+				})
+			}
+		}
+		resp["favicons"] = list
+	}
+
+	if has("items") {
+		items, total, err := feverItems(form, user, feedIDs)
+		if err != nil {
+			return err
+		}
+		resp["items"] = items
+		resp["total_items"] = total
+	}
+
+	if has("links") {
+		// Fever's "hot links" ranking isn't implemented; clients accept an
+		// empty list.
+		resp["links"] = []interface{}{}
+	}
+
+	if has("unread_item_ids") || (has("mark") && form.Get("as") != "saved" && form.Get("as") != "unsaved") {
+		ids, err := articleIDs(ArticleFilter{User: user, Unread: true, ByID: true, Oldest: true})
+		if err != nil {
+			return err
+		}
+		resp["unread_item_ids"] = joinIDs(ids)
+	}
+	if has("saved_item_ids") || (has("mark") && (form.Get("as") == "saved" || form.Get("as") == "unsaved")) {
+		ids, err := articleIDs(ArticleFilter{User: user, Starred: true, ByID: true, Oldest: true})
+		if err != nil {
+			return err
+		}
+		resp["saved_item_ids"] = joinIDs(ids)
+	}
+	return nil
+}
+
+// feverItems returns one page of items: those listed in with_ids (at most
+// 50), the 50 after since_id, or the 50 before max_id, newest first. The
+// first page, without any of them, starts at the oldest item.
+func feverItems(form url.Values, user int, feedIDs map[string]int) ([]feverItem, int, error) {
+	f := ArticleFilter{User: user, ByID: true, Oldest: true, Limit: feverPageSize}
+	switch {
+	case form.Get("with_ids") != "":
+		for _, s := range strings.Split(form.Get("with_ids"), ",") {
+			if id, err := strconv.Atoi(strings.TrimSpace(s)); err == nil && len(f.IDs) < feverPageSize {
+				f.IDs = append(f.IDs, id)
+			}
+		}
+		if len(f.IDs) == 0 {
+			return []feverItem{}, 0, nil
+		}
+	case form.Get("max_id") != "":
+		f.MaxID, _ = strconv.Atoi(form.Get("max_id"))
+		f.Oldest = false
+	default:
+		f.SinceID, _ = strconv.Atoi(form.Get("since_id"))
+	}
+	posts, err := QueryArticles(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := CountArticles(ArticleFilter{User: user})
+	if err != nil {
+		return nil, 0, err
+	}
+	ids := make([]int, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+	}
+	read := map[int]bool{}
+	if len(ids) > 0 {
+		readIDs, err := articleIDs(ArticleFilter{User: user, IDs: ids, Read: true})
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, id := range readIDs {
+			read[id] = true
+		}
+	}
+	items := make([]feverItem, 0, len(posts))
+	for _, p := range posts {
+		html := p.Content
+		if html == "" {
+			html = p.Description
+		}
+		item := feverItem{
+			ID:      p.ID,
+			FeedID:  feedIDs[p.Source],
+			Title:   p.Title,
+			Author:  p.Author,
+			HTML:    html,
+			URL:     p.Link,
+			IsSaved: feverBool(p.Starred),
+			IsRead:  feverBool(read[p.ID]),
+		}
+		if t, ok := articleTime(p); ok {
+			item.CreatedOnTime = t.Unix()
+		}
+		items = append(items, item)
+	}
+	return items, total, nil
+}
+
+// feverMark applies mark=item|feed|group with as=read|unread|saved|unsaved.
+// Feeds and groups can only be marked read, up to the Unix time before;
+// group 0 is every article. Unknown IDs are ignored, as Fever does.
+func feverMark(form url.Values, d DB, user int, feeds []Feed) error {
+	id, _ := strconv.Atoi(form.Get("id"))
+	as := form.Get("as")
+	switch form.Get("mark") {
+	case "item":
+		posts, err := QueryArticles(ArticleFilter{User: user, IDs: []int{id}, WithHidden: true})
+		if err != nil || len(posts) == 0 {
+			return err
+		}
+		link := posts[0].Link
+		switch as {
+		case "read":
+			return d.MarkRead(link)
+		case "unread":
+			return d.MarkUnread(link)
+		case "saved":
+			return d.Star(link)
+		case "unsaved":
+			return d.Unstar(link)
+		}
+	case "feed", "group":
+		if as != "read" {
+			return nil
+		}
+		f := ArticleFilter{User: user, Unread: true}
+		if before, err := strconv.ParseInt(form.Get("before"), 10, 64); err == nil && before > 0 {
+			f.Until = time.Unix(before, 0)
+		}
+		if form.Get("mark") == "feed" {
+			for _, feed := range feeds {
+				if feed.ID == id {
+					f.Feed = feed.URL
+				}
+			}
+			if f.Feed == "" {
+				return nil
+			}
+		} else if id > 0 {
+			f.Folder = id
+		} else if id < 0 {
+			// Group -1 is Fever's "Sparks", which has no feeds here.
+			return nil
+		}
+		links, err := matchingLinks(f)
+		if err != nil {
+			return err
+		}
+		return d.SetRead(links, true)
+	}
+	return nil
+}
+
+// feverKeyHandler handles POST /auth/fever with {"username", "password"},
+// the credentials to type into Fever clients, and DELETE /auth/fever to
+// turn Fever access off. username defaults to the account's name.
+func feverKeyHandler(w http.ResponseWriter, r *http.Request) {
+	d := userDB(r)
+	switch r.Method {
+	case http.MethodPost:
+		var req credentials
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		req.Username = strings.TrimSpace(req.Username)
+		if u, ok := currentUser(r); ok && req.Username == "" {
+			req.Username = u.Username
+		}
+		if req.Username == "" {
+			http.Error(w, "Invalid request: username is required", http.StatusBadRequest)
+			return
+		}
+		if len(req.Password) < minPasswordLen {
+			http.Error(w, "Password must have at least 8 characters", http.StatusBadRequest)
+			return
+		}
+		err := d.SetFeverKey(feverAPIKey(req.Username, req.Password))
+		if errors.Is(err, errFeverKeyTaken) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to save fever credentials", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"username": req.Username, "endpoint": baseURL(r) + feverPath})
+	case http.MethodDelete:
+		if err := d.SetFeverKey(""); err != nil {
+			http.Error(w, "Failed to remove fever credentials", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Folder groups feeds. Folders can be nested through ParentID. Each user has
+// their own folder tree.
+type Folder struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	ParentID  *int   `json:"parent_id"`
+	Position  int    `json:"position"`
+	Collapsed bool   `json:"collapsed"`
+	Path      string `json:"path"` // names from the root, joined with "/"
+}
+
+var (
+	errFolderNotFound = errors.New("folder not found")
+	errFolderCycle    = errors.New("a folder cannot be moved inside itself")
+)
+
+// migrateFolders creates the folders table and gives feeds a folder_id.
+func migrateFolders(db *sql.DB) error {
+	createFolders := `
+	CREATE TABLE IF NOT EXISTS folders (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		parent_id INTEGER REFERENCES folders(id),
+		position INTEGER NOT NULL DEFAULT 0,
+		collapsed INTEGER NOT NULL DEFAULT 0
+	);`
+	if _, err := db.Exec(createFolders); err != nil {
+		return err
+	}
+	if err := addColumn(db, "feeds", "folder_id", "INTEGER REFERENCES folders(id)"); err != nil {
+		return err
+	}
+	if has, err := hasColumn(db, "folders", "user_id"); err != nil || has {
+		return err
+	}
+	// Folders from before they were per user belong to defaultUserID.
+	if err := addColumn(db, "folders", "user_id", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	return splitSharedFolders(db)
+}
+
+// splitSharedFolders gives every other user whose feeds are filed in
+// defaultUserID's folders folders of their own at the same paths.
+func splitSharedFolders(db *sql.DB) error {
+	folders, err := (&sqliteDB{db: db}).ListFolders()
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query("SELECT DISTINCT user_id, folder_id FROM feeds WHERE folder_id IS NOT NULL AND user_id != ?", defaultUserID)
+	if err != nil {
+		return err
+	}
+	var moves [][2]int
+	for rows.Next() {
+		var user, folder int
+		if err := rows.Scan(&user, &folder); err != nil {
+			rows.Close()
+			return err
+		}
+		moves = append(moves, [2]int{user, folder})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	paths := map[int]string{}
+	for _, f := range folders {
+		paths[f.ID] = f.Path
+	}
+	for _, m := range moves {
+		id, err := (&sqliteDB{db: db, user: m[0]}).ensureFolderPath(paths[m[1]])
+		if err != nil {
+			return err
+		}
+		if _, err := db.Exec("UPDATE feeds SET folder_id = ? WHERE user_id = ? AND folder_id = ?", id, m[0], m[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListFolders returns the user's folders ordered by parent and position,
+// with their paths filled in.
+func (s *sqliteDB) ListFolders() ([]Folder, error) {
+	return s.listFolders("WHERE user_id = ?", s.userID())
+}
+
+// listFolders returns the folders matching where. Paths are only complete
+// if where selects whole trees.
+func (s *sqliteDB) listFolders(where string, args ...interface{}) ([]Folder, error) {
+	rows, err := s.db.Query("SELECT id, name, parent_id, position, collapsed FROM folders "+where+" ORDER BY parent_id, position, id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	folders := []Folder{}
+	for rows.Next() {
+		var f Folder
+		var parentID sql.NullInt64
+		if err := rows.Scan(&f.ID, &f.Name, &parentID, &f.Position, &f.Collapsed); err != nil {
+			return nil, err
+		}
+		if parentID.Valid {
+			id := int(parentID.Int64)
+			f.ParentID = &id
+		}
+		folders = append(folders, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	byID := map[int]*Folder{}
+	for i := range folders {
+		byID[folders[i].ID] = &folders[i]
+	}
+	for i := range folders {
+		var names []string
+		// The depth bound guards against cycles in a hand-edited database.
+		for f := &folders[i]; f != nil && len(names) <= len(folders); {
+			names = append([]string{f.Name}, names...)
+			if f.ParentID == nil {
+				break
+			}
+			f = byID[*f.ParentID]
+		}
+		folders[i].Path = strings.Join(names, "/")
+	}
+	return folders, nil
+}
+
+func (s *sqliteDB) getFolder(id int) (Folder, error) {
+	folders, err := s.ListFolders()
+	if err != nil {
+		return Folder{}, err
+	}
+	for _, f := range folders {
+		if f.ID == id {
+			return f, nil
+		}
+	}
+	return Folder{}, errFolderNotFound
+}
+
+// CreateFolder adds a folder at the end of its parent's children.
+func (s *sqliteDB) CreateFolder(name string, parentID *int) (Folder, error) {
+	if parentID != nil {
+		if _, err := s.getFolder(*parentID); err != nil {
+			return Folder{}, err
+		}
+	}
+	res, err := s.db.Exec(`
+		INSERT INTO folders (user_id, name, parent_id, position)
+		VALUES (?, ?, ?, (SELECT COALESCE(MAX(position), -1) + 1 FROM folders WHERE user_id = ? AND parent_id IS ?))`,
+		s.userID(), name, parentID, s.userID(), parentID)
+	if err != nil {
+		return Folder{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Folder{}, err
+	}
+	return s.getFolder(int(id))
+}
+
+// UpdateFolder saves a folder's name, parent and collapse state. Moving a
+// folder to a new parent places it last among its new siblings.
+func (s *sqliteDB) UpdateFolder(f Folder) error {
+	current, err := s.getFolder(f.ID)
+	if err != nil {
+		return err
+	}
+	if f.ParentID != nil {
+		folders, err := s.ListFolders()
+		if err != nil {
+			return err
+		}
+		if isFolderDescendant(folders, *f.ParentID, f.ID) {
+			return errFolderCycle
+		}
+		if _, err := s.getFolder(*f.ParentID); err != nil {
+			return err
+		}
+	}
+	position := current.Position
+	if !sameParent(current.ParentID, f.ParentID) {
+		err := s.db.QueryRow("SELECT COALESCE(MAX(position), -1) + 1 FROM folders WHERE user_id = ? AND parent_id IS ?", s.userID(), f.ParentID).Scan(&position)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = s.db.Exec("UPDATE folders SET name = ?, parent_id = ?, position = ?, collapsed = ? WHERE id = ? AND user_id = ?",
+		f.Name, f.ParentID, position, f.Collapsed, f.ID, s.userID())
+	return err
+}
+
+// ReorderFolders sets the order of the children of parentID to ids.
+func (s *sqliteDB) ReorderFolders(parentID *int, ids []int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i, id := range ids {
+		res, err := tx.Exec("UPDATE folders SET position = ? WHERE id = ? AND user_id = ? AND parent_id IS ?", i, id, s.userID(), parentID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errFolderNotFound
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteFolder removes a folder. Its feeds and subfolders move up to the
+// folder's parent rather than being deleted.
+func (s *sqliteDB) DeleteFolder(id int) error {
+	f, err := s.getFolder(id)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE feeds SET folder_id = ? WHERE user_id = ? AND folder_id = ?", f.ParentID, s.userID(), id); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE folders SET parent_id = ?,
+			position = position + (SELECT COALESCE(MAX(position), -1) + 1 FROM folders WHERE user_id = ? AND parent_id IS ?)
+		WHERE user_id = ? AND parent_id = ?`, f.ParentID, s.userID(), f.ParentID, s.userID(), id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM folders WHERE id = ? AND user_id = ?", id, s.userID()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ensureFolderPath returns the folder at a "/"-separated path, creating any
+// missing folders. An empty path returns nil (top level).
+func (s *sqliteDB) ensureFolderPath(path string) (*int, error) {
+	folders, err := s.ListFolders()
+	if err != nil {
+		return nil, err
+	}
+	var parentID *int
+	for _, name := range strings.Split(path, "/") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		var found *int
+		for _, f := range folders {
+			if f.Name == name && sameParent(f.ParentID, parentID) {
+				id := f.ID
+				found = &id
+				break
+			}
+		}
+		if found == nil {
+			f, err := s.CreateFolder(name, parentID)
+			if err != nil {
+				return nil, err
+			}
+			folders = append(folders, f)
+			found = &f.ID
+		}
+		parentID = found
+	}
+	return parentID, nil
+}
+
+func sameParent(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// isFolderDescendant reports whether id is ancestor or one of its descendants.
+func isFolderDescendant(folders []Folder, id, ancestor int) bool {
+	parents := map[int]*int{}
+	for _, f := range folders {
+		parents[f.ID] = f.ParentID
+	}
+	for steps := 0; steps <= len(folders); steps++ {
+		if id == ancestor {
+			return true
+		}
+		parent := parents[id]
+		if parent == nil {
+			return false
+		}
+		id = *parent
+	}
+	return true
+}
+
+// foldersHandler handles GET, POST, PATCH, DELETE for the user's folders.
+func foldersHandler(w http.ResponseWriter, r *http.Request) {
+	db := userDB(r)
+	switch r.Method {
+	case http.MethodGet:
+		folders, err := db.ListFolders()
+		if err != nil {
+			http.Error(w, "Failed to list folders", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(folders)
+	case http.MethodPost:
+		var req struct {
+			Name     string `json:"name"`
+			ParentID *int   `json:"parent_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		folder, err := db.CreateFolder(strings.TrimSpace(req.Name), req.ParentID)
+		if err != nil {
+			folderError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(folder)
+	case http.MethodPatch:
+		// Only the fields present in the body are changed. "parent_id": null
+		// moves the folder to the top level.
+		var req struct {
+			ID        int             `json:"id"`
+			Name      *string         `json:"name"`
+			ParentID  json.RawMessage `json:"parent_id"`
+			Collapsed *bool           `json:"collapsed"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == 0 {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		folder, err := db.(*sqliteDB).getFolder(req.ID)
+		if err != nil {
+			folderError(w, err)
+			return
+		}
+		if req.Name != nil {
+			if strings.TrimSpace(*req.Name) == "" {
+				http.Error(w, "Invalid request", http.StatusBadRequest)
+				return
+			}
+			folder.Name = strings.TrimSpace(*req.Name)
+		}
+		if req.ParentID != nil {
+			folder.ParentID = nil
+			if err := json.Unmarshal(req.ParentID, &folder.ParentID); err != nil {
+				http.Error(w, "Invalid request", http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Collapsed != nil {
+			folder.Collapsed = *req.Collapsed
+		}
+		if err := db.UpdateFolder(folder); err != nil {
+			folderError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		var req struct {
+			ID int `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == 0 {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := db.DeleteFolder(req.ID); err != nil {
+			folderError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// folderOrderHandler handles POST /folders/order, which sets the order of
+// the folders under one parent.
+func folderOrderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ParentID *int  `json:"parent_id"`
+		IDs      []int `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.IDs) == 0 {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := userDB(r).ReorderFolders(req.ParentID, req.IDs); err != nil {
+		folderError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func folderError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errFolderNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errFolderCycle):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Failed to update folders", http.StatusInternalServerError)
+	}
+}
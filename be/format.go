@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Output formats supported by the ?format= query parameter.
+const (
+	FormatHTML     = "html"
+	FormatMarkdown = "markdown"
+	FormatText     = "text"
+)
+
+var (
+	spaceRe     = regexp.MustCompile(`[ \t\r\n\f]+`)
+	blankLineRe = regexp.MustCompile(`\n[ \t]*\n(?:[ \t]*\n)+`)
+	mdEscaper   = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "`", "\\`")
+)
+
+// formatFromRequest reads and validates the ?format= query parameter.
+// An empty value means the original HTML.
+func formatFromRequest(r *http.Request) (string, error) {
+	switch f := strings.ToLower(r.URL.Query().Get("format")); f {
+	case "", FormatHTML:
+		return FormatHTML, nil
+	case FormatMarkdown, FormatText:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown format %q (want html, markdown or text)", f)
+	}
+}
+
+// RenderHTML converts an HTML fragment to the requested format.
+// Links are emitted as numbered references listed at the end of the output.
+func RenderHTML(src, format string) string {
+	if format == FormatHTML || strings.TrimSpace(src) == "" {
+		return src
+	}
+	nodes, err := html.ParseFragment(strings.NewReader(src), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+	if err != nil {
+		return src
+	}
+	r := &htmlRenderer{markdown: format == FormatMarkdown, refs: map[string]int{}}
+	var sb strings.Builder
+	for _, n := range nodes {
+		sb.WriteString(r.node(n))
+	}
+	out := normalizeBlocks(sb.String())
+	if len(r.links) > 0 {
+		var refs strings.Builder
+		if !r.markdown {
+			refs.WriteString("Links:\n")
+		}
+		for i, link := range r.links {
+			if r.markdown {
+				fmt.Fprintf(&refs, "[%d]: %s\n", i+1, link)
+			} else {
+				fmt.Fprintf(&refs, "[%d] %s\n", i+1, link)
+			}
+		}
+		out += "\n\n" + strings.TrimRight(refs.String(), "\n")
+	}
+	return out
+}
+
+// renderPost converts the HTML fields of a post to the requested format.
+func renderPost(p *Post, format string) {
+	p.Content = RenderHTML(p.Content, format)
+	p.Description = RenderHTML(p.Description, format)
+}
+
+// htmlRenderer walks an HTML tree and produces Markdown or plain text.
+type htmlRenderer struct {
+	markdown bool
+	links    []string
+	refs     map[string]int
+}
+
+// ref returns the reference number for a link, allocating one if needed.
+func (r *htmlRenderer) ref(href string) int {
+	if n, ok := r.refs[href]; ok {
+		return n
+	}
+	r.links = append(r.links, href)
+	r.refs[href] = len(r.links)
+	return len(r.links)
+}
+
+func (r *htmlRenderer) children(n *html.Node) string {
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(r.node(c))
+	}
+	return sb.String()
+}
+
+func (r *htmlRenderer) node(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		text := spaceRe.ReplaceAllString(n.Data, " ")
+		if r.markdown {
+			text = mdEscaper.Replace(text)
+		}
+		return text
+	case html.ElementNode:
+	case html.DocumentNode:
+		return r.children(n)
+	default:
+		return ""
+	}
+
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Head, atom.Noscript, atom.Iframe, atom.Template:
+		return ""
+	case atom.Br:
+		return "\n"
+	case atom.Hr:
+		if r.markdown {
+			return "\n\n---\n\n"
+		}
+		return "\n\n"
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		text := strings.TrimSpace(spaceRe.ReplaceAllString(r.children(n), " "))
+		if text == "" {
+			return ""
+		}
+		if r.markdown {
+			level := int(n.Data[1] - '0')
+			text = strings.Repeat("#", level) + " " + text
+		}
+		return "\n\n" + text + "\n\n"
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer,
+		atom.Main, atom.Aside, atom.Nav, atom.Figure, atom.Figcaption, atom.Dl, atom.Dt, atom.Dd:
+		return "\n\n" + strings.TrimSpace(r.children(n)) + "\n\n"
+	case atom.Ul, atom.Ol:
+		return r.list(n)
+	case atom.Pre:
+		code := strings.Trim(textContent(n), "\n")
+		if r.markdown {
+			return "\n\n```\n" + code + "\n```\n\n"
+		}
+		return "\n\n" + prefixLines(code, "    ") + "\n\n"
+	case atom.Code, atom.Kbd, atom.Samp:
+		code := textContent(n)
+		if r.markdown && code != "" {
+			return "`" + code + "`"
+		}
+		return code
+	case atom.Blockquote:
+		inner := strings.TrimSpace(normalizeBlocks(r.children(n)))
+		prefix := "> "
+		if !r.markdown {
+			prefix = "  | "
+		}
+		return "\n\n" + prefixLines(inner, prefix) + "\n\n"
+	case atom.Strong, atom.B:
+		return r.wrapInline(n, "**")
+	case atom.Em, atom.I:
+		return r.wrapInline(n, "*")
+	case atom.Del, atom.S, atom.Strike:
+		return r.wrapInline(n, "~~")
+	case atom.A:
+		return r.link(n)
+	case atom.Img:
+		alt := strings.TrimSpace(attr(n, "alt"))
+		src := attr(n, "src")
+		if r.markdown && src != "" {
+			return "![" + mdEscaper.Replace(alt) + "](" + src + ")"
+		}
+		if alt != "" {
+			return "[image: " + alt + "]"
+		}
+		return ""
+	case atom.Table:
+		return r.table(n)
+	}
+	return r.children(n)
+}
+
+// wrapInline surrounds inline content with a Markdown marker such as ** or *.
+// Plain text output drops the marker.
+func (r *htmlRenderer) wrapInline(n *html.Node, marker string) string {
+	inner := r.children(n)
+	text := strings.TrimSpace(inner)
+	if !r.markdown || text == "" {
+		return inner
+	}
+	// Keep surrounding whitespace outside the markers so they still parse.
+	lead := inner[:len(inner)-len(strings.TrimLeft(inner, " "))]
+	trail := inner[len(strings.TrimRight(inner, " ")):]
+	return lead + marker + text + marker + trail
+}
+
+func (r *htmlRenderer) link(n *html.Node) string {
+	text := strings.TrimSpace(r.children(n))
+	href := strings.TrimSpace(attr(n, "href"))
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		return text
+	}
+	if text == "" {
+		text = href
+		if r.markdown {
+			text = mdEscaper.Replace(href)
+		}
+	}
+	idx := r.ref(href)
+	if r.markdown {
+		return fmt.Sprintf("[%s][%d]", text, idx)
+	}
+	return fmt.Sprintf("%s [%d]", text, idx)
+}
+
+func (r *htmlRenderer) list(n *html.Node) string {
+	ordered := n.DataAtom == atom.Ol
+	num := 1
+	if start, err := strconv.Atoi(attr(n, "start")); err == nil {
+		num = start
+	}
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.DataAtom != atom.Li {
+			continue
+		}
+		marker := "- "
+		if ordered {
+			marker = strconv.Itoa(num) + ". "
+			num++
+		}
+		body := strings.TrimSpace(normalizeBlocks(r.children(c)))
+		body = strings.ReplaceAll(body, "\n\n", "\n")
+		lines := strings.Split(body, "\n")
+		sb.WriteString(marker + lines[0] + "\n")
+		for _, line := range lines[1:] {
+			sb.WriteString(strings.Repeat(" ", len(marker)) + line + "\n")
+		}
+	}
+	return "\n\n" + sb.String() + "\n\n"
+}
+
+func (r *htmlRenderer) table(n *html.Node) string {
+	var rows [][]string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			if c.DataAtom != atom.Tr {
+				walk(c)
+				continue
+			}
+			var cells []string
+			for td := c.FirstChild; td != nil; td = td.NextSibling {
+				if td.Type != html.ElementNode || (td.DataAtom != atom.Td && td.DataAtom != atom.Th) {
+					continue
+				}
+				cell := strings.TrimSpace(spaceRe.ReplaceAllString(r.children(td), " "))
+				cells = append(cells, strings.ReplaceAll(cell, "|", `\|`))
+			}
+			if len(cells) > 0 {
+				rows = append(rows, cells)
+			}
+		}
+	}
+	walk(n)
+	if len(rows) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	for i, cells := range rows {
+		if r.markdown {
+			sb.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+			// Markdown tables need a header, so the first row always becomes one.
+			if i == 0 {
+				sb.WriteString("|" + strings.Repeat(" --- |", len(cells)) + "\n")
+			}
+		} else {
+			sb.WriteString(strings.Join(cells, " | ") + "\n")
+		}
+	}
+	return "\n\n" + sb.String() + "\n\n"
+}
+
+// normalizeBlocks trims trailing whitespace from each line and collapses
+// runs of blank lines left behind by nested block elements.
+func normalizeBlocks(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	s = strings.Join(lines, "\n")
+	s = blankLineRe.ReplaceAllString(s, "\n\n")
+	return strings.Trim(s, "\n ")
+}
+
+func prefixLines(s, prefix string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(prefix+line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// textContent returns the raw text below a node, preserving whitespace.
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(textContent(c))
+	}
+	return sb.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,774 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The Google Reader API, as implemented by FreshRSS and Miniflux, is spoken
+// by clients such as NetNewsWire, FeedMe and Newsflash. They sign in at
+// /accounts/ClientLogin and send the returned Auth value as
+// "Authorization: GoogleLogin auth=<Auth>"; everything else lives under
+// /reader/api/0/.
+const (
+	greaderLoginPath = "/accounts/ClientLogin"
+	greaderPrefix    = "/reader/api/0/"
+	// greaderPageSize and greaderMaxPage bound the n parameter of streams.
+	greaderPageSize = 20
+	greaderMaxPage  = 1000
+	// greaderLoginToken names the tokens ClientLogin creates; each user
+	// keeps the newest greaderLoginKeep, one per device or so.
+	greaderLoginToken = "GReader login"
+	greaderLoginKeep  = 5
+)
+
+// Stream IDs. Clients may send their user ID in place of "-".
+const (
+	streamReadingList = "user/-/state/com.google/reading-list"
+	streamRead        = "user/-/state/com.google/read"
+	streamStarred     = "user/-/state/com.google/starred"
+	streamKeptUnread  = "user/-/state/com.google/kept-unread"
+	streamLabelPrefix = "user/-/label/"
+	streamFeedPrefix  = "feed/"
+	greaderItemPrefix = "tag:google.com,2005:reader/item/"
+)
+
+var errUnknownStream = errors.New("unknown stream")
+
+type greaderLink struct {
+	Href string `json:"href"`
+	Type string `json:"type,omitempty"`
+}
+
+type greaderContent struct {
+	Direction string `json:"direction"`
+	Content   string `json:"content"`
+}
+
+type greaderOrigin struct {
+	StreamID string `json:"streamId"`
+	Title    string `json:"title"`
+	HTMLURL  string `json:"htmlUrl"`
+}
+
+type greaderEnclosure struct {
+	Href   string `json:"href"`
+	Type   string `json:"type,omitempty"`
+	Length string `json:"length,omitempty"`
+}
+
+type greaderItem struct {
+	ID            string             `json:"id"`
+	CrawlTimeMsec string             `json:"crawlTimeMsec"`
+	TimestampUsec string             `json:"timestampUsec"`
+	Published     int64              `json:"published"`
+	Updated       int64              `json:"updated"`
+	Title         string             `json:"title"`
+	Canonical     []greaderLink      `json:"canonical"`
+	Alternate     []greaderLink      `json:"alternate"`
+	Summary       greaderContent     `json:"summary"`
+	Author        string             `json:"author,omitempty"`
+	Categories    []string           `json:"categories"`
+	Origin        greaderOrigin      `json:"origin"`
+	Enclosure     []greaderEnclosure `json:"enclosure,omitempty"`
+}
+
+type greaderCategory struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+type greaderSubscription struct {
+	ID         string            `json:"id"`
+	Title      string            `json:"title"`
+	Categories []greaderCategory `json:"categories"`
+	URL        string            `json:"url"`
+	HTMLURL    string            `json:"htmlUrl"`
+	IconURL    string            `json:"iconUrl"`
+}
+
+type greaderTag struct {
+	ID   string `json:"id"`
+	Type string `json:"type,omitempty"`
+}
+
+type greaderUnreadCount struct {
+	ID                      string `json:"id"`
+	Count                   int    `json:"count"`
+	NewestItemTimestampUsec string `json:"newestItemTimestampUsec"`
+}
+
+// normalizeStream replaces the user ID in "user/<id>/..." stream IDs with
+// "-", the form the constants above use.
+func normalizeStream(s string) string {
+	parts := strings.SplitN(s, "/", 3)
+	if len(parts) == 3 && parts[0] == "user" {
+		return "user/-/" + parts[2]
+	}
+	return s
+}
+
+// greaderItemID formats an article ID in the long form clients expect in
+// item contents.
+func greaderItemID(id int) string {
+	return fmt.Sprintf("%s%016x", greaderItemPrefix, id)
+}
+
+// parseGReaderItemID accepts the long form, plain hex as some clients
+// send it, or the decimal form returned by stream/items/ids.
+func parseGReaderItemID(s string) (int, error) {
+	if h, ok := strings.CutPrefix(s, greaderItemPrefix); ok {
+		id, err := strconv.ParseInt(h, 16, 64)
+		return int(id), err
+	}
+	if len(s) == 16 {
+		if id, err := strconv.ParseInt(s, 16, 64); err == nil {
+			return int(id), nil
+		}
+	}
+	id, err := strconv.ParseInt(s, 10, 64)
+	return int(id), err
+}
+
+// streamFilter returns the filter selecting the articles of a stream.
+// Labels name a folder (by path) if there is one, otherwise a tag.
+func streamFilter(stream string, d DB, user int) (ArticleFilter, error) {
+	f := ArticleFilter{User: user}
+	stream = normalizeStream(stream)
+	switch {
+	case stream == "" || stream == streamReadingList:
+	case stream == streamStarred:
+		f.Starred = true
+	case stream == streamRead:
+		f.Read = true
+	case stream == streamKeptUnread:
+		f.Unread = true
+	case strings.HasPrefix(stream, streamFeedPrefix):
+		f.Feed = strings.TrimPrefix(stream, streamFeedPrefix)
+	case strings.HasPrefix(stream, streamLabelPrefix):
+		label := strings.TrimPrefix(stream, streamLabelPrefix)
+		folders, err := d.ListFolders()
+		if err != nil {
+			return f, err
+		}
+		for _, folder := range folders {
+			if folder.Path == label {
+				f.Folder = folder.ID
+			}
+		}
+		if f.Folder == 0 {
+			f.Tag = label
+		}
+	default:
+		return f, errUnknownStream
+	}
+	return f, nil
+}
+
+// streamPage reads the paging parameters shared by stream/contents and
+// stream/items/ids: xt and it (exclude or include a state), ot and nt
+// (older and newer time bounds, Unix seconds), r=o (oldest first), n and
+// c (continuation).
+func streamPage(f ArticleFilter, q url.Values) (ArticleFilter, error) {
+	switch normalizeStream(q.Get("xt")) {
+	case streamRead:
+		f.Unread = true
+	case streamStarred:
+		// Rarely used; there is no "not starred" filter, so it is ignored.
+	}
+	switch normalizeStream(q.Get("it")) {
+	case streamRead:
+		f.Read = true
+	case streamStarred:
+		f.Starred = true
+	}
+	if ot, err := strconv.ParseInt(q.Get("ot"), 10, 64); err == nil && ot > 0 {
+		f.Since = time.Unix(ot, 0)
+	}
+	if nt, err := strconv.ParseInt(q.Get("nt"), 10, 64); err == nil && nt > 0 {
+		f.Until = time.Unix(nt, 0)
+	}
+	f.Oldest = q.Get("r") == "o"
+	f.Limit = greaderPageSize
+	if n, err := strconv.Atoi(q.Get("n")); err == nil && n > 0 {
+		f.Limit = min(n, greaderMaxPage)
+	}
+	if c := q.Get("c"); c != "" {
+		var err error
+		if f.After, err = parseCursor(c); err != nil {
+			return f, err
+		}
+		if f.After.Oldest != f.Oldest {
+			return f, fmt.Errorf("%w: it belongs to a different sort order", errInvalidCursor)
+		}
+	}
+	return f, nil
+}
+
+// greaderLoginHandler handles ClientLogin, a POST with Email and Passwd
+// in the body. Passwd is the account password, or an API token, which is
+// also how installs without accounts sign in. The Auth returned is an API
+// token: the one given, or a new one named greaderLoginToken that can be
+// revoked at /tokens. Only the newest greaderLoginKeep of those are kept,
+// as clients sign in again whenever they like.
+func greaderLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	password := r.PostFormValue("Passwd")
+	var auth string
+	if strings.HasPrefix(password, apiTokenPrefix) {
+		if _, err := db.APITokenUser(password); err != nil {
+			http.Error(w, "Error=BadAuthentication", http.StatusUnauthorized)
+			return
+		}
+		auth = password
+	} else {
+		u, err := checkPassword(r.PostFormValue("Email"), password)
+		if errors.Is(err, errBadCredentials) {
+			http.Error(w, "Error=BadAuthentication", http.StatusUnauthorized)
+			return
+		}
+		var t APIToken
+		if err == nil {
+			t, err = db.ForUser(u.ID).RotateAPIToken(greaderLoginToken, greaderLoginKeep)
+		}
+		if err != nil {
+			http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+			return
+		}
+		auth = t.Token
+	}
+	if r.FormValue("output") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"SID": auth, "LSID": "null", "Auth": auth})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "SID=%s\nLSID=null\nAuth=%s\n", auth, auth)
+}
+
+// greaderHandler dispatches the /reader/api/0/ endpoints. Requests are
+// authenticated by withAuth like the rest of the API.
+func greaderHandler(w http.ResponseWriter, r *http.Request) {
+	d, user := userDB(r), requestUserID(r)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, greaderPrefix)
+	var err error
+	switch {
+	case path == "token":
+		// The T token guards Google's cookie-based API against CSRF. Here
+		// requests are authenticated by header, so any value is accepted.
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		token, _ := randomToken(16)
+		fmt.Fprintln(w, token)
+	case path == "user-info":
+		u, _ := currentUser(r)
+		writeJSON(w, map[string]string{
+			"userId":        strconv.Itoa(user),
+			"userName":      u.Username,
+			"userProfileId": strconv.Itoa(user),
+			"userEmail":     "",
+		})
+	case path == "subscription/list":
+		err = greaderSubscriptions(w, d)
+	case path == "tag/list":
+		err = greaderTags(w, d)
+	case path == "unread-count":
+		err = greaderUnreadCounts(w, d)
+	case path == "stream/items/ids":
+		err = greaderStream(w, r, d, user, true)
+	case strings.HasPrefix(path, "stream/contents"):
+		err = greaderStream(w, r, d, user, false)
+	case path == "stream/items/contents":
+		err = greaderItemContents(w, r, d, user)
+	case r.Method != http.MethodPost:
+		if path == "subscription/edit" || path == "subscription/quickadd" || path == "edit-tag" || path == "mark-all-as-read" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		http.NotFound(w, r)
+	case path == "subscription/edit":
+		err = greaderEditSubscription(w, r, d)
+	case path == "subscription/quickadd":
+		err = greaderQuickAdd(w, r, d)
+	case path == "edit-tag":
+		err = greaderEditTag(w, r, d, user)
+	case path == "mark-all-as-read":
+		err = greaderMarkAllRead(w, r, d, user)
+	default:
+		http.NotFound(w, r)
+	}
+	switch {
+	case errors.Is(err, errUnknownStream), errors.Is(err, errInvalidCursor):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		log.Printf("GReader %s failed: %v", path, err)
+		http.Error(w, "Request failed", http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeOK(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, "OK")
+}
+
+// siteURL guesses a feed's website from its URL.
+func siteURL(feedURL string) string {
+	u, err := url.Parse(feedURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host + "/"
+}
+
+func greaderSubscriptions(w http.ResponseWriter, d DB) error {
+	feeds, err := d.ListFeeds()
+	if err != nil {
+		return err
+	}
+	subs := []greaderSubscription{}
+	for _, f := range feeds {
+		sub := greaderSubscription{
+			ID:         streamFeedPrefix + f.URL,
+			Title:      f.FeedName,
+			Categories: []greaderCategory{},
+			URL:        f.URL,
+			HTMLURL:    siteURL(f.URL),
+		}
+		if f.Folder != "" {
+			sub.Categories = append(sub.Categories, greaderCategory{ID: streamLabelPrefix + f.Folder, Label: f.Folder})
+		}
+		subs = append(subs, sub)
+	}
+	writeJSON(w, map[string]interface{}{"subscriptions": subs})
+	return nil
+}
+
+// greaderTags lists the starred state, folders and article tags.
+func greaderTags(w http.ResponseWriter, d DB) error {
+	folders, err := d.ListFolders()
+	if err != nil {
+		return err
+	}
+	tags, err := d.ListTags()
+	if err != nil {
+		return err
+	}
+	list := []greaderTag{{ID: streamStarred}}
+	for _, f := range folders {
+		list = append(list, greaderTag{ID: streamLabelPrefix + f.Path, Type: "folder"})
+	}
+	for _, t := range tags {
+		list = append(list, greaderTag{ID: streamLabelPrefix + t.Name, Type: "tag"})
+	}
+	writeJSON(w, map[string]interface{}{"tags": list})
+	return nil
+}
+
+// greaderUnreadCounts counts unread articles per feed, per folder and in
+// the reading list.
+func greaderUnreadCounts(w http.ResponseWriter, d DB) error {
+	counts, err := articleCounts(d)
+	if err != nil {
+		return err
+	}
+	feeds, err := d.ListFeeds()
+	if err != nil {
+		return err
+	}
+	updated, err := db.(*sqliteDB).feedUpdateTimes()
+	if err != nil {
+		return err
+	}
+	usec := func(t time.Time) string {
+		if t.IsZero() {
+			return "0"
+		}
+		return strconv.FormatInt(t.UnixMicro(), 10)
+	}
+	folder := map[string]string{}
+	for _, f := range feeds {
+		folder[f.URL] = f.Folder
+	}
+	list := []greaderUnreadCount{}
+	var labels []string
+	labelCount := map[string]int{}
+	labelNewest := map[string]time.Time{}
+	var newest time.Time
+	for _, c := range counts.Feeds {
+		t := updated[c.URL]
+		list = append(list, greaderUnreadCount{ID: streamFeedPrefix + c.URL, Count: c.Unread, NewestItemTimestampUsec: usec(t)})
+		if t.After(newest) {
+			newest = t
+		}
+		label := folder[c.URL]
+		if label == "" {
+			continue
+		}
+		if _, ok := labelCount[label]; !ok {
+			labels = append(labels, label)
+		}
+		labelCount[label] += c.Unread
+		if t.After(labelNewest[label]) {
+			labelNewest[label] = t
+		}
+	}
+	for _, label := range labels {
+		list = append(list, greaderUnreadCount{ID: streamLabelPrefix + label, Count: labelCount[label], NewestItemTimestampUsec: usec(labelNewest[label])})
+	}
+	list = append(list, greaderUnreadCount{ID: streamReadingList, Count: counts.Unread, NewestItemTimestampUsec: usec(newest)})
+	writeJSON(w, map[string]interface{}{"max": counts.Unread, "unreadcounts": list})
+	return nil
+}
+
+// greaderStream serves stream/contents/<stream> and stream/items/ids?s=,
+// the latter with IDs only.
+func greaderStream(w http.ResponseWriter, r *http.Request, d DB, user int, idsOnly bool) error {
+	stream := r.Form.Get("s")
+	if !idsOnly {
+		if rest, ok := strings.CutPrefix(r.URL.EscapedPath(), greaderPrefix+"stream/contents/"); ok {
+			if s, err := url.PathUnescape(rest); err == nil && s != "" {
+				stream = s
+			}
+		}
+	}
+	f, err := streamFilter(stream, d, user)
+	if err != nil {
+		return err
+	}
+	if f, err = streamPage(f, r.Form); err != nil {
+		return err
+	}
+	posts, _, next, err := articlePage(f)
+	if err != nil {
+		return err
+	}
+	if idsOnly {
+		refs := make([]map[string]string, 0, len(posts))
+		for _, p := range posts {
+			ref := map[string]string{"id": strconv.Itoa(p.ID)}
+			if t, ok := articleTime(p); ok {
+				ref["timestampUsec"] = strconv.FormatInt(t.UnixMicro(), 10)
+			}
+			refs = append(refs, ref)
+		}
+		resp := map[string]interface{}{"itemRefs": refs}
+		if next != "" {
+			resp["continuation"] = next
+		}
+		writeJSON(w, resp)
+		return nil
+	}
+	items, err := greaderItems(posts, d, user)
+	if err != nil {
+		return err
+	}
+	resp := map[string]interface{}{
+		"id":      normalizeStream(stream),
+		"updated": time.Now().Unix(),
+		"items":   items,
+	}
+	if next != "" {
+		resp["continuation"] = next
+	}
+	writeJSON(w, resp)
+	return nil
+}
+
+// greaderItemContents returns the articles named by the i parameters.
+func greaderItemContents(w http.ResponseWriter, r *http.Request, d DB, user int) error {
+	f := ArticleFilter{User: user, WithHidden: true}
+	for _, s := range r.Form["i"] {
+		if id, err := parseGReaderItemID(s); err == nil {
+			f.IDs = append(f.IDs, id)
+		}
+	}
+	posts := []Post{}
+	if len(f.IDs) > 0 {
+		var err error
+		if posts, err = QueryArticles(f); err != nil {
+			return err
+		}
+	}
+	items, err := greaderItems(posts, d, user)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, map[string]interface{}{
+		"id":      streamReadingList,
+		"updated": time.Now().Unix(),
+		"items":   items,
+	})
+	return nil
+}
+
+// greaderItems converts articles, adding their read, starred, folder and
+// tag categories.
+func greaderItems(posts []Post, d DB, user int) ([]greaderItem, error) {
+	items := []greaderItem{}
+	if len(posts) == 0 {
+		return items, nil
+	}
+	if err := attachTags(d, posts); err != nil {
+		return nil, err
+	}
+	ids := make([]int, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+	}
+	readIDs, err := articleIDs(ArticleFilter{User: user, IDs: ids, Read: true, WithHidden: true})
+	if err != nil {
+		return nil, err
+	}
+	read := map[int]bool{}
+	for _, id := range readIDs {
+		read[id] = true
+	}
+	feeds, err := d.ListFeeds()
+	if err != nil {
+		return nil, err
+	}
+	byURL := map[string]Feed{}
+	for _, f := range feeds {
+		byURL[f.URL] = f
+	}
+	for _, p := range posts {
+		feed := byURL[p.Source]
+		item := greaderItem{
+			ID:         greaderItemID(p.ID),
+			Title:      p.Title,
+			Canonical:  []greaderLink{{Href: p.Link}},
+			Alternate:  []greaderLink{{Href: p.Link, Type: "text/html"}},
+			Summary:    greaderContent{Direction: "ltr", Content: p.Content},
+			Author:     p.Author,
+			Categories: []string{streamReadingList},
+			Origin:     greaderOrigin{StreamID: streamFeedPrefix + p.Source, Title: feed.FeedName, HTMLURL: siteURL(p.Source)},
+		}
+		if item.Summary.Content == "" {
+			item.Summary.Content = p.Description
+		}
+		if t, ok := articleTime(p); ok {
+			item.Published, item.Updated = t.Unix(), t.Unix()
+			item.TimestampUsec = strconv.FormatInt(t.UnixMicro(), 10)
+		}
+		if t, err := time.Parse(time.RFC3339, p.FetchedAt); err == nil {
+			item.CrawlTimeMsec = strconv.FormatInt(t.UnixMilli(), 10)
+		}
+		if read[p.ID] {
+			item.Categories = append(item.Categories, streamRead)
+		}
+		if p.Starred {
+			item.Categories = append(item.Categories, streamStarred)
+		}
+		if feed.Folder != "" {
+			item.Categories = append(item.Categories, streamLabelPrefix+feed.Folder)
+		}
+		for _, tag := range p.Tags {
+			item.Categories = append(item.Categories, streamLabelPrefix+tag)
+		}
+		if p.Enclosure != nil {
+			item.Enclosure = []greaderEnclosure{{Href: p.Enclosure.URL, Type: p.Enclosure.Type, Length: p.Enclosure.Length}}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// greaderEditSubscription handles ac=subscribe|unsubscribe|edit for the
+// feeds in s, with an optional title t, and a label to add (a) or remove
+// (r), which moves the feed into or out of that folder.
+func greaderEditSubscription(w http.ResponseWriter, r *http.Request, d DB) error {
+	title := r.Form.Get("t")
+	add := strings.TrimPrefix(normalizeStream(r.Form.Get("a")), streamLabelPrefix)
+	remove := strings.TrimPrefix(normalizeStream(r.Form.Get("r")), streamLabelPrefix)
+	streams := r.Form["s"]
+	if len(streams) == 0 {
+		http.Error(w, "Invalid request: missing s", http.StatusBadRequest)
+		return nil
+	}
+	for _, s := range streams {
+		feedURL, ok := strings.CutPrefix(s, streamFeedPrefix)
+		if !ok || !validFeedURL(feedURL) {
+			http.Error(w, "Invalid request: not a feed stream", http.StatusBadRequest)
+			return nil
+		}
+		switch r.Form.Get("ac") {
+		case "subscribe":
+			if err := greaderSubscribe(d, feedURL, title, add); err != nil {
+				return err
+			}
+		case "unsubscribe":
+			if err := d.RemoveFeed(feedURL); err != nil {
+				return err
+			}
+			emitEvent(d, EventFeedRemoved, feedEventData{URL: feedURL})
+		case "edit":
+			if title != "" {
+				if err := d.RenameFeed(feedURL, title); err != nil {
+					return err
+				}
+			}
+			var err error
+			switch {
+			case add != "":
+				err = d.SetFeedFolder(feedURL, add)
+			case remove != "":
+				err = d.MoveFeed(feedURL, nil)
+			}
+			if err != nil {
+				return err
+			}
+		default:
+			http.Error(w, "Invalid request: ac must be subscribe, unsubscribe or edit", http.StatusBadRequest)
+			return nil
+		}
+	}
+	writeOK(w)
+	return nil
+}
+
+// greaderSubscribe adds a feed, named after its title unless name is set,
+// optionally into the folder at path label.
+func greaderSubscribe(d DB, feedURL, name, label string) error {
+	if name == "" {
+		name = FetchFeedTitle(feedURL)
+	}
+	if err := d.AddFeed(feedURL, name); err != nil {
+		return err
+	}
+	if label != "" {
+		if err := d.SetFeedFolder(feedURL, label); err != nil {
+			return err
+		}
+	}
+	emitEvent(d, EventFeedAdded, feedEventData{URL: feedURL, Name: name})
+	return nil
+}
+
+func greaderQuickAdd(w http.ResponseWriter, r *http.Request, d DB) error {
+	feedURL := strings.TrimPrefix(r.Form.Get("quickadd"), streamFeedPrefix)
+	if !validFeedURL(feedURL) {
+		http.Error(w, "Invalid request: quickadd must be a feed URL", http.StatusBadRequest)
+		return nil
+	}
+	name := FetchFeedTitle(feedURL)
+	if err := greaderSubscribe(d, feedURL, name, ""); err != nil {
+		return err
+	}
+	writeJSON(w, map[string]interface{}{
+		"numResults": 1,
+		"query":      feedURL,
+		"streamId":   streamFeedPrefix + feedURL,
+		"streamName": name,
+	})
+	return nil
+}
+
+// greaderEditTag adds (a) and removes (r) states and labels on the items
+// in i: the read and starred states, kept-unread (the same as removing
+// read), and labels, which are article tags.
+func greaderEditTag(w http.ResponseWriter, r *http.Request, d DB, user int) error {
+	f := ArticleFilter{User: user, WithHidden: true}
+	for _, s := range r.Form["i"] {
+		id, err := parseGReaderItemID(s)
+		if err != nil {
+			http.Error(w, "Invalid request: bad item id", http.StatusBadRequest)
+			return nil
+		}
+		f.IDs = append(f.IDs, id)
+	}
+	if len(f.IDs) == 0 {
+		http.Error(w, "Invalid request: missing i", http.StatusBadRequest)
+		return nil
+	}
+	links, err := matchingLinks(f)
+	if err != nil {
+		return err
+	}
+	apply := func(tag string, add bool) error {
+		tag = normalizeStream(tag)
+		switch {
+		case tag == streamRead:
+			return d.SetRead(links, add)
+		case tag == streamKeptUnread:
+			return d.SetRead(links, !add)
+		case tag == streamStarred:
+			for _, link := range links {
+				var err error
+				if add {
+					err = d.Star(link)
+				} else {
+					err = d.Unstar(link)
+				}
+				if err != nil {
+					return err
+				}
+			}
+		case strings.HasPrefix(tag, streamLabelPrefix):
+			name := strings.TrimPrefix(tag, streamLabelPrefix)
+			for _, link := range links {
+				var err error
+				if add {
+					err = d.TagArticle(link, name)
+				} else {
+					err = d.UntagArticle(link, name)
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for _, tag := range r.Form["a"] {
+		if err := apply(tag, true); err != nil {
+			return err
+		}
+	}
+	for _, tag := range r.Form["r"] {
+		if err := apply(tag, false); err != nil {
+			return err
+		}
+	}
+	writeOK(w)
+	return nil
+}
+
+// greaderMarkAllRead marks the unread articles of stream s read, up to
+// ts (microseconds since the epoch) if given.
+func greaderMarkAllRead(w http.ResponseWriter, r *http.Request, d DB, user int) error {
+	f, err := streamFilter(r.Form.Get("s"), d, user)
+	if err != nil {
+		return err
+	}
+	f.Unread, f.Read = true, false
+	if ts, err := strconv.ParseInt(r.Form.Get("ts"), 10, 64); err == nil && ts > 0 {
+		f.Until = time.UnixMicro(ts)
+	}
+	links, err := matchingLinks(f)
+	if err != nil {
+		return err
+	}
+	if err := d.SetRead(links, true); err != nil {
+		return err
+	}
+	writeOK(w)
+	return nil
+}
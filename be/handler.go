@@ -1,9 +1,10 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"log"
 	"net/http"
 	"time"
 
@@ -18,19 +19,25 @@ type ArticleParseResponse struct {
 }
 
 // ParseArticleHandler handles on-demand article parsing.
-
+// The optional ?format= parameter selects html (default), markdown or text content.
 func ParseArticleHandler(w http.ResponseWriter, r *http.Request) {
 	urlStr := r.URL.Query().Get("url")
 	if urlStr == "" {
 		http.Error(w, "Missing url parameter", http.StatusBadRequest)
 		return
 	}
+	format, err := formatFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	result, err := ParseArticleFromURL(urlStr)
 	if err != nil {
 		http.Error(w, "Failed to parse article: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	result.Content = RenderHTML(result.Content, format)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(result)
@@ -38,13 +45,7 @@ func ParseArticleHandler(w http.ResponseWriter, r *http.Request) {
 
 // FetchFeedTitle tries to fetch the RSS feed and extract its <title>
 func FetchFeedTitle(url string) string {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return url
-	}
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := fetchURL(url)
 	if err != nil {
 		return url
 	}
@@ -63,24 +64,77 @@ func FetchFeedTitle(url string) string {
 
 // postsHandler returns all posts from all feeds in the database as JSON
 func postsHandler(w http.ResponseWriter, r *http.Request) {
-	articles, err := GetCachedArticles(db)
+	format, err := formatFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	filter, err := parsePostsQuery(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.User = requestUserID(r)
+	if search := q.Get("search"); search != "" {
+		// A saved search reads like a feed.
+		filter, err = savedSearchArticleFilter(userDB(r), search, filter)
+		if errors.Is(err, errSavedSearchNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, errSearchUnavailable) {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		if errors.Is(err, errSearchQuery) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	var articles []Post
+	var total int
+	var next string
+	if err == nil {
+		if q.Get("search") == "" && q.Get("sort") == "" && filter.empty() {
+			// Without parameters every visible article is returned, as
+			// older clients expect.
+			articles, err = GetCachedArticles(userDB(r))
+			total = len(articles)
+		} else {
+			articles, total, next, err = articlePage(filter)
+		}
+	}
+	if err == nil {
+		err = attachTags(userDB(r), articles)
+	}
 	if err != nil {
 		http.Error(w, "Failed to fetch cached articles", http.StatusInternalServerError)
 		return
 	}
+	for i := range articles {
+		renderPost(&articles[i], format)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	response := struct {
-		FromCache bool   `json:"fromCache"`
-		Articles  []Post `json:"articles"`
+		// FromCache is kept for older clients: articles are only ever
+		// read from the cache, POST /refresh is what fetches feeds.
+		FromCache  bool   `json:"fromCache"`
+		Total      int    `json:"total"`
+		NextCursor string `json:"next_cursor,omitempty"`
+		Articles   []Post `json:"articles"`
 	}{
-		FromCache: true, // Always true for now
-		Articles:  articles,
+		FromCache:  true,
+		Total:      total,
+		NextCursor: next,
+		Articles:   articles,
 	}
 	json.NewEncoder(w).Encode(response)
 }
 
-// feedsHandler handles GET, POST, DELETE for RSS feed URLs
+// feedsHandler handles GET, POST, PATCH, DELETE for RSS feed URLs
 func feedsHandler(w http.ResponseWriter, r *http.Request) {
+	db := userDB(r)
 	switch r.Method {
 	case http.MethodGet:
 		feeds, err := db.ListFeeds()
@@ -88,8 +142,34 @@ func feedsHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Failed to list feeds", http.StatusInternalServerError)
 			return
 		}
+		counts, err := db.CountsBySource()
+		if err != nil {
+			http.Error(w, "Failed to count articles", http.StatusInternalServerError)
+			return
+		}
+		for i := range feeds {
+			feeds[i].Unread, feeds[i].Total = counts[feeds[i].URL].Unread, counts[feeds[i].URL].Total
+		}
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(feeds)
+		// Saved searches are listed after the feeds, marked "type": "search",
+		// unless ?searches=0 asks for the feeds alone.
+		if s := r.URL.Query().Get("searches"); s != "" && !isTrue(s) {
+			json.NewEncoder(w).Encode(feeds)
+			return
+		}
+		searches, err := savedSearchFeeds(db)
+		if err != nil {
+			http.Error(w, "Failed to list saved searches", http.StatusInternalServerError)
+			return
+		}
+		list := make([]interface{}, 0, len(feeds)+len(searches))
+		for _, f := range feeds {
+			list = append(list, f)
+		}
+		for _, ss := range searches {
+			list = append(list, ss)
+		}
+		json.NewEncoder(w).Encode(list)
 	case http.MethodPost:
 		var req struct {
 			URL  string `json:"url"`
@@ -107,6 +187,22 @@ func feedsHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Failed to add feed", http.StatusInternalServerError)
 			return
 		}
+		emitEvent(db, EventFeedAdded, feedEventData{URL: req.URL, Name: name})
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPatch:
+		// Move a feed into a folder; a null folder_id moves it to the top level.
+		var req struct {
+			URL      string `json:"url"`
+			FolderID *int   `json:"folder_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := db.MoveFeed(req.URL, req.FolderID); err != nil {
+			folderError(w, err)
+			return
+		}
 		w.WriteHeader(http.StatusNoContent)
 	case http.MethodDelete:
 		var req struct {
@@ -120,6 +216,7 @@ func feedsHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Failed to remove feed", http.StatusInternalServerError)
 			return
 		}
+		emitEvent(db, EventFeedRemoved, feedEventData{URL: req.URL})
 		w.WriteHeader(http.StatusNoContent)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -127,6 +224,7 @@ func feedsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func readHandler(w http.ResponseWriter, r *http.Request) {
+	db := userDB(r)
 	switch r.Method {
 	case http.MethodGet:
 		links, err := db.ListRead()
@@ -139,15 +237,105 @@ func readHandler(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPost:
 		var req struct {
 			Link string `json:"link"`
+			// Seconds spent on the article, if the client measures it.
+			Seconds int `json:"seconds"`
 		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Link == "" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Link == "" || req.Seconds < 0 {
 			http.Error(w, "Invalid request", http.StatusBadRequest)
 			return
 		}
+		var err error
 		if r.URL.Path == "/read" {
-			db.MarkRead(req.Link)
+			err = markRead(db, req.Link, req.Seconds)
 		} else {
-			db.MarkUnread(req.Link)
+			err = db.MarkUnread(req.Link)
+		}
+		if err != nil {
+			http.Error(w, "Failed to update read articles", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// markRead marks an article read and records it in the reading history.
+// Failing to record history is logged but doesn't fail the request.
+func markRead(db DB, link string, seconds int) error {
+	if err := db.MarkRead(link); err != nil {
+		return err
+	}
+	if err := db.RecordRead(link, seconds, time.Now()); err != nil {
+		log.Printf("Failed to record reading of %s: %v", link, err)
+	}
+	return nil
+}
+
+// starHandler handles GET /star (list starred links) and POST /star, /unstar.
+func starHandler(w http.ResponseWriter, r *http.Request) {
+	db := userDB(r)
+	switch r.Method {
+	case http.MethodGet:
+		links, err := db.ListStarred()
+		if err != nil {
+			http.Error(w, "Failed to list starred articles", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(links)
+	case http.MethodPost:
+		var req struct {
+			Link string `json:"link"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Link == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		var err error
+		if r.URL.Path == "/star" {
+			err = db.Star(req.Link)
+		} else {
+			err = db.Unstar(req.Link)
+		}
+		if err != nil {
+			http.Error(w, "Failed to update starred articles", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func hideHandler(w http.ResponseWriter, r *http.Request) {
+	db := userDB(r)
+	switch r.Method {
+	case http.MethodGet:
+		links, err := db.ListHidden()
+		if err != nil {
+			http.Error(w, "Failed to list hidden articles", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(links)
+	case http.MethodPost:
+		var req struct {
+			Link string `json:"link"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Link == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		var err error
+		if r.URL.Path == "/hide" {
+			err = db.Hide(req.Link)
+		} else {
+			err = db.Unhide(req.Link)
+		}
+		if err != nil {
+			http.Error(w, "Failed to update hidden articles", http.StatusInternalServerError)
+			return
 		}
 		w.WriteHeader(http.StatusNoContent)
 	default:
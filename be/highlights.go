@@ -0,0 +1,458 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Highlight is a passage marked in an article. It is anchored by the quoted
+// text plus some surrounding context rather than by offsets, so it can be
+// found again after upsertArticle replaces the article content.
+type Highlight struct {
+	ID        int    `json:"id"`
+	Link      string `json:"link"`
+	Title     string `json:"title,omitempty"`
+	Quote     string `json:"quote"`
+	Prefix    string `json:"prefix,omitempty"`
+	Suffix    string `json:"suffix,omitempty"`
+	Note      string `json:"note,omitempty"`
+	CreatedAt string `json:"created_at"`
+	// Offset is where the quote starts in the article's current plain text,
+	// or -1 when it can no longer be found there.
+	Offset int `json:"offset"`
+}
+
+var errHighlightNotFound = errors.New("highlight not found")
+
+// createNotes is the article_notes table, one note per user and article.
+const createNotes = `
+	CREATE TABLE IF NOT EXISTS article_notes (
+		user_id INTEGER NOT NULL DEFAULT 1,
+		link TEXT NOT NULL,
+		note TEXT NOT NULL,
+		updated_at TEXT,
+		PRIMARY KEY (user_id, link)
+	);`
+
+// migrateHighlights creates the highlight and note tables, both private to
+// each user.
+func migrateHighlights(db *sql.DB) error {
+	createHighlights := `
+	CREATE TABLE IF NOT EXISTS highlights (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL DEFAULT 1,
+		link TEXT NOT NULL,
+		quote TEXT NOT NULL,
+		prefix TEXT,
+		suffix TEXT,
+		note TEXT,
+		created_at TEXT
+	);
+	CREATE INDEX IF NOT EXISTS highlights_link ON highlights(link);`
+	if _, err := db.Exec(createHighlights); err != nil {
+		return err
+	}
+	if err := addColumn(db, "highlights", "user_id", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	if _, err := db.Exec(createNotes); err != nil {
+		return err
+	}
+	return rebuildWithUser(db, "article_notes", createNotes, "link, note, updated_at")
+}
+
+func (s *sqliteDB) AddHighlight(h Highlight) (Highlight, error) {
+	h.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	res, err := s.db.Exec("INSERT INTO highlights (user_id, link, quote, prefix, suffix, note, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		s.userID(), h.Link, h.Quote, h.Prefix, h.Suffix, h.Note, h.CreatedAt)
+	if err != nil {
+		return h, err
+	}
+	id, err := res.LastInsertId()
+	h.ID = int(id)
+	return h, err
+}
+
+func (s *sqliteDB) SetHighlightNote(id int, note string) error {
+	res, err := s.db.Exec("UPDATE highlights SET note = ? WHERE user_id = ? AND id = ?", note, s.userID(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errHighlightNotFound
+	}
+	return nil
+}
+
+func (s *sqliteDB) DeleteHighlight(id int) error {
+	_, err := s.db.Exec("DELETE FROM highlights WHERE user_id = ? AND id = ?", s.userID(), id)
+	return err
+}
+
+// ListHighlights returns the highlights of one article, or of all articles
+// when link is empty, ordered by article and creation time.
+func (s *sqliteDB) ListHighlights(link string) ([]Highlight, error) {
+	query := `
+		SELECT h.id, h.link, COALESCE(a.title, ''), h.quote, h.prefix, h.suffix, h.note, h.created_at
+		FROM highlights h LEFT JOIN articles a ON a.link = h.link
+		WHERE h.user_id = ?`
+	args := []interface{}{s.userID()}
+	if link != "" {
+		query += " AND h.link = ?"
+		args = append(args, link)
+	}
+	rows, err := s.db.Query(query+" ORDER BY h.link, h.created_at, h.id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	highlights := []Highlight{}
+	for rows.Next() {
+		var h Highlight
+		var prefix, suffix, note, createdAt sql.NullString
+		if err := rows.Scan(&h.ID, &h.Link, &h.Title, &h.Quote, &prefix, &suffix, &note, &createdAt); err != nil {
+			return nil, err
+		}
+		h.Prefix, h.Suffix, h.Note, h.CreatedAt = prefix.String, suffix.String, note.String, createdAt.String
+		highlights = append(highlights, h)
+	}
+	return highlights, rows.Err()
+}
+
+// SetArticleNote stores the free-form note of an article; an empty note
+// deletes it.
+func (s *sqliteDB) SetArticleNote(link, note string) error {
+	if strings.TrimSpace(note) == "" {
+		_, err := s.db.Exec("DELETE FROM article_notes WHERE user_id = ? AND link = ?", s.userID(), link)
+		return err
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO article_notes (user_id, link, note, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id, link) DO UPDATE SET note = excluded.note, updated_at = excluded.updated_at`,
+		s.userID(), link, note, time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+// ArticleNotes returns article notes keyed by link, for one link or all.
+func (s *sqliteDB) ArticleNotes(link string) (map[string]string, error) {
+	query := "SELECT link, note FROM article_notes WHERE user_id = ?"
+	args := []interface{}{s.userID()}
+	if link != "" {
+		query += " AND link = ?"
+		args = append(args, link)
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	notes := map[string]string{}
+	for rows.Next() {
+		var l, note string
+		if err := rows.Scan(&l, &note); err != nil {
+			return nil, err
+		}
+		notes[l] = note
+	}
+	return notes, rows.Err()
+}
+
+// plainText flattens HTML to whitespace-normalized text, the form in which
+// highlight quotes and their context are matched.
+func plainText(src string) string {
+	nodes, err := html.ParseFragment(strings.NewReader(src), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+	if err != nil {
+		return normalizeSpace(src)
+	}
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			sb.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			if n.DataAtom == atom.Script || n.DataAtom == atom.Style {
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		// Separate block-level text so words don't run together.
+		if n.Type == html.ElementNode && !inlineElements[n.DataAtom] {
+			sb.WriteString(" ")
+		}
+	}
+	for _, n := range nodes {
+		walk(n)
+	}
+	return normalizeSpace(sb.String())
+}
+
+var inlineElements = map[atom.Atom]bool{
+	atom.A: true, atom.Abbr: true, atom.B: true, atom.Cite: true, atom.Code: true,
+	atom.Em: true, atom.I: true, atom.Kbd: true, atom.Mark: true, atom.Q: true,
+	atom.S: true, atom.Small: true, atom.Span: true, atom.Strong: true, atom.Sub: true,
+	atom.Sup: true, atom.U: true, atom.Time: true, atom.Del: true, atom.Ins: true,
+}
+
+func normalizeSpace(s string) string {
+	return strings.TrimSpace(spaceRe.ReplaceAllString(s, " "))
+}
+
+// locateQuote finds the quote in text, preferring the occurrence whose
+// surroundings best match prefix and suffix. It returns -1 if not found.
+func locateQuote(text, quote, prefix, suffix string) int {
+	quote, prefix, suffix = normalizeSpace(quote), normalizeSpace(prefix), normalizeSpace(suffix)
+	if quote == "" {
+		return -1
+	}
+	best, bestScore := -1, -1
+	for start := 0; ; {
+		i := strings.Index(text[start:], quote)
+		if i < 0 {
+			break
+		}
+		pos := start + i
+		before := strings.TrimSpace(text[:pos])
+		after := strings.TrimSpace(text[pos+len(quote):])
+		score := commonSuffixLen(before, prefix) + commonPrefixLen(after, suffix)
+		if score > bestScore {
+			best, bestScore = pos, score
+		}
+		start = pos + 1
+	}
+	return best
+}
+
+func commonPrefixLen(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+func commonSuffixLen(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+	return n
+}
+
+// anchorHighlights sets Offset on each highlight against current article content.
+func anchorHighlights(highlights []Highlight) {
+	texts := map[string]string{}
+	for i := range highlights {
+		h := &highlights[i]
+		text, ok := texts[h.Link]
+		if !ok {
+			var content, description sql.NullString
+			db.(*sqliteDB).db.QueryRow("SELECT content, description FROM articles WHERE link = ?", h.Link).Scan(&content, &description)
+			text = plainText(content.String + " " + description.String)
+			texts[h.Link] = text
+		}
+		h.Offset = locateQuote(text, h.Quote, h.Prefix, h.Suffix)
+	}
+}
+
+// highlightsHandler lists (GET ?link=), adds (POST), edits notes of (PATCH)
+// and deletes (DELETE) highlights.
+func highlightsHandler(w http.ResponseWriter, r *http.Request) {
+	db := userDB(r)
+	switch r.Method {
+	case http.MethodGet:
+		highlights, err := db.ListHighlights(r.URL.Query().Get("link"))
+		if err != nil {
+			http.Error(w, "Failed to list highlights", http.StatusInternalServerError)
+			return
+		}
+		anchorHighlights(highlights)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(highlights)
+	case http.MethodPost:
+		var h Highlight
+		if err := json.NewDecoder(r.Body).Decode(&h); err != nil || h.Link == "" || normalizeSpace(h.Quote) == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		h, err := db.AddHighlight(h)
+		if err != nil {
+			http.Error(w, "Failed to add highlight", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(h)
+	case http.MethodPatch:
+		var req struct {
+			ID   int    `json:"id"`
+			Note string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == 0 {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := db.SetHighlightNote(req.ID, req.Note); err != nil {
+			if errors.Is(err, errHighlightNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to update highlight", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		var req struct {
+			ID int `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == 0 {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := db.DeleteHighlight(req.ID); err != nil {
+			http.Error(w, "Failed to delete highlight", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// notesHandler reads (GET ?link=) and writes (PUT) article notes.
+func notesHandler(w http.ResponseWriter, r *http.Request) {
+	db := userDB(r)
+	switch r.Method {
+	case http.MethodGet:
+		notes, err := db.ArticleNotes(r.URL.Query().Get("link"))
+		if err != nil {
+			http.Error(w, "Failed to list notes", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(notes)
+	case http.MethodPut:
+		var req struct {
+			Link string `json:"link"`
+			Note string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Link == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := db.SetArticleNote(req.Link, req.Note); err != nil {
+			http.Error(w, "Failed to save note", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// highlightsExportHandler serves GET /highlights/export as Markdown, for one
+// article (?link=) or all annotated articles.
+func highlightsExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	db := userDB(r)
+	link := r.URL.Query().Get("link")
+	highlights, err := db.ListHighlights(link)
+	if err != nil {
+		http.Error(w, "Failed to list highlights", http.StatusInternalServerError)
+		return
+	}
+	notes, err := db.ArticleNotes(link)
+	if err != nil {
+		http.Error(w, "Failed to list notes", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="highlights.md"`)
+	WriteHighlightsMarkdown(w, highlights, notes)
+}
+
+// WriteHighlightsMarkdown writes highlights and article notes grouped by
+// article, each group headed by a link to its source.
+func WriteHighlightsMarkdown(w io.Writer, highlights []Highlight, notes map[string]string) error {
+	byLink := map[string][]Highlight{}
+	var links []string
+	for _, h := range highlights {
+		if _, ok := byLink[h.Link]; !ok {
+			links = append(links, h.Link)
+		}
+		byLink[h.Link] = append(byLink[h.Link], h)
+	}
+	var noteOnly []string
+	for link := range notes {
+		if _, ok := byLink[link]; !ok {
+			noteOnly = append(noteOnly, link)
+		}
+	}
+	sort.Strings(noteOnly)
+	links = append(links, noteOnly...)
+
+	// Highlights come with their article's title from ListHighlights; only
+	// the articles that just have a note are looked up.
+	titles := map[string]string{}
+	for _, h := range highlights {
+		titles[h.Link] = h.Title
+	}
+	if len(noteOnly) > 0 {
+		args := make([]interface{}, len(noteOnly))
+		for i, l := range noteOnly {
+			args[i] = l
+		}
+		rows, err := db.(*sqliteDB).db.Query("SELECT link, COALESCE(title, '') FROM articles WHERE link IN (?"+
+			strings.Repeat(", ?", len(noteOnly)-1)+")", args...)
+		if err == nil {
+			for rows.Next() {
+				var l, t string
+				if rows.Scan(&l, &t) == nil {
+					titles[l] = t
+				}
+			}
+			rows.Close()
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("# Highlights\n")
+	for _, link := range links {
+		title := titles[link]
+		if title == "" {
+			title = link
+		}
+		fmt.Fprintf(&sb, "\n## [%s](%s)\n", mdEscaper.Replace(title), link)
+		if note := notes[link]; note != "" {
+			fmt.Fprintf(&sb, "\n%s\n", strings.TrimSpace(note))
+		}
+		for _, h := range byLink[link] {
+			fmt.Fprintf(&sb, "\n%s\n", prefixLines(strings.TrimSpace(h.Quote), "> "))
+			if h.Note != "" {
+				fmt.Fprintf(&sb, "\n%s\n", strings.TrimSpace(h.Note))
+			}
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// historyMergeWindow joins repeated reads of an article (opening it again,
+// or a client reporting the reading time afterwards) into one entry.
+const historyMergeWindow = 30 * time.Minute
+
+var errHistoryNotFound = errors.New("history entry not found")
+
+// HistoryEntry is one reading of an article. Seconds is the time spent on
+// it as reported by the client, 0 when unknown. Title and Feed are empty
+// once the article is gone from the cache.
+type HistoryEntry struct {
+	ID      int    `json:"id"`
+	Link    string `json:"link"`
+	Title   string `json:"title"`
+	Feed    string `json:"feed"`
+	ReadAt  string `json:"read_at"`
+	Seconds int    `json:"seconds"`
+}
+
+func migrateHistory(db *sql.DB) error {
+	createHistory := `
+	CREATE TABLE IF NOT EXISTS read_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		link TEXT NOT NULL,
+		read_at TEXT NOT NULL,
+		seconds INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS read_history_read_at ON read_history(read_at);
+	CREATE INDEX IF NOT EXISTS read_history_link ON read_history(link, read_at);`
+	if _, err := db.Exec(createHistory); err != nil {
+		return err
+	}
+	if err := addColumn(db, "read_history", "user_id", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	_, err := db.Exec("CREATE INDEX IF NOT EXISTS read_history_user ON read_history(user_id, read_at)")
+	return err
+}
+
+// RecordRead adds a reading of link at t to the history, or adds seconds
+// to the entry of a reading within historyMergeWindow before t.
+func (s *sqliteDB) RecordRead(link string, seconds int, t time.Time) error {
+	t = t.UTC()
+	res, err := s.db.Exec(`UPDATE read_history SET seconds = seconds + ? WHERE id = (
+		SELECT id FROM read_history WHERE user_id = ? AND link = ? AND read_at >= ? ORDER BY read_at DESC LIMIT 1)`,
+		seconds, s.userID(), link, t.Add(-historyMergeWindow).Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	_, err = s.db.Exec("INSERT INTO read_history (user_id, link, read_at, seconds) VALUES (?, ?, ?, ?)",
+		s.userID(), link, t.Format(time.RFC3339), seconds)
+	return err
+}
+
+// History returns the readings in [from, to), latest first.
+func (s *sqliteDB) History(from, to time.Time) ([]HistoryEntry, error) {
+	rows, err := s.db.Query(`
+	SELECT read_history.id, read_history.link, COALESCE(articles.title, ''), COALESCE(articles.source, ''),
+		read_history.read_at, read_history.seconds
+	FROM read_history LEFT JOIN articles ON articles.link = read_history.link
+	WHERE read_history.user_id = ? AND read_history.read_at >= ? AND read_history.read_at < ?
+	ORDER BY read_history.read_at DESC, read_history.id DESC`,
+		s.userID(), from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []HistoryEntry{}
+	for rows.Next() {
+		var e HistoryEntry
+		if err := rows.Scan(&e.ID, &e.Link, &e.Title, &e.Feed, &e.ReadAt, &e.Seconds); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// HistoryBounds returns the time of the latest reading before t and of the
+// earliest one at or after t; either is "" when there is none.
+func (s *sqliteDB) HistoryBounds(t time.Time) (before, after string, err error) {
+	ts := t.UTC().Format(time.RFC3339)
+	var b, a sql.NullString
+	err = s.db.QueryRow(`SELECT (SELECT MAX(read_at) FROM read_history WHERE user_id = ? AND read_at < ?),
+		(SELECT MIN(read_at) FROM read_history WHERE user_id = ? AND read_at >= ?)`, s.userID(), ts, s.userID(), ts).Scan(&b, &a)
+	return b.String, a.String, err
+}
+
+// DeleteHistory removes one entry (id != 0), the readings before t
+// (t non-zero) or, with neither, the whole history. Read state is kept.
+func (s *sqliteDB) DeleteHistory(id int, before time.Time) error {
+	switch {
+	case id != 0:
+		res, err := s.db.Exec("DELETE FROM read_history WHERE id = ? AND user_id = ?", id, s.userID())
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errHistoryNotFound
+		}
+		return nil
+	case !before.IsZero():
+		_, err := s.db.Exec("DELETE FROM read_history WHERE user_id = ? AND read_at < ?", s.userID(), before.UTC().Format(time.RFC3339))
+		return err
+	}
+	_, err := s.db.Exec("DELETE FROM read_history WHERE user_id = ?", s.userID())
+	return err
+}
+
+// historyHandler serves the reading history one UTC day at a time and
+// clears it.
+//
+// GET /history?date=YYYY-MM-DD returns {"date", "entries", "previous",
+// "next"}, where previous and next are the closest earlier and later days
+// with readings. Without date the latest day with readings is returned.
+//
+// DELETE /history removes {"id"}, everything {"before"} a date, or with
+// {"all": true} the whole history.
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	db := userDB(r)
+	switch r.Method {
+	case http.MethodGet:
+		day, err := parseDateParam(r.URL.Query().Get("date"))
+		if err != nil {
+			http.Error(w, "Invalid date", http.StatusBadRequest)
+			return
+		}
+		if day.IsZero() {
+			latest, _, err := db.HistoryBounds(time.Now().Add(24 * time.Hour))
+			if err != nil {
+				http.Error(w, "Failed to read history", http.StatusInternalServerError)
+				return
+			}
+			day = time.Now()
+			if t, err := time.Parse(time.RFC3339, latest); err == nil {
+				day = t
+			}
+		}
+		start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+		end := start.AddDate(0, 0, 1)
+		entries, err := db.History(start, end)
+		if err != nil {
+			http.Error(w, "Failed to read history", http.StatusInternalServerError)
+			return
+		}
+		previous, _, err := db.HistoryBounds(start)
+		if err != nil {
+			http.Error(w, "Failed to read history", http.StatusInternalServerError)
+			return
+		}
+		_, next, err := db.HistoryBounds(end)
+		if err != nil {
+			http.Error(w, "Failed to read history", http.StatusInternalServerError)
+			return
+		}
+		resp := struct {
+			Date     string         `json:"date"`
+			Entries  []HistoryEntry `json:"entries"`
+			Previous string         `json:"previous,omitempty"`
+			Next     string         `json:"next,omitempty"`
+		}{Date: start.Format("2006-01-02"), Entries: entries}
+		if len(previous) >= 10 {
+			resp.Previous = previous[:10]
+		}
+		if len(next) >= 10 {
+			resp.Next = next[:10]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	case http.MethodDelete:
+		var req struct {
+			ID     int    `json:"id"`
+			Before string `json:"before"`
+			All    bool   `json:"all"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request", http.StatusBadRequest)
+				return
+			}
+		}
+		before, err := parseDateParam(req.Before)
+		if err != nil {
+			http.Error(w, "Invalid before", http.StatusBadRequest)
+			return
+		}
+		// As with /read/bulk, clearing everything must be asked for.
+		if req.ID == 0 && before.IsZero() && !req.All {
+			http.Error(w, "Select entries with id or before, or set all", http.StatusBadRequest)
+			return
+		}
+		if err := db.DeleteHistory(req.ID, before); err != nil {
+			if errors.Is(err, errHistoryNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to clear history", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
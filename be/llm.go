@@ -10,13 +10,16 @@ import (
 
 // To add this later
 func generateSummaryWithLlama(articleText string) (string, error) {
+	if config.LLM.URL == "" {
+		return "", fmt.Errorf("no llm.url configured")
+	}
 	payload := map[string]interface{}{
-		"model":      "your-model-name", // e.g., "llama-2-7b-chat"
+		"model":      config.LLM.Model, // e.g., "llama-2-7b-chat"
 		"prompt":     fmt.Sprintf("Summarize this news article in 2-3 sentences:\n\n%s", articleText),
 		"max_tokens": 200,
 	}
 	body, _ := json.Marshal(payload)
-	resp, err := http.Post("http://localhost:8083/v1/completions", "application/json", bytes.NewReader(body))
+	resp, err := http.Post(config.LLM.URL, "application/json", bytes.NewReader(body))
 	if err != nil {
 		return "", err
 	}
@@ -1,30 +1,177 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"strings"
 )
 
 var db DB // Global database interface
 
 func main() {
+	configPath := flag.String("config", "", "configuration file, TOML or YAML (default: $RSS_READER_CONFIG, or ./rss-reader.toml if present)")
+	var sets []configSetting
+	flag.Func("set", "set any configuration key, e.g. -set fetch.timeout=10s (repeatable)", func(v string) error {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return errors.New("use -set key=value")
+		}
+		sets = append(sets, configSetting{strings.TrimSpace(key), value, "flag -set"})
+		return nil
+	})
+	serverFlags(flag.CommandLine)
+	flag.Usage = usage
+	flag.Parse()
+	name, args := "serve", flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		flag.CommandLine.SetOutput(os.Stdout)
+		usage()
+		return
+	}
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+	flags := configFlagSettings(flag.CommandLine)
+	if cmd.run == nil {
+		// serve also takes the server flags after the command.
+		fs := flag.NewFlagSet("serve", flag.ExitOnError)
+		serverFlags(fs)
+		if rest := parseArgs(fs, args); len(rest) > 0 {
+			log.Fatalf("unexpected argument %q", rest[0])
+		}
+		flags = append(flags, configFlagSettings(fs)...)
+	}
+
 	var err error
-	db, err = NewSQLiteDB("./posts.db")
+	config, configSources, configFile, err = loadConfig(*configPath, append(flags, sets...))
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
+	}
+	if cmd.name == "config" {
+		// Showing the configuration doesn't need (or create) the database.
+		if err := cmd.run(args); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	db, err = NewSQLiteDB(config.DB)
+	if err != nil {
+		log.Fatal(err)
 	}
 	defer db.Close()
 
+	if cmd.run == nil {
+		err = serve()
+	} else {
+		err = cmd.run(args)
+	}
+	if err != nil {
+		db.Close()
+		log.Fatal(err)
+	}
+}
+
+// configFlags maps the flags that set a configuration key to the key.
+var configFlags = map[string]string{"db": "db", "addr": "addr", "no-auth": "no_auth"}
+
+// serverFlags adds the flags of configFlags to fs; their values are read
+// back by configFlagSettings.
+func serverFlags(fs *flag.FlagSet) {
+	defaults := defaultConfig()
+	fs.String("db", defaults.DB, "SQLite database file")
+	fs.String("addr", defaults.Addr, "address to listen on")
+	fs.Bool("no-auth", false, "serve requests without credentials (only with a loopback -addr)")
+}
+
+// configFlagSettings returns the configuration set by the flags given.
+func configFlagSettings(fs *flag.FlagSet) []configSetting {
+	var settings []configSetting
+	fs.Visit(func(f *flag.Flag) {
+		if key, ok := configFlags[f.Name]; ok {
+			settings = append(settings, configSetting{key, f.Value.String(), "flag -" + f.Name})
+		}
+	})
+	return settings
+}
+
+// serve runs the HTTP server.
+func serve() error {
+	http.HandleFunc("/auth/register", registerHandler)
+	http.HandleFunc("/auth/login", loginHandler)
+	http.HandleFunc("/auth/logout", logoutHandler)
+	http.HandleFunc("/auth/me", meHandler)
+	http.HandleFunc("/auth/password", passwordHandler)
+	http.HandleFunc("/users", usersHandler)
+	http.HandleFunc("/tokens", tokensHandler)
+	http.HandleFunc("/auth/fever", feverKeyHandler)
+	http.HandleFunc(feverPath, feverHandler)
+	http.HandleFunc(greaderLoginPath, greaderLoginHandler)
+	http.HandleFunc(greaderPrefix, greaderHandler)
 	http.HandleFunc("/posts", postsHandler)
+	http.HandleFunc("/counts", countsHandler)
+	http.HandleFunc("/search", searchHandler)
+	http.HandleFunc("/searches", searchesHandler)
+	http.HandleFunc("/rules", rulesHandler)
+	http.HandleFunc("/rules/test", rulesTestHandler)
+	http.HandleFunc("/alerts", alertsHandler)
+	http.HandleFunc("/alerts/history", alertHistoryHandler)
+	http.HandleFunc("/alerts/channels", channelsHandler)
+	http.HandleFunc("/alerts/channels/test", channelTestHandler)
+	http.HandleFunc("/webhooks", webhooksHandler)
+	http.HandleFunc("/webhooks/deliveries", webhookDeliveriesHandler)
 	http.HandleFunc("/feeds", feedsHandler)
+	http.HandleFunc("/opml", opmlHandler)
+	http.HandleFunc("/folders", foldersHandler)
+	http.HandleFunc("/folders/order", folderOrderHandler)
 	http.HandleFunc("/read", readHandler)
 	http.HandleFunc("/unread", readHandler)
+	http.HandleFunc("/read/bulk", bulkReadHandler)
+	http.HandleFunc("/read/undo", undoReadHandler)
+	http.HandleFunc("/history", historyHandler)
+	http.HandleFunc("/star", starHandler)
+	http.HandleFunc("/unstar", starHandler)
+	http.HandleFunc("/hide", hideHandler)
+	http.HandleFunc("/unhide", hideHandler)
+	http.HandleFunc("/tag", tagHandler)
+	http.HandleFunc("/untag", tagHandler)
+	http.HandleFunc("/tags", tagsHandler)
+	http.HandleFunc("/highlights", highlightsHandler)
+	http.HandleFunc("/highlights/export", highlightsExportHandler)
+	http.HandleFunc("/notes", notesHandler)
+	http.HandleFunc("/queue", queueHandler)
+	http.HandleFunc("/queue/pop", queuePopHandler)
+	http.HandleFunc("/queue/order", queueOrderHandler)
 	http.HandleFunc("/refresh", refreshHandler)
 	http.HandleFunc("/parse-article", ParseArticleHandler)
+	http.HandleFunc("/export/epub", exportEPUBHandler)
+	http.HandleFunc("/output-feeds", outputFeedsHandler)
+	http.HandleFunc("/output-feeds/rotate", outputFeedsHandler)
+	http.HandleFunc(outputFeedPrefix, republishHandler)
+	registerAPIV1()
+
+	go runAlertDispatcher()
+	go runWebhookDispatcher()
 
 	// Sample RSS feed
-	StartSampleFeeds()
+	if config.SampleFeeds.Enabled {
+		StartSampleFeeds(config.SampleFeeds.Ports)
+	}
 	// Sample RSS end
 
-	println("Server running at http://localhost:8080/")
-	http.ListenAndServe(":8080", nil)
+	host := config.Addr
+	if strings.HasPrefix(host, ":") {
+		host = "localhost" + host
+	}
+	println("Server running at http://" + host + "/")
+	return http.ListenAndServe(config.Addr, withJSONErrors(withAuth(http.DefaultServeMux, config.NoAuth)))
 }
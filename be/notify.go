@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// secretPlaceholder replaces tokens and passwords in API responses. Sending
+// it back in an update keeps the stored secret, unless the update also
+// changes where the channel delivers to.
+const secretPlaceholder = "********"
+
+var errChannelNotFound = errors.New("notification channel not found")
+
+// notifyClient is used for webhook and push deliveries.
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// NotifyChannel is a destination for alert notifications.
+//
+// Type is webhook (JSON POST to URL), ntfy (POST to the topic URL, with an
+// optional access Token), gotify (server URL and application Token) or
+// email (sent through SMTPHost:SMTPPort from From to To).
+type NotifyChannel struct {
+	ID       int      `json:"id"`
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	URL      string   `json:"url,omitempty"`
+	Token    string   `json:"token,omitempty"`
+	SMTPHost string   `json:"smtp_host,omitempty"`
+	SMTPPort int      `json:"smtp_port,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+}
+
+// Notification is one batch of alert matches.
+type Notification struct {
+	Alert   string       `json:"alert"`
+	Title   string       `json:"title"`
+	Text    string       `json:"text"`
+	Matches []AlertMatch `json:"matches"`
+}
+
+// ListNotifyChannels returns the user's channels.
+func (s *sqliteDB) ListNotifyChannels() ([]NotifyChannel, error) {
+	rows, err := s.db.Query("SELECT id, config FROM notification_channels WHERE user_id = ? ORDER BY id", s.userID())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	channels := []NotifyChannel{}
+	for rows.Next() {
+		var id int
+		var config string
+		if err := rows.Scan(&id, &config); err != nil {
+			return nil, err
+		}
+		var ch NotifyChannel
+		if err := json.Unmarshal([]byte(config), &ch); err != nil {
+			return nil, err
+		}
+		ch.ID = id
+		channels = append(channels, ch)
+	}
+	return channels, rows.Err()
+}
+
+func (s *sqliteDB) CreateNotifyChannel(ch NotifyChannel) (NotifyChannel, error) {
+	config, err := json.Marshal(ch)
+	if err != nil {
+		return ch, err
+	}
+	res, err := s.db.Exec("INSERT INTO notification_channels (user_id, config) VALUES (?, ?)", s.userID(), string(config))
+	if err != nil {
+		return ch, err
+	}
+	id, err := res.LastInsertId()
+	ch.ID = int(id)
+	return ch, err
+}
+
+func (s *sqliteDB) UpdateNotifyChannel(ch NotifyChannel) error {
+	config, err := json.Marshal(ch)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec("UPDATE notification_channels SET config = ? WHERE id = ? AND user_id = ?", string(config), ch.ID, s.userID())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errChannelNotFound
+	}
+	return nil
+}
+
+func (s *sqliteDB) DeleteNotifyChannel(id int) error {
+	res, err := s.db.Exec("DELETE FROM notification_channels WHERE id = ? AND user_id = ?", id, s.userID())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errChannelNotFound
+	}
+	return nil
+}
+
+// validate checks that the channel has what its type needs.
+func (ch *NotifyChannel) validate() error {
+	ch.Name = strings.TrimSpace(ch.Name)
+	if ch.Name == "" {
+		return errors.New("name is required")
+	}
+	switch ch.Type {
+	case "webhook", "ntfy":
+		if !validFeedURL(ch.URL) {
+			return errors.New("url must be an http(s) URL")
+		}
+	case "gotify":
+		if !validFeedURL(ch.URL) || ch.Token == "" {
+			return errors.New("gotify needs the server url and an application token")
+		}
+	case "email":
+		if ch.SMTPHost == "" || ch.From == "" || len(ch.To) == 0 {
+			return errors.New("email needs smtp_host, from and to")
+		}
+		if ch.SMTPPort == 0 {
+			ch.SMTPPort = 587
+		}
+	default:
+		return fmt.Errorf("unknown channel type %q", ch.Type)
+	}
+	return nil
+}
+
+// redacted returns the channel with its secrets replaced by secretPlaceholder.
+func (ch NotifyChannel) redacted() NotifyChannel {
+	if ch.Token != "" {
+		ch.Token = secretPlaceholder
+	}
+	if ch.Password != "" {
+		ch.Password = secretPlaceholder
+	}
+	return ch
+}
+
+// send delivers n to the channel.
+func (ch NotifyChannel) send(n Notification) error {
+	switch ch.Type {
+	case "webhook":
+		body, err := json.Marshal(n)
+		if err != nil {
+			return err
+		}
+		return notifyPost(ch.URL, "application/json", body, nil)
+	case "ntfy":
+		headers := map[string]string{"Title": mime.QEncoding.Encode("utf-8", n.Title)}
+		if ch.Token != "" {
+			headers["Authorization"] = "Bearer " + ch.Token
+		}
+		return notifyPost(ch.URL, "text/plain; charset=utf-8", []byte(n.Text), headers)
+	case "gotify":
+		body, err := json.Marshal(map[string]interface{}{"title": n.Title, "message": n.Text, "priority": 5})
+		if err != nil {
+			return err
+		}
+		return notifyPost(strings.TrimRight(ch.URL, "/")+"/message", "application/json", body,
+			map[string]string{"X-Gotify-Key": ch.Token})
+	case "email":
+		return ch.sendEmail(n)
+	}
+	return fmt.Errorf("unknown channel type %q", ch.Type)
+}
+
+func notifyPost(url, contentType string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// sendEmail sends n as a plain text mail. net/smtp upgrades to TLS with
+// STARTTLS when the server offers it.
+func (ch NotifyChannel) sendEmail(n Notification) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", ch.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(ch.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", n.Title))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(n.Text, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if ch.Username != "" {
+		auth = smtp.PlainAuth("", ch.Username, ch.Password, ch.SMTPHost)
+	}
+	addr := net.JoinHostPort(ch.SMTPHost, strconv.Itoa(ch.SMTPPort))
+	return smtp.SendMail(addr, auth, ch.From, ch.To, msg.Bytes())
+}
+
+// channelsHandler lists (GET), creates (POST), replaces (PATCH) and deletes
+// (DELETE) the user's notification channels. Secrets are never returned.
+func channelsHandler(w http.ResponseWriter, r *http.Request) {
+	db := userDB(r)
+	switch r.Method {
+	case http.MethodGet:
+		channels, err := db.ListNotifyChannels()
+		if err != nil {
+			http.Error(w, "Failed to list channels", http.StatusInternalServerError)
+			return
+		}
+		for i := range channels {
+			channels[i] = channels[i].redacted()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(channels)
+	case http.MethodPost, http.MethodPatch:
+		var ch NotifyChannel
+		if err := json.NewDecoder(r.Body).Decode(&ch); err != nil || (r.Method == http.MethodPatch && ch.ID == 0) {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodPatch {
+			if err := keepSecrets(db, &ch); err != nil {
+				channelError(w, err)
+				return
+			}
+		}
+		if err := ch.validate(); err != nil {
+			http.Error(w, "Invalid channel: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodPatch {
+			if err := db.UpdateNotifyChannel(ch); err != nil {
+				channelError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		ch, err := db.CreateNotifyChannel(ch)
+		if err != nil {
+			channelError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(ch.redacted())
+	case http.MethodDelete:
+		var req struct {
+			ID int `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == 0 {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := db.DeleteNotifyChannel(req.ID); err != nil {
+			channelError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// keepSecrets restores stored secrets that an update of one of the user's
+// channels sent back redacted. If the update changes the type, url or
+// smtp_host, the secrets are dropped instead, so they are never sent to a
+// new destination without being entered again.
+func keepSecrets(d DB, ch *NotifyChannel) error {
+	if ch.Token != secretPlaceholder && ch.Password != secretPlaceholder {
+		return nil
+	}
+	channels, err := d.ListNotifyChannels()
+	if err != nil {
+		return err
+	}
+	for _, stored := range channels {
+		if stored.ID != ch.ID {
+			continue
+		}
+		if stored.Type != ch.Type || stored.URL != ch.URL || stored.SMTPHost != ch.SMTPHost {
+			stored.Token, stored.Password = "", ""
+		}
+		if ch.Token == secretPlaceholder {
+			ch.Token = stored.Token
+		}
+		if ch.Password == secretPlaceholder {
+			ch.Password = stored.Password
+		}
+		return nil
+	}
+	return errChannelNotFound
+}
+
+// channelTestHandler handles POST /alerts/channels/test with {"id"},
+// sending a test notification through the channel.
+func channelTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == 0 {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	channels, err := userDB(r).ListNotifyChannels()
+	if err != nil {
+		http.Error(w, "Failed to list channels", http.StatusInternalServerError)
+		return
+	}
+	for _, ch := range channels {
+		if ch.ID != req.ID {
+			continue
+		}
+		n := Notification{Alert: "test", Title: "RSS Reader test notification", Text: "Notifications from RSS Reader Go arrive here.", Matches: []AlertMatch{}}
+		if err := ch.send(n); err != nil {
+			http.Error(w, "Delivery failed: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	channelError(w, errChannelNotFound)
+}
+
+func channelError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errChannelNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Failed to update channels", http.StatusInternalServerError)
+}
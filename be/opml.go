@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+// maxOPMLSize bounds uploaded OPML files.
+const maxOPMLSize = 10 << 20
+
+// opmlDoc is the OPML document written by GET /opml.
+type opmlDoc struct {
+	XMLName xml.Name      `xml:"opml"`
+	Version string        `xml:"version,attr"`
+	Head    opmlHead      `xml:"head"`
+	Body    []opmlOutline `xml:"body>outline"`
+}
+
+type opmlHead struct {
+	Title       string `xml:"title"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr,omitempty"`
+	Type     string        `xml:"type,attr,omitempty"`
+	XMLURL   string        `xml:"xmlUrl,attr,omitempty"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+// opmlInputOutline is used for parsing. Attributes are kept generically
+// because OPML 1.0 exporters disagree on casing (xmlUrl, xmlurl, url).
+type opmlInputOutline struct {
+	Attrs    []xml.Attr         `xml:",any,attr"`
+	Outlines []opmlInputOutline `xml:"outline"`
+}
+
+func (o opmlInputOutline) attr(names ...string) string {
+	for _, name := range names {
+		for _, a := range o.Attrs {
+			if strings.EqualFold(a.Name.Local, name) {
+				return strings.TrimSpace(a.Value)
+			}
+		}
+	}
+	return ""
+}
+
+// OPMLImportResult reports what happened to one feed in an imported file.
+type OPMLImportResult struct {
+	URL    string `json:"url"`
+	Name   string `json:"name"`
+	Folder string `json:"folder,omitempty"` // folder path, e.g. "Tech/Go"
+	Status string `json:"status"`           // added, skipped or invalid
+	Reason string `json:"reason,omitempty"`
+}
+
+// OPMLImportReport summarizes an import.
+type OPMLImportReport struct {
+	Added   int                `json:"added"`
+	Skipped int                `json:"skipped"`
+	Invalid int                `json:"invalid"`
+	Feeds   []OPMLImportResult `json:"feeds"`
+}
+
+// opmlHandler exports (GET) or imports (POST) the subscription list.
+func opmlHandler(w http.ResponseWriter, r *http.Request) {
+	db := userDB(r)
+	switch r.Method {
+	case http.MethodGet:
+		feeds, err := db.ListFeeds()
+		if err != nil {
+			http.Error(w, "Failed to list feeds", http.StatusInternalServerError)
+			return
+		}
+		folders, err := db.ListFolders()
+		if err != nil {
+			http.Error(w, "Failed to list folders", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="subscriptions.opml"`)
+		WriteOPML(w, folders, feeds)
+	case http.MethodPost:
+		body, err := opmlUpload(r)
+		if err != nil {
+			http.Error(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer body.Close()
+		report, err := ImportOPML(db, io.LimitReader(body, maxOPMLSize))
+		if err != nil {
+			http.Error(w, "Invalid OPML: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// opmlUpload returns the OPML document from either a multipart form field
+// named "file" or the raw request body.
+func opmlUpload(r *http.Request) (io.ReadCloser, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxOPMLSize); err != nil {
+			return nil, err
+		}
+		f, _, err := r.FormFile("file")
+		return f, err
+	}
+	return r.Body, nil
+}
+
+// WriteOPML writes feeds as an OPML 2.0 document, nesting them in outlines
+// that follow the folder tree. Within a folder, feeds come before subfolders;
+// folders without feeds are left out.
+func WriteOPML(w io.Writer, folders []Folder, feeds []Feed) error {
+	doc := opmlDoc{
+		Version: "2.0",
+		Head: opmlHead{
+			Title:       "RSS Reader Go subscriptions",
+			DateCreated: time.Now().UTC().Format(time.RFC1123Z),
+		},
+	}
+	// Children by parent folder ID, 0 for the top level.
+	feedsIn := map[int][]Feed{}
+	for _, f := range feeds {
+		parent := 0
+		if f.FolderID != nil {
+			parent = *f.FolderID
+		}
+		feedsIn[parent] = append(feedsIn[parent], f)
+	}
+	foldersIn := map[int][]Folder{}
+	for _, f := range folders {
+		parent := 0
+		if f.ParentID != nil {
+			parent = *f.ParentID
+		}
+		foldersIn[parent] = append(foldersIn[parent], f)
+	}
+	var outlines func(parent int) []opmlOutline
+	outlines = func(parent int) []opmlOutline {
+		var out []opmlOutline
+		for _, f := range feedsIn[parent] {
+			out = append(out, opmlOutline{
+				Text:   f.FeedName,
+				Title:  f.FeedName,
+				Type:   "rss",
+				XMLURL: f.URL,
+			})
+		}
+		for _, f := range foldersIn[parent] {
+			if children := outlines(f.ID); len(children) > 0 {
+				out = append(out, opmlOutline{Text: f.Name, Title: f.Name, Outlines: children})
+			}
+		}
+		return out
+	}
+	doc.Body = outlines(0)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
+
+// ImportOPML subscribes the user of d to the feeds of an OPML 1.0 or 2.0
+// document. Nested outlines become folders; URLs that are already
+// subscribed are skipped.
+func ImportOPML(d DB, r io.Reader) (OPMLImportReport, error) {
+	var doc struct {
+		Body struct {
+			Outlines []opmlInputOutline `xml:"outline"`
+		} `xml:"body"`
+	}
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	dec.CharsetReader = charset.NewReaderLabel
+	if err := dec.Decode(&doc); err != nil {
+		return OPMLImportReport{}, err
+	}
+
+	report := OPMLImportReport{Feeds: []OPMLImportResult{}}
+	existing := map[string]bool{}
+	feeds, err := d.ListFeeds()
+	if err != nil {
+		return report, err
+	}
+	for _, f := range feeds {
+		existing[f.URL] = true
+	}
+
+	var walk func(outlines []opmlInputOutline, groups []string)
+	walk = func(outlines []opmlInputOutline, groups []string) {
+		for _, o := range outlines {
+			name := o.attr("text", "title")
+			feedURL := o.attr("xmlUrl", "url")
+			if feedURL == "" {
+				if len(o.Outlines) > 0 {
+					group := groups
+					if name != "" {
+						group = append(append([]string{}, groups...), strings.ReplaceAll(name, "/", "-"))
+					}
+					walk(o.Outlines, group)
+				}
+				continue
+			}
+
+			res := OPMLImportResult{URL: feedURL, Name: name, Folder: strings.Join(groups, "/")}
+			if res.Folder == "" {
+				// OPML 1.0 "category" attribute, e.g. "/Tech/Go,/Other".
+				res.Folder = strings.Trim(strings.Split(o.attr("category"), ",")[0], "/ ")
+			}
+			if res.Name == "" {
+				res.Name = feedURL
+			}
+			switch {
+			case !validFeedURL(feedURL):
+				res.Status, res.Reason = "invalid", "not an http(s) URL"
+				report.Invalid++
+			case existing[feedURL]:
+				res.Status, res.Reason = "skipped", "already subscribed"
+				report.Skipped++
+			default:
+				err := d.AddFeed(feedURL, res.Name)
+				if err == nil && res.Folder != "" {
+					err = d.SetFeedFolder(feedURL, res.Folder)
+				}
+				if err != nil {
+					res.Status, res.Reason = "invalid", err.Error()
+					report.Invalid++
+					break
+				}
+				existing[feedURL] = true
+				emitEvent(d, EventFeedAdded, feedEventData{URL: feedURL, Name: res.Name})
+				res.Status = "added"
+				report.Added++
+			}
+			report.Feeds = append(report.Feeds, res)
+		}
+	}
+	walk(doc.Body.Outlines, nil)
+	return report, nil
+}
+
+func validFeedURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// outputTokenSetting is the settings key holding the secret that protects
+// republished feeds; see outputTokenKey.
+const outputTokenSetting = "output_feed_token"
+
+// Output feeds are served from /out/{token}/{scope}.{ext} where scope is
+// "all", "unread" or "feeds/{id}" and ext is rss, atom or json.
+const (
+	outputFeedPrefix   = "/out/"
+	defaultOutputItems = 50
+	maxOutputItems     = 500
+)
+
+// randomToken returns n random bytes, hex encoded.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// outputTokenKey is the settings key of user's republishing token. The
+// default user keeps the key used before accounts existed, so published
+// URLs survive creating the first account.
+func outputTokenKey(user int) string {
+	if user == defaultUserID {
+		return outputTokenSetting
+	}
+	return outputTokenSetting + ":" + strconv.Itoa(user)
+}
+
+// outputFeedToken returns user's republishing token, creating one on first
+// use.
+func outputFeedToken(user int) (string, error) {
+	token, err := db.GetSetting(outputTokenKey(user))
+	if err != nil || token != "" {
+		return token, err
+	}
+	return rotateOutputFeedToken(user)
+}
+
+// rotateOutputFeedToken replaces user's token, invalidating all their
+// published URLs.
+func rotateOutputFeedToken(user int) (string, error) {
+	token, err := randomToken(24)
+	if err != nil {
+		return "", err
+	}
+	return token, db.SetSetting(outputTokenKey(user), token)
+}
+
+// outputFeedUser returns the user whose republishing token is token, or 0
+// if there is none.
+func outputFeedUser(token string) (int, error) {
+	users := []int{defaultUserID}
+	accounts, err := db.ListUsers()
+	if err != nil {
+		return 0, err
+	}
+	for _, u := range accounts {
+		if u.ID != defaultUserID {
+			users = append(users, u.ID)
+		}
+	}
+	found := 0
+	for _, u := range users {
+		expected, err := db.GetSetting(outputTokenKey(u))
+		if err != nil {
+			return 0, err
+		}
+		// Every token is compared so the time taken doesn't tell which matched.
+		if expected != "" && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+			found = u
+		}
+	}
+	return found, nil
+}
+
+// outputFeedsHandler lists the republished feed URLs (GET) or rotates the
+// token (POST /output-feeds/rotate).
+func outputFeedsHandler(w http.ResponseWriter, r *http.Request) {
+	var token string
+	var err error
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/output-feeds":
+		token, err = outputFeedToken(requestUserID(r))
+	case r.Method == http.MethodPost && r.URL.Path == "/output-feeds/rotate":
+		token, err = rotateOutputFeedToken(requestUserID(r))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to load output feed token", http.StatusInternalServerError)
+		return
+	}
+	feeds, err := userDB(r).ListFeeds()
+	if err != nil {
+		http.Error(w, "Failed to list feeds", http.StatusInternalServerError)
+		return
+	}
+
+	base := baseURL(r) + outputFeedPrefix + token + "/"
+	urls := func(scope string) map[string]string {
+		return map[string]string{
+			"rss":  base + scope + ".rss",
+			"atom": base + scope + ".atom",
+			"json": base + scope + ".json",
+		}
+	}
+	type feedURLs struct {
+		ID       int               `json:"id"`
+		FeedName string            `json:"feed_name"`
+		URLs     map[string]string `json:"urls"`
+	}
+	response := struct {
+		All    map[string]string `json:"all"`
+		Unread map[string]string `json:"unread"`
+		Feeds  []feedURLs        `json:"feeds"`
+	}{
+		All:    urls("all"),
+		Unread: urls("unread"),
+		Feeds:  []feedURLs{},
+	}
+	for _, f := range feeds {
+		response.Feeds = append(response.Feeds, feedURLs{
+			ID:       f.ID,
+			FeedName: f.FeedName,
+			URLs:     urls("feeds/" + strconv.Itoa(f.ID)),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// outputFeed describes one republished feed before it is encoded.
+type outputFeed struct {
+	Title    string
+	SelfURL  string
+	HomeURL  string
+	ID       string
+	Articles []Post
+	Names    map[string]string // feed URL -> feed name
+}
+
+// republishHandler serves GET /out/{token}/{scope}.{rss|atom|json}.
+func republishHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token, scope, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, outputFeedPrefix), "/")
+	user, err := outputFeedUser(token)
+	if err != nil {
+		http.Error(w, "Failed to load output feed token", http.StatusInternalServerError)
+		return
+	}
+	if !ok || user == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	dot := strings.LastIndex(scope, ".")
+	if dot < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	scope, ext := scope[:dot], scope[dot+1:]
+
+	limit := defaultOutputItems
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			limit = min(n, maxOutputItems)
+		}
+	}
+
+	feeds, err := db.ForUser(user).ListFeeds()
+	if err != nil {
+		http.Error(w, "Failed to list feeds", http.StatusInternalServerError)
+		return
+	}
+	out := outputFeed{
+		SelfURL: baseURL(r) + r.URL.Path,
+		HomeURL: baseURL(r) + "/",
+		ID:      "urn:rssreadergo:" + strings.ReplaceAll(scope, "/", ":"),
+		Names:   map[string]string{},
+	}
+	for _, f := range feeds {
+		out.Names[f.URL] = f.FeedName
+	}
+	filter := ArticleFilter{User: user, Limit: limit}
+	switch {
+	case scope == "all":
+		out.Title = "RSS Reader Go – All articles"
+	case scope == "unread":
+		out.Title = "RSS Reader Go – Unread articles"
+		filter.Unread = true
+	case strings.HasPrefix(scope, "feeds/"):
+		id, err := strconv.Atoi(strings.TrimPrefix(scope, "feeds/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		for _, f := range feeds {
+			if f.ID == id {
+				out.Title = "RSS Reader Go – " + f.FeedName
+				filter.Feed = f.URL
+				out.HomeURL = f.URL
+			}
+		}
+		if filter.Feed == "" {
+			http.NotFound(w, r)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	out.Articles, err = QueryArticles(filter)
+	if err != nil {
+		http.Error(w, "Failed to fetch cached articles", http.StatusInternalServerError)
+		return
+	}
+
+	switch ext {
+	case "rss":
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		err = writeRSS(w, out)
+	case "atom":
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		err = writeAtom(w, out)
+	case "json":
+		w.Header().Set("Content-Type", "application/feed+json; charset=utf-8")
+		err = writeJSONFeed(w, out)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to write %s feed: %v", ext, err)
+	}
+}
+
+// baseURL returns the scheme and host the client used to reach the server.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// articleTime returns the article's publish date, falling back to when it
+// was fetched. ok is false if neither can be parsed.
+func articleTime(p Post) (t time.Time, ok bool) {
+	for _, s := range []string{p.PubDate, p.FetchedAt} {
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// articleGUID is the stable identifier of an article in republished feeds.
+func articleGUID(p Post) (guid string, permalink bool) {
+	if strings.HasPrefix(p.Link, "http://") || strings.HasPrefix(p.Link, "https://") {
+		return p.Link, true
+	}
+	return fmt.Sprintf("urn:rssreadergo:article:%d", p.ID), false
+}
+
+// newestTime returns the most recent article time, or now if there is none.
+func (o outputFeed) newestTime() time.Time {
+	newest := time.Time{}
+	for _, a := range o.Articles {
+		if t, ok := articleTime(a); ok && t.After(newest) {
+			newest = t
+		}
+	}
+	if newest.IsZero() {
+		return time.Now().UTC()
+	}
+	return newest
+}
+
+// RSS 2.0
+
+type rssDoc struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	AtomNS    string     `xml:"xmlns:atom,attr"`
+	ContentNS string     `xml:"xmlns:content,attr"`
+	Channel   rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	AtomLink      atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Generator     string    `xml:"generator"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link,omitempty"`
+	Description string        `xml:"description"`
+	Content     string        `xml:"content:encoded,omitempty"`
+	GUID        rssGUID       `xml:"guid"`
+	PubDate     string        `xml:"pubDate,omitempty"`
+	Source      *rssSource    `xml:"source,omitempty"`
+	Enclosure   *rssEnclosure `xml:"enclosure,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssSource struct {
+	URL  string `xml:"url,attr"`
+	Name string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length string `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+func writeRSS(w http.ResponseWriter, o outputFeed) error {
+	doc := rssDoc{
+		Version:   "2.0",
+		AtomNS:    "http://www.w3.org/2005/Atom",
+		ContentNS: "http://purl.org/rss/1.0/modules/content/",
+		Channel: rssChannel{
+			Title:         o.Title,
+			Link:          o.HomeURL,
+			Description:   o.Title,
+			AtomLink:      atomLink{Rel: "self", Href: o.SelfURL, Type: "application/rss+xml"},
+			LastBuildDate: o.newestTime().Format(time.RFC1123Z),
+			Generator:     "RSS Reader Go",
+		},
+	}
+	for _, a := range o.Articles {
+		guid, permalink := articleGUID(a)
+		item := rssItem{
+			Title:       a.Title,
+			Link:        a.Link,
+			Description: a.Description,
+			Content:     a.Content,
+			GUID:        rssGUID{IsPermaLink: permalink, Value: guid},
+			Source:      &rssSource{URL: a.Source, Name: o.Names[a.Source]},
+		}
+		if item.Description == "" {
+			item.Description = a.Content
+		}
+		if t, ok := articleTime(a); ok {
+			item.PubDate = t.Format(time.RFC1123Z)
+		}
+		if a.Enclosure != nil {
+			length := a.Enclosure.Length
+			if length == "" {
+				length = "0" // required by the spec even when unknown
+			}
+			item.Enclosure = &rssEnclosure{URL: a.Enclosure.URL, Length: length, Type: a.Enclosure.Type}
+		}
+		doc.Channel.Items = append(doc.Channel.Items, item)
+	}
+	return writeXML(w, doc)
+}
+
+// Atom 1.0
+
+type atomFeed struct {
+	XMLName   xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Updated   string      `xml:"updated"`
+	Links     []atomLink  `xml:"link"`
+	Author    atomPerson  `xml:"author"`
+	Generator string      `xml:"generator"`
+	Entries   []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Updated   string      `xml:"updated"`
+	Published string      `xml:"published,omitempty"`
+	Links     []atomLink  `xml:"link"`
+	Summary   *atomText   `xml:"summary,omitempty"`
+	Content   *atomText   `xml:"content,omitempty"`
+	Source    *atomSource `xml:"source,omitempty"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length string `xml:"length,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomSource struct {
+	ID    string   `xml:"id"`
+	Title string   `xml:"title"`
+	Link  atomLink `xml:"link"`
+}
+
+func writeAtom(w http.ResponseWriter, o outputFeed) error {
+	feed := atomFeed{
+		Title:   o.Title,
+		ID:      o.ID,
+		Updated: o.newestTime().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Href: o.SelfURL, Type: "application/atom+xml"},
+			{Rel: "alternate", Href: o.HomeURL},
+		},
+		Author:    atomPerson{Name: "RSS Reader Go"},
+		Generator: "RSS Reader Go",
+	}
+	for _, a := range o.Articles {
+		id, _ := articleGUID(a)
+		entry := atomEntry{
+			Title:  a.Title,
+			ID:     id,
+			Source: &atomSource{ID: a.Source, Title: o.Names[a.Source], Link: atomLink{Rel: "self", Href: a.Source}},
+		}
+		// updated is required; use the feed's time when the article has none.
+		if t, ok := articleTime(a); ok {
+			entry.Updated = t.Format(time.RFC3339)
+		} else {
+			entry.Updated = feed.Updated
+		}
+		if t, err := time.Parse(time.RFC3339, a.PubDate); err == nil {
+			entry.Published = t.Format(time.RFC3339)
+		}
+		if a.Link != "" {
+			entry.Links = append(entry.Links, atomLink{Rel: "alternate", Href: a.Link})
+		}
+		if a.Enclosure != nil {
+			entry.Links = append(entry.Links, atomLink{
+				Rel:    "enclosure",
+				Href:   a.Enclosure.URL,
+				Type:   a.Enclosure.Type,
+				Length: a.Enclosure.Length,
+			})
+		}
+		if a.Description != "" {
+			entry.Summary = &atomText{Type: "html", Body: a.Description}
+		}
+		if a.Content != "" {
+			entry.Content = &atomText{Type: "html", Body: a.Content}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return writeXML(w, feed)
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) error {
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(v)
+}
+
+// JSON Feed 1.1
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url,omitempty"`
+	FeedURL     string         `json:"feed_url"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string               `json:"id"`
+	URL           string               `json:"url,omitempty"`
+	Title         string               `json:"title,omitempty"`
+	ContentHTML   string               `json:"content_html,omitempty"`
+	ContentText   string               `json:"content_text,omitempty"`
+	Summary       string               `json:"summary,omitempty"`
+	DatePublished string               `json:"date_published,omitempty"`
+	Authors       []jsonFeedAuthor     `json:"authors,omitempty"`
+	Attachments   []jsonFeedAttachment `json:"attachments,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+type jsonFeedAttachment struct {
+	URL         string `json:"url"`
+	MimeType    string `json:"mime_type"`
+	SizeInBytes int64  `json:"size_in_bytes,omitempty"`
+}
+
+func writeJSONFeed(w http.ResponseWriter, o outputFeed) error {
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       o.Title,
+		HomePageURL: o.HomeURL,
+		FeedURL:     o.SelfURL,
+		Items:       []jsonFeedItem{},
+	}
+	for _, a := range o.Articles {
+		id, _ := articleGUID(a)
+		item := jsonFeedItem{
+			ID:          id,
+			URL:         a.Link,
+			Title:       a.Title,
+			ContentHTML: a.Content,
+			Summary:     RenderHTML(a.Description, FormatText),
+			Authors:     []jsonFeedAuthor{{Name: o.Names[a.Source], URL: a.Source}},
+		}
+		if item.ContentHTML == "" {
+			item.ContentHTML = a.Description
+		}
+		if item.ContentHTML == "" {
+			// Items must carry either content_html or content_text.
+			item.ContentText = a.Title
+		}
+		if t, ok := articleTime(a); ok {
+			item.DatePublished = t.Format(time.RFC3339)
+		}
+		if a.Enclosure != nil {
+			size, _ := strconv.ParseInt(a.Enclosure.Length, 10, 64)
+			mimeType := a.Enclosure.Type
+			if mimeType == "" {
+				mimeType = "application/octet-stream"
+			}
+			item.Attachments = []jsonFeedAttachment{{URL: a.Enclosure.URL, MimeType: mimeType, SizeInBytes: size}}
+		}
+		feed.Items = append(feed.Items, item)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(feed)
+}
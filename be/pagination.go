@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// maxPageSize caps the limit parameter of GET /posts.
+const maxPageSize = 500
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// articleCursor is the position of the last article of a page: its sort
+// date (see articleDateExpr) and ID, which breaks ties between articles
+// published at the same time. Oldest records the sort order it belongs to.
+type articleCursor struct {
+	Date   string `json:"d"`
+	ID     int    `json:"i"`
+	Oldest bool   `json:"o,omitempty"`
+}
+
+// cursorAfter returns the cursor continuing a listing after p.
+func cursorAfter(p Post, oldest bool) *articleCursor {
+	date := p.PubDate
+	if date == "" {
+		date = p.FetchedAt
+	}
+	return &articleCursor{Date: date, ID: p.ID, Oldest: oldest}
+}
+
+// String encodes the cursor for clients, which should treat it as opaque.
+func (c *articleCursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func parseCursor(s string) (*articleCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var c articleCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == 0 {
+		return nil, errInvalidCursor
+	}
+	return &c, nil
+}
+
+// parsePostsQuery reads the filter, sort and page parameters of GET /posts:
+//
+//	feed           feed URL
+//	folder         folder ID, including subfolders
+//	tag            tag name
+//	unread, read   only unread or read articles
+//	starred        only starred articles
+//	hidden         only hidden articles
+//	has_enclosure  only articles with an enclosure
+//	since, until   date range (RFC3339 or YYYY-MM-DD), until exclusive
+//	sort           newest (default) or oldest
+//	limit          page size, at most maxPageSize
+//	cursor         next_cursor of the previous page
+func parsePostsQuery(q url.Values) (ArticleFilter, error) {
+	f := ArticleFilter{
+		Feed:      q.Get("feed"),
+		Tag:       q.Get("tag"),
+		Unread:    isTrue(q.Get("unread")),
+		Read:      isTrue(q.Get("read")),
+		Starred:   isTrue(q.Get("starred")),
+		Hidden:    isTrue(q.Get("hidden")),
+		Enclosure: isTrue(q.Get("has_enclosure")),
+	}
+	var err error
+	if folder := q.Get("folder"); folder != "" {
+		if f.Folder, err = strconv.Atoi(folder); err != nil {
+			return f, errors.New("invalid folder")
+		}
+	}
+	if f.Since, err = parseDateParam(q.Get("since")); err != nil {
+		return f, fmt.Errorf("invalid since: %w", err)
+	}
+	if f.Until, err = parseDateParam(q.Get("until")); err != nil {
+		return f, fmt.Errorf("invalid until: %w", err)
+	}
+	switch q.Get("sort") {
+	case "", "newest":
+	case "oldest":
+		f.Oldest = true
+	default:
+		return f, errors.New("invalid sort: use newest or oldest")
+	}
+	if limit := q.Get("limit"); limit != "" {
+		if f.Limit, err = strconv.Atoi(limit); err != nil || f.Limit < 1 || f.Limit > maxPageSize {
+			return f, fmt.Errorf("invalid limit: use 1 to %d", maxPageSize)
+		}
+	}
+	if cursor := q.Get("cursor"); cursor != "" {
+		if f.After, err = parseCursor(cursor); err != nil {
+			return f, err
+		}
+		if f.After.Oldest != f.Oldest {
+			return f, fmt.Errorf("%w: it belongs to a different sort order", errInvalidCursor)
+		}
+	}
+	return f, nil
+}
+
+// articlePage returns one page of the articles matching f, the total number
+// of matching articles regardless of paging, and the cursor of the next
+// page ("" on the last page).
+func articlePage(f ArticleFilter) ([]Post, int, string, error) {
+	fetch := f
+	if fetch.Limit > 0 {
+		fetch.Limit++ // one more tells whether a next page exists
+	}
+	articles, err := QueryArticles(fetch)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	all := f
+	all.After, all.Limit = nil, 0
+	total, err := CountArticles(all)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	next := ""
+	if f.Limit > 0 && len(articles) > f.Limit {
+		articles = articles[:f.Limit]
+		next = cursorAfter(articles[len(articles)-1], f.Oldest).String()
+	}
+	return articles, total, next, nil
+}
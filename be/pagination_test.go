@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []articleCursor{
+		{Date: "2024-05-01T10:00:00Z", ID: 1},
+		{Date: "2024-05-01T10:00:00Z", ID: 42, Oldest: true},
+		{Date: "", ID: 7},
+		{Date: "Wed, 01 May 2024 10:00:00 +0000", ID: 1 << 40},
+	}
+	for _, c := range tests {
+		got, err := parseCursor(c.String())
+		if err != nil {
+			t.Errorf("parseCursor(%+v): %v", c, err)
+			continue
+		}
+		if *got != c {
+			t.Errorf("round trip of %+v gave %+v", c, *got)
+		}
+	}
+}
+
+func TestParseCursorInvalid(t *testing.T) {
+	enc := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tests := []string{
+		"",
+		"not base64!",
+		enc("not json"),
+		enc(`{}`),
+		enc(`{"d":"2024-05-01T10:00:00Z"}`),
+		enc(`{"d":"2024-05-01T10:00:00Z","i":"7"}`),
+	}
+	for _, s := range tests {
+		if _, err := parseCursor(s); !errors.Is(err, errInvalidCursor) {
+			t.Errorf("parseCursor(%q) = %v, want errInvalidCursor", s, err)
+		}
+	}
+}
+
+func TestParsePostsQueryCursorOrder(t *testing.T) {
+	newest := (&articleCursor{Date: "2024-05-01T10:00:00Z", ID: 3}).String()
+	oldest := (&articleCursor{Date: "2024-05-01T10:00:00Z", ID: 3, Oldest: true}).String()
+	tests := []struct {
+		query string
+		ok    bool
+	}{
+		{"cursor=" + newest, true},
+		{"sort=oldest&cursor=" + oldest, true},
+		{"sort=oldest&cursor=" + newest, false},
+		{"cursor=" + oldest, false},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		_, err := parsePostsQuery(q)
+		if (err == nil) != tt.ok {
+			t.Errorf("parsePostsQuery(%q) error = %v, want ok %v", tt.query, err, tt.ok)
+		}
+	}
+}
+
+// TestArticlePageEqualDates pages through articles that share their dates,
+// where only the ID tie-break keeps pages from repeating or skipping any.
+func TestArticlePageEqualDates(t *testing.T) {
+	d, err := NewSQLiteDB(filepath.Join(t.TempDir(), "posts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	saved := db
+	db = d
+	defer func() { db = saved }()
+
+	// IDs 1-7; 2-5 share one date, 6 and 7 another, and 7 has no pubdate
+	// so its fetch time is its date.
+	articles := []struct{ pubdate, fetchedAt string }{
+		{"2024-05-01T09:00:00Z", "2024-05-02T00:00:00Z"},
+		{"2024-05-01T10:00:00Z", "2024-05-02T00:00:00Z"},
+		{"2024-05-01T10:00:00Z", "2024-05-02T00:00:00Z"},
+		{"2024-05-01T10:00:00Z", "2024-05-02T00:00:00Z"},
+		{"2024-05-01T10:00:00Z", "2024-05-02T00:00:00Z"},
+		{"2024-05-01T11:00:00Z", "2024-05-02T00:00:00Z"},
+		{"", "2024-05-01T11:00:00Z"},
+	}
+	for i, a := range articles {
+		_, err := d.(*sqliteDB).db.Exec(`INSERT INTO articles (id, title, link, description, content, source, pubdate, fetched_at)
+			VALUES (?, ?, ?, '', '', 'http://example.com/feed', ?, ?)`,
+			i+1, fmt.Sprint("Article ", i+1), fmt.Sprint("http://example.com/", i+1), a.pubdate, a.fetchedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		oldest bool
+		limit  int
+		want   []int
+	}{
+		{false, 1, []int{7, 6, 5, 4, 3, 2, 1}},
+		{false, 2, []int{7, 6, 5, 4, 3, 2, 1}},
+		{false, 3, []int{7, 6, 5, 4, 3, 2, 1}},
+		{true, 2, []int{1, 2, 3, 4, 5, 6, 7}},
+		{true, 4, []int{1, 2, 3, 4, 5, 6, 7}},
+		{false, 7, []int{7, 6, 5, 4, 3, 2, 1}},
+	}
+	for _, tt := range tests {
+		f := ArticleFilter{AnyFeed: true, Oldest: tt.oldest, Limit: tt.limit}
+		var got []int
+		for pages := 0; ; pages++ {
+			if pages > len(articles) {
+				t.Fatalf("oldest=%v limit=%d: cursor does not advance", tt.oldest, tt.limit)
+			}
+			page, total, next, err := articlePage(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != len(articles) {
+				t.Errorf("oldest=%v limit=%d: total = %d, want %d", tt.oldest, tt.limit, total, len(articles))
+			}
+			if len(page) > tt.limit {
+				t.Errorf("oldest=%v limit=%d: page of %d", tt.oldest, tt.limit, len(page))
+			}
+			for _, p := range page {
+				got = append(got, p.ID)
+			}
+			if next == "" {
+				break
+			}
+			if f.After, err = parseCursor(next); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("oldest=%v limit=%d: got %v, want %v", tt.oldest, tt.limit, got, tt.want)
+		}
+	}
+}
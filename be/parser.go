@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"time"
 
 	"github.com/go-shiori/go-readability"
@@ -16,13 +17,19 @@ type Enclosure struct {
 
 // Post represents a blog post or article.
 type Post struct {
+	ID          int        `json:"id,omitempty"`
 	Title       string     `json:"title"`
 	Link        string     `json:"link"`
 	Content     string     `json:"content"`
 	Description string     `json:"description"`
 	Source      string     `json:"source"`
+	Author      string     `json:"author,omitempty"`
+	Categories  []string   `json:"categories,omitempty"`
 	PubDate     string     `json:"pubdate"`
+	FetchedAt   string     `json:"fetched_at,omitempty"`
 	Enclosure   *Enclosure `json:"enclosure,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	Starred     bool       `json:"starred,omitempty"`
 }
 
 // ArticleParseResult represents the parsed article data.
@@ -69,8 +76,36 @@ func ParseFeed(data []byte) ([]Post, error) {
 			Content:     item.Content,
 			Description: item.Description,
 			Source:      feed.Title, // or set as needed
+			Author:      itemAuthor(item),
+			Categories:  item.Categories,
+			PubDate:     itemDate(item),
 			Enclosure:   enclosure,
 		})
 	}
 	return posts, nil
 }
+
+// itemAuthor returns the names of the item's authors, comma separated.
+func itemAuthor(item *gofeed.Item) string {
+	var names []string
+	for _, a := range item.Authors {
+		if a != nil && a.Name != "" {
+			names = append(names, a.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// itemDate returns the item's publish (or update) time as UTC RFC3339 so
+// dates stored in the DB sort and compare as plain strings. It is empty
+// when neither parses, so the fetch time stands in for it.
+func itemDate(item *gofeed.Item) string {
+	switch {
+	case item.PublishedParsed != nil:
+		return item.PublishedParsed.UTC().Format(time.RFC3339)
+	case item.UpdatedParsed != nil:
+		return item.UpdatedParsed.UTC().Format(time.RFC3339)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// wordsPerMinute is the reading speed used for time estimates.
+const wordsPerMinute = 230
+
+var (
+	errQueueEmpty = errors.New("read-later queue is empty")
+	errQueueLink  = errors.New("link must be a cached article or an http(s) URL")
+	// errQueueFetch wraps failures to fetch or parse a page being queued.
+	errQueueFetch = errors.New("failed to fetch article")
+)
+
+// QueueItem is an entry in the read-later queue. Content is a snapshot taken
+// when the item was added, so arbitrary URLs can be queued too.
+type QueueItem struct {
+	ID       int    `json:"id"`
+	Link     string `json:"link"`
+	Title    string `json:"title"`
+	Content  string `json:"content,omitempty"`
+	Position int    `json:"position"`
+	AddedAt  string `json:"added_at"`
+	Words    int    `json:"words"`
+	Minutes  int    `json:"minutes"`
+}
+
+// createQueue is the read-later table; each user has their own queue.
+const createQueue = `
+	CREATE TABLE IF NOT EXISTS read_later (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL DEFAULT 1,
+		link TEXT NOT NULL,
+		title TEXT,
+		content TEXT,
+		position INTEGER NOT NULL,
+		added_at TEXT,
+		words INTEGER NOT NULL DEFAULT 0,
+		UNIQUE (user_id, link)
+	);`
+
+func migrateQueue(db *sql.DB) error {
+	if _, err := db.Exec(createQueue); err != nil {
+		return err
+	}
+	return rebuildWithUser(db, "read_later", createQueue, "id, link, title, content, position, added_at, words")
+}
+
+// readingMinutes estimates reading time, rounding up to whole minutes.
+func readingMinutes(words int) int {
+	return int(math.Ceil(float64(words) / wordsPerMinute))
+}
+
+// countWords counts the words of an HTML fragment.
+func countWords(htmlContent string) int {
+	return len(strings.Fields(RenderHTML(htmlContent, FormatText)))
+}
+
+// Enqueue adds an item to the end (or, with front, the start) of the queue.
+// Queuing a link that is already queued moves it instead.
+func (s *sqliteDB) Enqueue(item QueueItem, front bool) (QueueItem, error) {
+	edge := "MAX(position) + 1"
+	if front {
+		edge = "MIN(position) - 1"
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO read_later (user_id, link, title, content, position, added_at, words)
+		VALUES (?, ?, ?, ?, (SELECT COALESCE(`+edge+`, 0) FROM read_later WHERE user_id = ?), ?, ?)
+		ON CONFLICT(user_id, link) DO UPDATE SET position = excluded.position`,
+		s.userID(), item.Link, item.Title, item.Content, s.userID(), time.Now().UTC().Format(time.RFC3339), countWords(item.Content))
+	if err != nil {
+		return QueueItem{}, err
+	}
+	return s.queueItem("AND link = ?", item.Link)
+}
+
+// ListQueue returns the queue in reading order, without content.
+func (s *sqliteDB) ListQueue() ([]QueueItem, error) {
+	rows, err := s.db.Query("SELECT id, link, title, position, added_at, words FROM read_later WHERE user_id = ? ORDER BY position, id", s.userID())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []QueueItem{}
+	for rows.Next() {
+		var it QueueItem
+		var title, addedAt sql.NullString
+		if err := rows.Scan(&it.ID, &it.Link, &title, &it.Position, &addedAt, &it.Words); err != nil {
+			return nil, err
+		}
+		it.Title, it.AddedAt = title.String, addedAt.String
+		it.Minutes = readingMinutes(it.Words)
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// queueItem returns the user's item selected by cond, which follows the
+// WHERE clause on the user.
+func (s *sqliteDB) queueItem(cond string, args ...interface{}) (QueueItem, error) {
+	var it QueueItem
+	var title, content, addedAt sql.NullString
+	args = append([]interface{}{s.userID()}, args...)
+	err := s.db.QueryRow("SELECT id, link, title, content, position, added_at, words FROM read_later WHERE user_id = ? "+cond, args...).
+		Scan(&it.ID, &it.Link, &title, &content, &it.Position, &addedAt, &it.Words)
+	if err == sql.ErrNoRows {
+		return it, errQueueEmpty
+	}
+	it.Title, it.Content, it.AddedAt = title.String, content.String, addedAt.String
+	it.Minutes = readingMinutes(it.Words)
+	return it, err
+}
+
+// PopQueue removes and returns the first item of the queue.
+func (s *sqliteDB) PopQueue() (QueueItem, error) {
+	it, err := s.queueItem("ORDER BY position, id LIMIT 1")
+	if err != nil {
+		return it, err
+	}
+	_, err = s.db.Exec("DELETE FROM read_later WHERE id = ?", it.ID)
+	return it, err
+}
+
+func (s *sqliteDB) RemoveFromQueue(id int) error {
+	_, err := s.db.Exec("DELETE FROM read_later WHERE user_id = ? AND id = ?", s.userID(), id)
+	return err
+}
+
+// ReorderQueue puts the given items first, in order. Items not listed keep
+// their relative order after them.
+func (s *sqliteDB) ReorderQueue(ids []int) error {
+	items, err := s.ListQueue()
+	if err != nil {
+		return err
+	}
+	order := make([]int, 0, len(items))
+	listed := map[int]bool{}
+	for _, id := range ids {
+		if !listed[id] {
+			order = append(order, id)
+			listed[id] = true
+		}
+	}
+	for _, it := range items {
+		if !listed[it.ID] {
+			order = append(order, it.ID)
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i, id := range order {
+		if _, err := tx.Exec("UPDATE read_later SET position = ? WHERE user_id = ? AND id = ?", i, s.userID(), id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// queueHandler lists (GET), adds to (POST) and removes from (DELETE) the
+// read-later queue.
+func queueHandler(w http.ResponseWriter, r *http.Request) {
+	db := userDB(r)
+	switch r.Method {
+	case http.MethodGet:
+		items, err := db.ListQueue()
+		if err != nil {
+			http.Error(w, "Failed to list queue", http.StatusInternalServerError)
+			return
+		}
+		total := 0
+		for _, it := range items {
+			total += it.Words
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Items        []QueueItem `json:"items"`
+			TotalMinutes int         `json:"total_minutes"`
+		}{items, readingMinutes(total)})
+	case http.MethodPost:
+		// link may be a cached article or any web page, which is then
+		// fetched and parsed with go-readability.
+		var req struct {
+			Link  string `json:"link"`
+			Front bool   `json:"front"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Link == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		item, err := queueItemForLink(req.Link)
+		if errors.Is(err, errQueueLink) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, errQueueFetch) {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to add to queue", http.StatusInternalServerError)
+			return
+		}
+		item, err = db.Enqueue(item, req.Front)
+		if err != nil {
+			http.Error(w, "Failed to add to queue", http.StatusInternalServerError)
+			return
+		}
+		item.Content = ""
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(item)
+	case http.MethodDelete:
+		var req struct {
+			ID int `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == 0 {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := db.RemoveFromQueue(req.ID); err != nil {
+			http.Error(w, "Failed to remove from queue", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// queueItemForLink builds a queue item from the cached article with that
+// link, or by parsing the page when it isn't a known article.
+func queueItemForLink(link string) (QueueItem, error) {
+	var title, content, description sql.NullString
+	err := db.(*sqliteDB).db.QueryRow("SELECT title, content, description FROM articles WHERE link = ?", link).
+		Scan(&title, &content, &description)
+	if err == nil {
+		item := QueueItem{Link: link, Title: title.String, Content: content.String}
+		if item.Content == "" {
+			item.Content = description.String
+		}
+		return item, nil
+	}
+	if err != sql.ErrNoRows {
+		return QueueItem{}, err
+	}
+	if !validFeedURL(link) {
+		return QueueItem{}, errQueueLink
+	}
+	parsed, err := ParseArticleFromURL(link)
+	if err != nil {
+		return QueueItem{}, fmt.Errorf("%w: %v", errQueueFetch, err)
+	}
+	return QueueItem{Link: link, Title: parsed.Title, Content: parsed.Content}, nil
+}
+
+// queuePopHandler handles POST /queue/pop, returning the next item with its
+// content (in the ?format= requested) and removing it from the queue.
+func queuePopHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	format, err := formatFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	item, err := userDB(r).PopQueue()
+	if errors.Is(err, errQueueEmpty) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to pop queue", http.StatusInternalServerError)
+		return
+	}
+	item.Content = RenderHTML(item.Content, format)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
+
+// queueOrderHandler handles POST /queue/order with {"ids": [...]}.
+func queueOrderHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		IDs []int `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.IDs) == 0 {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := userDB(r).ReorderQueue(req.IDs); err != nil {
+		http.Error(w, "Failed to reorder queue", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
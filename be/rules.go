@@ -0,0 +1,553 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// maxRuleTestResults bounds the articles listed by POST /rules/test.
+const maxRuleTestResults = 100
+
+var errRuleNotFound = errors.New("rule not found")
+
+// Rule applies actions to newly fetched articles that match its conditions.
+// Rules belong to a user and only act on the articles of the user's feeds,
+// and on the user's read, starred and hidden state and tags.
+type Rule struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	// Match is "all" (the default) or "any" of the conditions.
+	Match      string          `json:"match"`
+	Conditions []RuleCondition `json:"conditions"`
+	Actions    []RuleAction    `json:"actions"`
+}
+
+// RuleCondition tests one field of an article.
+//
+// Field is feed (URL or name), title, content, author, category, domain
+// (of the link; subdomains match too) or enclosure (MIME type). Op is
+// contains (case-insensitive), keyword (case-insensitive whole words),
+// equals (case-insensitive) or regex (Go syntax; add (?i) to ignore case).
+type RuleCondition struct {
+	Field  string `json:"field"`
+	Op     string `json:"op"`
+	Value  string `json:"value"`
+	Negate bool   `json:"negate,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// RuleAction is read, star, tag (with Tag), hide or delete. delete hides the
+// article from the rule's owner, and keeps it from being stored at all when
+// every subscriber of the feed deletes it.
+type RuleAction struct {
+	Type string `json:"type"`
+	Tag  string `json:"tag,omitempty"`
+}
+
+func migrateRules(db *sql.DB) error {
+	createRules := `
+	CREATE TABLE IF NOT EXISTS rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		match TEXT NOT NULL DEFAULT 'all',
+		conditions TEXT NOT NULL,
+		actions TEXT NOT NULL
+	);`
+	if _, err := db.Exec(createRules); err != nil {
+		return err
+	}
+	// Rules from before accounts existed belong to defaultUserID.
+	return addColumn(db, "rules", "user_id", "INTEGER NOT NULL DEFAULT 1")
+}
+
+// ListRules returns the user's rules in the order they were created.
+func (s *sqliteDB) ListRules() ([]Rule, error) {
+	rows, err := s.db.Query("SELECT id, name, enabled, match, conditions, actions FROM rules WHERE user_id = ? ORDER BY id", s.userID())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := []Rule{}
+	for rows.Next() {
+		var r Rule
+		var conditions, actions string
+		if err := rows.Scan(&r.ID, &r.Name, &r.Enabled, &r.Match, &conditions, &actions); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(conditions), &r.Conditions); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(actions), &r.Actions); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+func (s *sqliteDB) CreateRule(r Rule) (Rule, error) {
+	conditions, actions, err := r.encode()
+	if err != nil {
+		return r, err
+	}
+	res, err := s.db.Exec("INSERT INTO rules (user_id, name, enabled, match, conditions, actions) VALUES (?, ?, ?, ?, ?, ?)",
+		s.userID(), r.Name, r.Enabled, r.Match, conditions, actions)
+	if err != nil {
+		return r, err
+	}
+	id, err := res.LastInsertId()
+	r.ID = int(id)
+	return r, err
+}
+
+func (s *sqliteDB) UpdateRule(r Rule) error {
+	conditions, actions, err := r.encode()
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec("UPDATE rules SET name = ?, enabled = ?, match = ?, conditions = ?, actions = ? WHERE id = ? AND user_id = ?",
+		r.Name, r.Enabled, r.Match, conditions, actions, r.ID, s.userID())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errRuleNotFound
+	}
+	return nil
+}
+
+func (s *sqliteDB) DeleteRule(id int) error {
+	res, err := s.db.Exec("DELETE FROM rules WHERE id = ? AND user_id = ?", id, s.userID())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errRuleNotFound
+	}
+	return nil
+}
+
+func (r Rule) encode() (conditions, actions string, err error) {
+	c, err := json.Marshal(r.Conditions)
+	if err != nil {
+		return "", "", err
+	}
+	a, err := json.Marshal(r.Actions)
+	return string(c), string(a), err
+}
+
+// compile validates the rule and prepares its regular expressions.
+func (r *Rule) compile() error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	switch r.Match {
+	case "":
+		r.Match = "all"
+	case "all", "any":
+	default:
+		return fmt.Errorf("match must be all or any, not %q", r.Match)
+	}
+	if len(r.Conditions) == 0 || len(r.Actions) == 0 {
+		return errors.New("a rule needs at least one condition and one action")
+	}
+	for i := range r.Conditions {
+		c := &r.Conditions[i]
+		switch c.Field {
+		case "feed", "title", "content", "author", "category", "domain", "enclosure":
+		default:
+			return fmt.Errorf("unknown field %q", c.Field)
+		}
+		if c.Value == "" {
+			return fmt.Errorf("condition on %s needs a value", c.Field)
+		}
+		var err error
+		switch c.Op {
+		case "contains", "equals":
+		case "keyword":
+			c.re, err = keywordRegexp(c.Value)
+		case "regex":
+			c.re, err = regexp.Compile(c.Value)
+		default:
+			return fmt.Errorf("unknown op %q", c.Op)
+		}
+		if err != nil {
+			return fmt.Errorf("condition on %s: %v", c.Field, err)
+		}
+	}
+	for i := range r.Actions {
+		a := &r.Actions[i]
+		a.Tag = strings.TrimSpace(a.Tag)
+		switch a.Type {
+		case "read", "star", "hide", "delete":
+		case "tag":
+			if a.Tag == "" {
+				return errors.New("tag action needs a tag")
+			}
+		default:
+			return fmt.Errorf("unknown action %q", a.Type)
+		}
+	}
+	return nil
+}
+
+// keywordRegexp matches the words of kw as a whole, case-insensitively and
+// with any whitespace between them.
+func keywordRegexp(kw string) (*regexp.Regexp, error) {
+	var words []string
+	for _, w := range strings.Fields(kw) {
+		words = append(words, regexp.QuoteMeta(w))
+	}
+	if len(words) == 0 {
+		return nil, errors.New("empty keyword")
+	}
+	return regexp.Compile(`(?i)\b` + strings.Join(words, `\s+`) + `\b`)
+}
+
+// matches reports whether p, from the feed with the given name, satisfies
+// the rule. p.Source must be the feed URL.
+func (r *Rule) matches(p Post, feedName string) bool {
+	for i := range r.Conditions {
+		ok := r.Conditions[i].matches(p, feedName)
+		if r.Match == "any" && ok {
+			return true
+		}
+		if r.Match != "any" && !ok {
+			return false
+		}
+	}
+	return r.Match != "any"
+}
+
+func (c *RuleCondition) matches(p Post, feedName string) bool {
+	var values []string
+	switch c.Field {
+	case "feed":
+		values = []string{p.Source, feedName}
+	case "title":
+		values = []string{p.Title}
+	case "content":
+		values = []string{plainText(p.Content), plainText(p.Description)}
+	case "author":
+		values = []string{p.Author}
+	case "category":
+		values = p.Categories
+	case "domain":
+		if u, err := url.Parse(p.Link); err == nil {
+			values = []string{u.Hostname()}
+		}
+	case "enclosure":
+		if p.Enclosure != nil {
+			values = []string{p.Enclosure.Type}
+		}
+	}
+	found := false
+	for _, v := range values {
+		if c.matchValue(v) {
+			found = true
+			break
+		}
+	}
+	return found != c.Negate
+}
+
+func (c *RuleCondition) matchValue(v string) bool {
+	switch c.Op {
+	case "contains":
+		return strings.Contains(strings.ToLower(v), strings.ToLower(c.Value))
+	case "equals":
+		if c.Field == "domain" {
+			v, want := strings.ToLower(v), strings.ToLower(c.Value)
+			return v == want || strings.HasSuffix(v, "."+want)
+		}
+		return strings.EqualFold(v, c.Value)
+	default:
+		return c.re.MatchString(v)
+	}
+}
+
+// ruleSet holds the enabled rules of a feed's subscribers for one refresh.
+type ruleSet struct {
+	users     []int          // subscribers of the feed
+	rules     map[int][]Rule // by owner
+	feedNames map[int]string // each subscriber's name for the feed
+}
+
+// loadRules returns the enabled rules of the users subscribed to feedURL,
+// ready to evaluate against its articles. Invalid stored rules are logged
+// and skipped.
+func loadRules(feedURL string) (*ruleSet, error) {
+	users, err := feedSubscribers(feedURL)
+	if err != nil {
+		return nil, err
+	}
+	set := &ruleSet{users: users, rules: map[int][]Rule{}, feedNames: map[int]string{}}
+	for _, u := range users {
+		d := db.ForUser(u)
+		rules, err := d.ListRules()
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rules {
+			if !r.Enabled {
+				continue
+			}
+			if err := r.compile(); err != nil {
+				log.Printf("Skipping rule %d (%s): %v", r.ID, r.Name, err)
+				continue
+			}
+			set.rules[u] = append(set.rules[u], r)
+		}
+		feed, _, err := feedByURL(d, feedURL)
+		if err != nil {
+			return nil, err
+		}
+		set.feedNames[u] = feed.FeedName
+	}
+	return set, nil
+}
+
+// actions returns the actions of every rule matching p, by rule owner.
+func (rs *ruleSet) actions(p Post) map[int][]RuleAction {
+	actions := map[int][]RuleAction{}
+	for u, rules := range rs.rules {
+		for i := range rules {
+			if rules[i].matches(p, rs.feedNames[u]) {
+				actions[u] = append(actions[u], rules[i].Actions...)
+			}
+		}
+	}
+	return actions
+}
+
+// deleted reports whether every subscriber has a rule deleting the article
+// the actions are for, so it needn't be stored.
+func (rs *ruleSet) deleted(actions map[int][]RuleAction) bool {
+	if len(rs.users) == 0 {
+		return false
+	}
+	for _, u := range rs.users {
+		if !isDeleted(actions[u]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isDeleted reports whether actions include delete.
+func isDeleted(actions []RuleAction) bool {
+	for _, a := range actions {
+		if a.Type == "delete" {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRuleActions performs the actions of each rule owner on a stored
+// article, changing only that user's state. delete hides the article from
+// the owner, as others may still want it.
+func applyRuleActions(link string, actions map[int][]RuleAction) error {
+	for u, userActions := range actions {
+		d := db.ForUser(u)
+		for _, a := range userActions {
+			var err error
+			switch a.Type {
+			case "read":
+				err = d.MarkRead(link)
+			case "star":
+				err = d.Star(link)
+			case "tag":
+				err = d.TagArticle(link, a.Tag)
+			case "hide", "delete":
+				err = d.Hide(link)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// feedSubscribers returns the users subscribed to feedURL.
+func feedSubscribers(feedURL string) ([]int, error) {
+	rows, err := db.(*sqliteDB).db.Query("SELECT DISTINCT user_id FROM feeds WHERE url = ?", feedURL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		users = append(users, id)
+	}
+	return users, rows.Err()
+}
+
+// cachedArticle returns the stored ID, title, description and content of
+// the article with this link; ok is false if it isn't cached.
+func cachedArticle(link string) (p Post, ok bool, err error) {
+	err = db.(*sqliteDB).db.QueryRow("SELECT id, COALESCE(title, ''), COALESCE(description, ''), COALESCE(content, '') FROM articles WHERE link = ?", link).
+		Scan(&p.ID, &p.Title, &p.Description, &p.Content)
+	if err == sql.ErrNoRows {
+		return p, false, nil
+	}
+	return p, err == nil, err
+}
+
+// rulesHandler lists (GET), creates (POST), replaces (PATCH) and deletes
+// (DELETE) the user's rules.
+func rulesHandler(w http.ResponseWriter, r *http.Request) {
+	db := userDB(r)
+	switch r.Method {
+	case http.MethodGet:
+		rules, err := db.ListRules()
+		if err != nil {
+			http.Error(w, "Failed to list rules", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rules)
+	case http.MethodPost, http.MethodPatch:
+		// New rules are enabled unless the body says otherwise.
+		rule := Rule{Enabled: true}
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil || (r.Method == http.MethodPatch && rule.ID == 0) {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := rule.compile(); err != nil {
+			http.Error(w, "Invalid rule: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodPatch {
+			if err := db.UpdateRule(rule); err != nil {
+				ruleError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		rule, err := db.CreateRule(rule)
+		if err != nil {
+			ruleError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rule)
+	case http.MethodDelete:
+		var req struct {
+			ID int `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == 0 {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := db.DeleteRule(req.ID); err != nil {
+			ruleError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// rulesTestHandler handles POST /rules/test: a dry run listing the cached
+// articles of the user's feeds (hidden ones included) that a rule would
+// match. The body is a rule, or just {"id"} to test a saved one. Nothing is
+// changed.
+func rulesTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var rule Rule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if rule.ID != 0 && len(rule.Conditions) == 0 {
+		rules, err := userDB(r).ListRules()
+		if err != nil {
+			http.Error(w, "Failed to list rules", http.StatusInternalServerError)
+			return
+		}
+		found := false
+		for _, saved := range rules {
+			if saved.ID == rule.ID {
+				rule, found = saved, true
+			}
+		}
+		if !found {
+			ruleError(w, errRuleNotFound)
+			return
+		}
+	}
+	if rule.Name == "" {
+		rule.Name = "test"
+	}
+	if err := rule.compile(); err != nil {
+		http.Error(w, "Invalid rule: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	posts, err := QueryArticles(ArticleFilter{User: requestUserID(r), WithHidden: true})
+	if err != nil {
+		http.Error(w, "Failed to fetch cached articles", http.StatusInternalServerError)
+		return
+	}
+	feeds, err := userDB(r).ListFeeds()
+	if err != nil {
+		http.Error(w, "Failed to list feeds", http.StatusInternalServerError)
+		return
+	}
+	names := map[string]string{}
+	for _, f := range feeds {
+		names[f.URL] = f.FeedName
+	}
+
+	type match struct {
+		ID     int    `json:"id"`
+		Title  string `json:"title"`
+		Link   string `json:"link"`
+		Source string `json:"source"`
+	}
+	result := struct {
+		Count    int     `json:"count"`
+		Articles []match `json:"articles"`
+	}{Articles: []match{}}
+	for _, p := range posts {
+		if !rule.matches(p, names[p.Source]) {
+			continue
+		}
+		result.Count++
+		if len(result.Articles) < maxRuleTestResults {
+			result.Articles = append(result.Articles, match{p.ID, p.Title, p.Link, p.Source})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func ruleError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errRuleNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Failed to update rules", http.StatusInternalServerError)
+}
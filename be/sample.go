@@ -1,68 +1,76 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 )
 
 // Generate sample RSS XML 1
-func sampleXML1() string {
-	return `<?xml version="1.0" encoding="UTF-8" ?>
+func sampleXML1(port int) string {
+	return samplePort(port, `<?xml version="1.0" encoding="UTF-8" ?>
 <rss version="2.0">
   <channel>
     <title>Sample Feed</title>
-    <link>http://localhost:8081/sample.xml</link>
+    <link>http://localhost:PORT/sample.xml</link>
     <description>This is a test RSS feed</description>
     <item>
       <title>First Post</title>
-      <link>http://localhost:8081/posts/1</link>
+      <link>http://localhost:PORT/posts/1</link>
       <description>Hello from the sample feed!</description>
     </item>
     <item>
       <title>Second Post</title>
-      <link>http://localhost:8081/posts/2</link>
+      <link>http://localhost:PORT/posts/2</link>
       <description>Another sample post.</description>
     </item>
   </channel>
-</rss>`
+</rss>`)
 }
 
 // Generate sample RSS XML 2
-func sampleXML2() string {
-	return `<?xml version="1.0" encoding="UTF-8" ?>
+func sampleXML2(port int) string {
+	return samplePort(port, `<?xml version="1.0" encoding="UTF-8" ?>
 <rss version="2.0">
   <channel>
     <title>Sample Feed 2</title>
-    <link>http://localhost:8082/sample2.xml</link>
+    <link>http://localhost:PORT/sample2.xml</link>
     <description>This is another test RSS feed</description>
     <item>
       <title>Third Post</title>
-      <link>http://localhost:8082/posts/3</link>
+      <link>http://localhost:PORT/posts/3</link>
       <description>Hello from the second sample feed!</description>
     </item>
   </channel>
-</rss>`
+</rss>`)
 }
 
-// StartSampleFeeds launches sample feed servers on 8081 and 8082
-func StartSampleFeeds() {
+// samplePort puts the port a sample feed is served on into its XML.
+func samplePort(port int, xml string) string {
+	return strings.ReplaceAll(xml, "PORT", fmt.Sprint(port))
+}
+
+// StartSampleFeeds launches the two sample feed servers on ports, which
+// come from sample_feeds.ports (8081 and 8082 by default)
+func StartSampleFeeds(ports []int) {
 	go func() {
 		mux := http.NewServeMux()
 		mux.HandleFunc("/sample.xml", func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "application/rss+xml")
-			w.Write([]byte(sampleXML1()))
+			w.Write([]byte(sampleXML1(ports[0])))
 		})
-		log.Println("Sample RSS feed available at http://localhost:8081/sample.xml")
-		log.Fatal(http.ListenAndServe(":8081", mux))
+		log.Printf("Sample RSS feed available at http://localhost:%d/sample.xml", ports[0])
+		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", ports[0]), mux))
 	}()
 
 	go func() {
 		mux := http.NewServeMux()
 		mux.HandleFunc("/sample2.xml", func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "application/rss+xml")
-			w.Write([]byte(sampleXML2()))
+			w.Write([]byte(sampleXML2(ports[1])))
 		})
-		log.Println("Sample RSS feed 2 available at http://localhost:8082/sample2.xml")
-		log.Fatal(http.ListenAndServe(":8082", mux))
+		log.Printf("Sample RSS feed 2 available at http://localhost:%d/sample2.xml", ports[1])
+		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", ports[1]), mux))
 	}()
 }
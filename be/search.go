@@ -0,0 +1,382 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSearchResults = 50
+	maxSearchResults     = 500
+)
+
+// searchEnabled is false when the SQLite driver was built without FTS5
+// (build with -tags sqlite_fts5); /search then reports it is unavailable.
+var searchEnabled bool
+
+var (
+	errSearchQuery       = errors.New("invalid search query")
+	errSearchUnavailable = errors.New("full-text search is not available: built without FTS5")
+)
+
+// SearchResult is an article matching a search, with the best matching
+// passage marked up with <mark> tags.
+type SearchResult struct {
+	Post
+	Snippet string  `json:"snippet,omitempty"`
+	Score   float64 `json:"score,omitempty"`
+}
+
+// migrateSearch creates the FTS5 index over article text. The index holds
+// plain text rather than HTML; upsertArticle keeps it current and a trigger
+// drops entries when articles are deleted (RemoveFeed, Unstar).
+func migrateSearch(db *sql.DB) error {
+	var exists int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'articles_fts'").Scan(&exists); err != nil {
+		return err
+	}
+	var fts5 bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5); err != nil {
+		return err
+	}
+	if !fts5 {
+		log.Println("WARNING: SQLite was built without FTS5, search is disabled (build with -tags sqlite_fts5)")
+		// An index left by an FTS5 build can't be maintained: drop the
+		// trigger so deletes keep working and rebuild it once FTS5 is back.
+		if _, err := db.Exec("DROP TRIGGER IF EXISTS articles_fts_delete"); err != nil {
+			return err
+		}
+		if exists > 0 {
+			_, err := db.Exec("INSERT OR REPLACE INTO settings (key, value) VALUES ('search_index_stale', '1')")
+			return err
+		}
+		return nil
+	}
+	createIndex := `
+	CREATE VIRTUAL TABLE IF NOT EXISTS articles_fts USING fts5(
+		title, description, content, author,
+		tokenize = 'porter unicode61 remove_diacritics 2'
+	);`
+	if _, err := db.Exec(createIndex); err != nil {
+		return err
+	}
+	createTrigger := `
+	CREATE TRIGGER IF NOT EXISTS articles_fts_delete AFTER DELETE ON articles BEGIN
+		DELETE FROM articles_fts WHERE rowid = old.id;
+	END;`
+	if _, err := db.Exec(createTrigger); err != nil {
+		return err
+	}
+	searchEnabled = true
+	var stale int
+	if err := db.QueryRow("SELECT COUNT(*) FROM settings WHERE key = 'search_index_stale'").Scan(&stale); err != nil {
+		return err
+	}
+	if exists > 0 && stale == 0 {
+		return nil
+	}
+
+	// (Re)build the index from the cached articles.
+	if _, err := db.Exec("DELETE FROM articles_fts; DELETE FROM settings WHERE key = 'search_index_stale'"); err != nil {
+		return err
+	}
+	rows, err := db.Query("SELECT id, title, description, content, author FROM articles")
+	if err != nil {
+		return err
+	}
+	var posts []Post
+	for rows.Next() {
+		var p Post
+		var title, description, content, author sql.NullString
+		if err := rows.Scan(&p.ID, &title, &description, &content, &author); err != nil {
+			rows.Close()
+			return err
+		}
+		p.Title, p.Description, p.Content, p.Author = title.String, description.String, content.String, author.String
+		posts = append(posts, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, p := range posts {
+		if err := writeSearchIndex(db, p.ID, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexArticle updates the search index entry of the article with p's link.
+func indexArticle(p Post) error {
+	if !searchEnabled {
+		return nil
+	}
+	conn := db.(*sqliteDB).db
+	var id int
+	if err := conn.QueryRow("SELECT id FROM articles WHERE link = ?", p.Link).Scan(&id); err != nil {
+		return err
+	}
+	return writeSearchIndex(conn, id, p)
+}
+
+func writeSearchIndex(conn *sql.DB, id int, p Post) error {
+	if _, err := conn.Exec("DELETE FROM articles_fts WHERE rowid = ?", id); err != nil {
+		return err
+	}
+	_, err := conn.Exec("INSERT INTO articles_fts (rowid, title, description, content, author) VALUES (?, ?, ?, ?, ?)",
+		id, p.Title, plainText(p.Description), plainText(p.Content), p.Author)
+	return err
+}
+
+// parseSearchQuery turns a search into an ArticleFilter. Terms of the form
+// key:value with keys feed, unread, read, starred, tag, since, until and date
+// become filters; everything else, including phrases, prefixes (go*),
+// AND/OR/NOT and column filters such as title:go, is left to FTS5 in Match.
+// feed: names one of the feeds of d's user.
+func parseSearchQuery(d DB, q string) (ArticleFilter, error) {
+	var f ArticleFilter
+	var match []string
+	for _, term := range splitSearchTerms(q) {
+		key, value, ok := strings.Cut(term, ":")
+		value = strings.Trim(value, `"`)
+		if !ok || value == "" {
+			match = append(match, term)
+			continue
+		}
+		var err error
+		switch strings.ToLower(key) {
+		case "feed":
+			f.Feed, err = resolveFeed(d, value)
+		case "unread":
+			f.Unread, f.Read = isTrue(value), !isTrue(value)
+		case "read":
+			f.Read, f.Unread = isTrue(value), !isTrue(value)
+		case "starred":
+			f.Starred = isTrue(value)
+		case "tag":
+			f.Tag = value
+		case "since":
+			f.Since, err = parseSearchDate(value)
+		case "until":
+			f.Until, err = parseSearchDate(value)
+		case "date":
+			var day time.Time
+			if day, err = time.Parse("2006-01-02", value); err == nil {
+				f.Since, f.Until = day, day.AddDate(0, 0, 1)
+			}
+		default:
+			match = append(match, term)
+			continue
+		}
+		if err != nil {
+			return f, fmt.Errorf("%w: %s: %v", errSearchQuery, key, err)
+		}
+	}
+	f.Match = strings.Join(match, " ")
+	return f, nil
+}
+
+// parseSearchDate accepts what parseDateParam does plus times relative to
+// now such as 12h, 7d or 2w, so saved searches can cover "the last week".
+func parseSearchDate(s string) (time.Time, error) {
+	if n, err := strconv.Atoi(s[:len(s)-1]); err == nil && n >= 0 {
+		unit := map[byte]time.Duration{'h': time.Hour, 'd': 24 * time.Hour, 'w': 7 * 24 * time.Hour}[s[len(s)-1]]
+		if unit != 0 {
+			return time.Now().Add(-time.Duration(n) * unit), nil
+		}
+	}
+	return parseDateParam(s)
+}
+
+// splitSearchTerms splits on whitespace outside double quotes.
+func splitSearchTerms(q string) []string {
+	var terms []string
+	var sb strings.Builder
+	quoted := false
+	for _, r := range q {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			sb.WriteRune(r)
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if sb.Len() > 0 {
+				terms = append(terms, sb.String())
+				sb.Reset()
+			}
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	if sb.Len() > 0 {
+		terms = append(terms, sb.String())
+	}
+	return terms
+}
+
+// resolveFeed finds one of the user's feeds by ID, URL or name.
+func resolveFeed(d DB, value string) (string, error) {
+	feeds, err := d.ListFeeds()
+	if err != nil {
+		return "", err
+	}
+	id, _ := strconv.Atoi(value)
+	for _, feed := range feeds {
+		if feed.ID == id || feed.URL == value || strings.EqualFold(feed.FeedName, value) {
+			return feed.URL, nil
+		}
+	}
+	return "", errors.New("no such feed")
+}
+
+// SearchArticles returns the articles matching f, best match first. Without
+// a match expression it returns the filtered articles newest first, without
+// snippets.
+func SearchArticles(f ArticleFilter) ([]SearchResult, error) {
+	results := []SearchResult{}
+	match := f.Match
+	if match == "" {
+		posts, err := QueryArticles(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range posts {
+			results = append(results, SearchResult{Post: p})
+		}
+		return results, nil
+	}
+
+	f.Match = "" // matched directly below, for ranking and snippets
+	where, args := f.where()
+	query := `
+		SELECT articles.id,
+			snippet(articles_fts, -1, char(2), char(3), '…', 16),
+			bm25(articles_fts, 10.0, 2.0, 1.0, 5.0) AS score
+		FROM articles_fts JOIN articles ON articles.id = articles_fts.rowid
+		WHERE articles_fts MATCH ?`
+	args = append([]interface{}{match}, args...)
+	if where != "" {
+		query += " AND " + strings.TrimPrefix(where, "WHERE ")
+	}
+	query += " ORDER BY score LIMIT ?"
+	args = append(args, f.Limit)
+	rows, err := db.(*sqliteDB).db.Query(query, args...)
+	if err != nil {
+		return nil, searchError(err)
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var r SearchResult
+		if err := rows.Scan(&r.ID, &r.Snippet, &r.Score); err != nil {
+			return nil, searchError(err)
+		}
+		r.Score = -r.Score // bm25 is lower for better matches
+		r.Snippet = markSnippet(r.Snippet)
+		ids = append(ids, r.ID)
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, searchError(err)
+	}
+	if len(ids) == 0 {
+		return results, nil
+	}
+
+	posts, err := QueryArticles(ArticleFilter{User: f.User, IDs: ids})
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]Post, len(posts))
+	for _, p := range posts {
+		byID[p.ID] = p
+	}
+	for i := range results {
+		results[i].Post = byID[results[i].ID]
+	}
+	return results, nil
+}
+
+// searchError reports malformed match expressions as errSearchQuery.
+func searchError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if strings.HasPrefix(msg, "fts5:") || strings.HasPrefix(msg, "no such column") || strings.Contains(msg, "malformed MATCH") {
+		return fmt.Errorf("%w: %s", errSearchQuery, msg)
+	}
+	return err
+}
+
+// markSnippet escapes a snippet and turns the match markers into <mark> tags.
+func markSnippet(s string) string {
+	s = html.EscapeString(s)
+	return strings.NewReplacer("\x02", "<mark>", "\x03", "</mark>").Replace(s)
+}
+
+// searchHandler handles GET /search?q=, with optional limit and format.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !searchEnabled {
+		http.Error(w, errSearchUnavailable.Error(), http.StatusNotImplemented)
+		return
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		http.Error(w, "Missing q parameter", http.StatusBadRequest)
+		return
+	}
+	format, err := formatFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter, err := parseSearchQuery(userDB(r), q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.User = requestUserID(r)
+	filter.Limit = defaultSearchResults
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			filter.Limit = min(n, maxSearchResults)
+		}
+	}
+
+	results, err := SearchArticles(filter)
+	if errors.Is(err, errSearchQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Search failed", http.StatusInternalServerError)
+		return
+	}
+	tags, err := userDB(r).ArticleTags()
+	if err != nil {
+		http.Error(w, "Search failed", http.StatusInternalServerError)
+		return
+	}
+	for i := range results {
+		results[i].Tags = tags[results[i].Link]
+		renderPost(&results[i].Post, format)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Query   string         `json:"query"`
+		Results []SearchResult `json:"results"`
+	}{q, results})
+}
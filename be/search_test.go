@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSplitSearchTerms(t *testing.T) {
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"go", []string{"go"}},
+		{"go  generics\tsqlite\n", []string{"go", "generics", "sqlite"}},
+		{`"go generics" sqlite`, []string{`"go generics"`, "sqlite"}},
+		{`title:"go lang" tag:news`, []string{`title:"go lang"`, "tag:news"}},
+		{`go OR rust NOT java`, []string{"go", "OR", "rust", "NOT", "java"}},
+		{`"unterminated phrase`, []string{`"unterminated phrase`}},
+	}
+	for _, tt := range tests {
+		if got := splitSearchTerms(tt.q); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitSearchTerms(%q) = %q, want %q", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	tests := []struct {
+		q    string
+		want ArticleFilter
+	}{
+		{"go generics", ArticleFilter{Match: "go generics"}},
+		{`"go generics" go*`, ArticleFilter{Match: `"go generics" go*`}},
+		{"title:go author:pike", ArticleFilter{Match: "title:go author:pike"}},
+		{"go unread:1", ArticleFilter{Match: "go", Unread: true}},
+		{"go unread:false", ArticleFilter{Match: "go", Read: true}},
+		{"read:true", ArticleFilter{Read: true}},
+		{"STARRED:true", ArticleFilter{Starred: true}},
+		{`tag:"long read" go`, ArticleFilter{Match: "go", Tag: "long read"}},
+		{"date:2024-05-01 go", ArticleFilter{Match: "go", Since: day("2024-05-01"), Until: day("2024-05-02")}},
+		{"since:2024-05-01 until:2024-06-01", ArticleFilter{Since: day("2024-05-01"), Until: day("2024-06-01")}},
+		{"tag: go", ArticleFilter{Match: "tag: go"}},
+	}
+	for _, tt := range tests {
+		got, err := parseSearchQuery(nil, tt.q)
+		if err != nil {
+			t.Errorf("parseSearchQuery(%q): %v", tt.q, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSearchQuery(%q) = %+v, want %+v", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestParseSearchQueryRelativeDates(t *testing.T) {
+	tests := []struct {
+		q   string
+		ago time.Duration
+	}{
+		{"since:12h", 12 * time.Hour},
+		{"since:7d", 7 * 24 * time.Hour},
+		{"since:2w", 14 * 24 * time.Hour},
+		{"since:0d", 0},
+	}
+	for _, tt := range tests {
+		f, err := parseSearchQuery(nil, tt.q)
+		if err != nil {
+			t.Errorf("parseSearchQuery(%q): %v", tt.q, err)
+			continue
+		}
+		if d := time.Since(f.Since) - tt.ago; d < 0 || d > time.Minute {
+			t.Errorf("parseSearchQuery(%q).Since = %v, want about %v ago", tt.q, f.Since, tt.ago)
+		}
+	}
+}
+
+func TestParseSearchQueryErrors(t *testing.T) {
+	for _, q := range []string{"since:yesterday", "until:2024-13-01", "date:2024-05", "go since:-3d"} {
+		if _, err := parseSearchQuery(nil, q); !errors.Is(err, errSearchQuery) {
+			t.Errorf("parseSearchQuery(%q) error = %v, want errSearchQuery", q, err)
+		}
+	}
+}
+
+// TestParseSearchQueryFeed checks that feed: only finds the user's own feeds.
+func TestParseSearchQueryFeed(t *testing.T) {
+	d, err := NewSQLiteDB(filepath.Join(t.TempDir(), "posts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	alice, bob := d.ForUser(1), d.ForUser(2)
+	if err := alice.AddFeed("http://example.com/go.xml", "Go Blog"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.AddFeed("http://example.com/rust.xml", "Rust Blog"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		d    DB
+		q    string
+		want string // feed URL, or "" for an error
+	}{
+		{alice, `feed:"go blog"`, "http://example.com/go.xml"},
+		{alice, "feed:http://example.com/go.xml", "http://example.com/go.xml"},
+		{alice, `feed:"Rust Blog"`, ""},
+		{alice, "feed:http://example.com/rust.xml", ""},
+		{bob, `feed:"Rust Blog"`, "http://example.com/rust.xml"},
+		{bob, `feed:"Go Blog"`, ""},
+	}
+	for _, tt := range tests {
+		f, err := parseSearchQuery(tt.d, tt.q)
+		switch {
+		case tt.want == "" && !errors.Is(err, errSearchQuery):
+			t.Errorf("parseSearchQuery(%q) as user %d = %q, %v, want errSearchQuery", tt.q, tt.d.(*sqliteDB).userID(), f.Feed, err)
+		case tt.want != "" && (err != nil || f.Feed != tt.want):
+			t.Errorf("parseSearchQuery(%q) as user %d = %q, %v, want %q", tt.q, tt.d.(*sqliteDB).userID(), f.Feed, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SavedSearch is a user's named search, in the syntax of GET /search, that
+// can be read like a feed with GET /posts?search={id}.
+type SavedSearch struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Query     string `json:"query"`
+	CreatedAt string `json:"created_at"`
+	Unread    int    `json:"unread"`
+}
+
+var errSavedSearchNotFound = errors.New("saved search not found")
+
+func migrateSavedSearches(db *sql.DB) error {
+	createSearches := `
+	CREATE TABLE IF NOT EXISTS saved_searches (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		query TEXT NOT NULL,
+		created_at TEXT
+	);`
+	if _, err := db.Exec(createSearches); err != nil {
+		return err
+	}
+	// Saved searches from before they were per user belong to defaultUserID.
+	return addColumn(db, "saved_searches", "user_id", "INTEGER NOT NULL DEFAULT 1")
+}
+
+// ListSavedSearches returns the user's saved searches by name, without
+// counts.
+func (s *sqliteDB) ListSavedSearches() ([]SavedSearch, error) {
+	rows, err := s.db.Query("SELECT id, name, query, created_at FROM saved_searches WHERE user_id = ? ORDER BY name COLLATE NOCASE, id", s.userID())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	searches := []SavedSearch{}
+	for rows.Next() {
+		var ss SavedSearch
+		var createdAt sql.NullString
+		if err := rows.Scan(&ss.ID, &ss.Name, &ss.Query, &createdAt); err != nil {
+			return nil, err
+		}
+		ss.CreatedAt = createdAt.String
+		searches = append(searches, ss)
+	}
+	return searches, rows.Err()
+}
+
+func (s *sqliteDB) GetSavedSearch(id int) (SavedSearch, error) {
+	var ss SavedSearch
+	var createdAt sql.NullString
+	err := s.db.QueryRow("SELECT id, name, query, created_at FROM saved_searches WHERE id = ? AND user_id = ?", id, s.userID()).
+		Scan(&ss.ID, &ss.Name, &ss.Query, &createdAt)
+	if err == sql.ErrNoRows {
+		return ss, errSavedSearchNotFound
+	}
+	ss.CreatedAt = createdAt.String
+	return ss, err
+}
+
+func (s *sqliteDB) CreateSavedSearch(name, query string) (SavedSearch, error) {
+	ss := SavedSearch{Name: name, Query: query, CreatedAt: time.Now().UTC().Format(time.RFC3339)}
+	res, err := s.db.Exec("INSERT INTO saved_searches (user_id, name, query, created_at) VALUES (?, ?, ?, ?)", s.userID(), ss.Name, ss.Query, ss.CreatedAt)
+	if err != nil {
+		return ss, err
+	}
+	id, err := res.LastInsertId()
+	ss.ID = int(id)
+	return ss, err
+}
+
+func (s *sqliteDB) UpdateSavedSearch(ss SavedSearch) error {
+	res, err := s.db.Exec("UPDATE saved_searches SET name = ?, query = ? WHERE id = ? AND user_id = ?", ss.Name, ss.Query, ss.ID, s.userID())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errSavedSearchNotFound
+	}
+	return nil
+}
+
+func (s *sqliteDB) DeleteSavedSearch(id int) error {
+	res, err := s.db.Exec("DELETE FROM saved_searches WHERE id = ? AND user_id = ?", id, s.userID())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errSavedSearchNotFound
+	}
+	return nil
+}
+
+// savedSearchFilter parses a saved query. Relative dates are resolved
+// against the current time, so "since:7d" always means the last week.
+func savedSearchFilter(d DB, query string) (ArticleFilter, error) {
+	f, err := parseSearchQuery(d, query)
+	if err != nil {
+		return f, err
+	}
+	if f.Match != "" && !searchEnabled {
+		return f, errSearchUnavailable
+	}
+	return f, nil
+}
+
+// validateSavedSearch checks that a query parses and, for text terms, that
+// FTS5 accepts the match expression.
+func validateSavedSearch(d DB, query string) error {
+	f, err := savedSearchFilter(d, query)
+	if err != nil {
+		return err
+	}
+	if f.Match != "" {
+		var n int
+		err := db.(*sqliteDB).db.QueryRow("SELECT COUNT(*) FROM (SELECT 1 FROM articles_fts WHERE articles_fts MATCH ? LIMIT 1)", f.Match).Scan(&n)
+		return searchError(err)
+	}
+	return nil
+}
+
+// withUnreadCounts fills in the Unread count of each saved search for the
+// user of d. Searches whose query no longer resolves (e.g. a removed feed)
+// count zero.
+func withUnreadCounts(d DB, searches []SavedSearch) ([]SavedSearch, error) {
+	for i := range searches {
+		f, err := savedSearchFilter(d, searches[i].Query)
+		if err != nil {
+			continue
+		}
+		f.User = d.(*sqliteDB).userID()
+		f.Unread, f.Read = true, false
+		if searches[i].Unread, err = CountArticles(f); err != nil {
+			return nil, err
+		}
+	}
+	return searches, nil
+}
+
+// savedSearchFeed is how a saved search is listed by GET /feeds.
+type savedSearchFeed struct {
+	Type string `json:"type"` // always "search"
+	SavedSearch
+	FeedName string `json:"feed_name"`
+}
+
+// savedSearchFeeds returns the saved searches of d's user in the form listed
+// next to real feeds, with their unread counts.
+func savedSearchFeeds(d DB) ([]savedSearchFeed, error) {
+	searches, err := d.ListSavedSearches()
+	if err == nil {
+		searches, err = withUnreadCounts(d, searches)
+	}
+	if err != nil {
+		return nil, err
+	}
+	feeds := make([]savedSearchFeed, 0, len(searches))
+	for _, ss := range searches {
+		feeds = append(feeds, savedSearchFeed{Type: "search", SavedSearch: ss, FeedName: ss.Name})
+	}
+	return feeds, nil
+}
+
+// savedSearchArticleFilter returns the filter selecting the articles of
+// saved search id of d's user, further narrowed by the /posts parameters in
+// extra. Feeds named in the query are looked up among those of d's user.
+func savedSearchArticleFilter(d DB, id string, extra ArticleFilter) (ArticleFilter, error) {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return ArticleFilter{}, errSavedSearchNotFound
+	}
+	ss, err := d.GetSavedSearch(n)
+	if err != nil {
+		return ArticleFilter{}, err
+	}
+	f, err := savedSearchFilter(d, ss.Query)
+	if err != nil {
+		return f, err
+	}
+	if extra.Feed != "" {
+		f.Feed = extra.Feed
+	}
+	if extra.Tag != "" {
+		f.Tag = extra.Tag
+	}
+	if extra.Folder != 0 {
+		f.Folder = extra.Folder
+	}
+	if !extra.Since.IsZero() {
+		f.Since = extra.Since
+	}
+	if !extra.Until.IsZero() {
+		f.Until = extra.Until
+	}
+	f.Unread = f.Unread || extra.Unread
+	f.Read = f.Read || extra.Read
+	f.Starred = f.Starred || extra.Starred
+	f.Hidden = f.Hidden || extra.Hidden
+	f.Enclosure = extra.Enclosure
+	f.Oldest, f.After, f.Limit = extra.Oldest, extra.After, extra.Limit
+	f.User = extra.User
+	return f, nil
+}
+
+// searchesHandler lists (GET), creates (POST), updates (PATCH) and deletes
+// (DELETE) the user's saved searches.
+func searchesHandler(w http.ResponseWriter, r *http.Request) {
+	db := userDB(r)
+	switch r.Method {
+	case http.MethodGet:
+		searches, err := db.ListSavedSearches()
+		if err == nil {
+			searches, err = withUnreadCounts(db, searches)
+		}
+		if err != nil {
+			http.Error(w, "Failed to list saved searches", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(searches)
+	case http.MethodPost, http.MethodPatch:
+		var req struct {
+			ID    int    `json:"id"`
+			Name  string `json:"name"`
+			Query string `json:"query"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		req.Name, req.Query = strings.TrimSpace(req.Name), strings.TrimSpace(req.Query)
+		if err != nil || req.Name == "" || req.Query == "" || (r.Method == http.MethodPatch && req.ID == 0) {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := validateSavedSearch(db, req.Query); err != nil {
+			http.Error(w, "Invalid query: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodPatch {
+			if err := db.UpdateSavedSearch(SavedSearch{ID: req.ID, Name: req.Name, Query: req.Query}); err != nil {
+				savedSearchError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		ss, err := db.CreateSavedSearch(req.Name, req.Query)
+		if err != nil {
+			savedSearchError(w, err)
+			return
+		}
+		if counted, err := withUnreadCounts(db, []SavedSearch{ss}); err == nil {
+			ss = counted[0]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(ss)
+	case http.MethodDelete:
+		var req struct {
+			ID int `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == 0 {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := db.DeleteSavedSearch(req.ID); err != nil {
+			savedSearchError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func savedSearchError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errSavedSearchNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Failed to update saved searches", http.StatusInternalServerError)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Tag is a user's label with the number of articles carrying it. Each user
+// has their own tags.
+type Tag struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+var errTagNotFound = errors.New("tag not found")
+
+// migrateTags creates the tag tables. Tags are attached by article link, like
+// read_articles, so they survive upsertArticle rewriting the article row.
+// article_tags belongs to the user through its tag.
+func migrateTags(db *sql.DB) error {
+	createTags := `
+	CREATE TABLE IF NOT EXISTS tags (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL DEFAULT 1,
+		name TEXT NOT NULL COLLATE NOCASE,
+		UNIQUE (user_id, name)
+	);`
+	if _, err := db.Exec(createTags); err != nil {
+		return err
+	}
+	// Tag names were unique server-wide; they become unique per user, with
+	// existing tags belonging to defaultUserID. The new table is renamed into
+	// place, rather than the old one out of it, so that article_tags keeps
+	// referencing "tags".
+	if has, err := hasColumn(db, "tags", "user_id"); err != nil {
+		return err
+	} else if !has {
+		rebuild := strings.Replace(createTags, "IF NOT EXISTS tags", "tags_new", 1) + `
+		INSERT INTO tags_new (id, name) SELECT id, name FROM tags;
+		DROP TABLE tags;
+		ALTER TABLE tags_new RENAME TO tags;`
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec(rebuild); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	createArticleTags := `
+	CREATE TABLE IF NOT EXISTS article_tags (
+		link TEXT NOT NULL,
+		tag_id INTEGER NOT NULL REFERENCES tags(id),
+		PRIMARY KEY (link, tag_id)
+	);
+	CREATE INDEX IF NOT EXISTS article_tags_tag ON article_tags(tag_id);`
+	_, err := db.Exec(createArticleTags)
+	return err
+}
+
+// TagArticle attaches a tag to an article, creating the tag if needed.
+func (s *sqliteDB) TagArticle(link, tag string) error {
+	if _, err := s.db.Exec("INSERT OR IGNORE INTO tags (user_id, name) VALUES (?, ?)", s.userID(), tag); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("INSERT OR IGNORE INTO article_tags (link, tag_id) SELECT ?, id FROM tags WHERE user_id = ? AND name = ?", link, s.userID(), tag)
+	return err
+}
+
+func (s *sqliteDB) UntagArticle(link, tag string) error {
+	_, err := s.db.Exec("DELETE FROM article_tags WHERE link = ? AND tag_id = (SELECT id FROM tags WHERE user_id = ? AND name = ?)", link, s.userID(), tag)
+	return err
+}
+
+// ListTags returns the user's tags with their article counts, by name.
+func (s *sqliteDB) ListTags() ([]Tag, error) {
+	rows, err := s.db.Query(`
+		SELECT tags.id, tags.name, COUNT(article_tags.link)
+		FROM tags LEFT JOIN article_tags ON article_tags.tag_id = tags.id
+		WHERE tags.user_id = ?
+		GROUP BY tags.id ORDER BY tags.name`, s.userID())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := []Tag{}
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.ID, &t.Name, &t.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// RenameTag renames a tag. If a tag called newName already exists the two
+// are merged into it.
+func (s *sqliteDB) RenameTag(oldName, newName string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldID int
+	if err := tx.QueryRow("SELECT id FROM tags WHERE user_id = ? AND name = ?", s.userID(), oldName).Scan(&oldID); err != nil {
+		if err == sql.ErrNoRows {
+			return errTagNotFound
+		}
+		return err
+	}
+	var newID int
+	err = tx.QueryRow("SELECT id FROM tags WHERE user_id = ? AND name = ?", s.userID(), newName).Scan(&newID)
+	switch {
+	case err == sql.ErrNoRows || newID == oldID:
+		// Plain rename (or a change of case only).
+		if _, err := tx.Exec("UPDATE tags SET name = ? WHERE id = ?", newName, oldID); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		if _, err := tx.Exec("INSERT OR IGNORE INTO article_tags (link, tag_id) SELECT link, ? FROM article_tags WHERE tag_id = ?", newID, oldID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM article_tags WHERE tag_id = ?", oldID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM tags WHERE id = ?", oldID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteTag removes one of the user's tags from all articles.
+func (s *sqliteDB) DeleteTag(name string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM article_tags WHERE tag_id = (SELECT id FROM tags WHERE user_id = ? AND name = ?)", s.userID(), name); err != nil {
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM tags WHERE user_id = ? AND name = ?", s.userID(), name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errTagNotFound
+	}
+	return tx.Commit()
+}
+
+// ArticleTags returns the user's tags of every article they tagged, keyed
+// by link.
+func (s *sqliteDB) ArticleTags() (map[string][]string, error) {
+	rows, err := s.db.Query("SELECT article_tags.link, tags.name FROM article_tags JOIN tags ON tags.id = article_tags.tag_id WHERE tags.user_id = ? ORDER BY tags.name", s.userID())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := map[string][]string{}
+	for rows.Next() {
+		var link, name string
+		if err := rows.Scan(&link, &name); err != nil {
+			return nil, err
+		}
+		tags[link] = append(tags[link], name)
+	}
+	return tags, rows.Err()
+}
+
+// attachTags fills in the Tags field of posts with the tags of d's user.
+func attachTags(d DB, posts []Post) error {
+	tags, err := d.ArticleTags()
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		posts[i].Tags = tags[posts[i].Link]
+	}
+	return nil
+}
+
+// tagHandler handles POST /tag and POST /untag, mirroring readHandler.
+func tagHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Link string `json:"link"`
+		Tag  string `json:"tag"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Link == "" || strings.TrimSpace(req.Tag) == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	tag := strings.TrimSpace(req.Tag)
+	db := userDB(r)
+	var err error
+	if r.URL.Path == "/tag" {
+		err = db.TagArticle(req.Link, tag)
+	} else {
+		err = db.UntagArticle(req.Link, tag)
+	}
+	if err != nil {
+		http.Error(w, "Failed to update tags", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tagsHandler lists (GET), renames or merges (PATCH) and deletes (DELETE)
+// the user's tags.
+func tagsHandler(w http.ResponseWriter, r *http.Request) {
+	db := userDB(r)
+	switch r.Method {
+	case http.MethodGet:
+		tags, err := db.ListTags()
+		if err != nil {
+			http.Error(w, "Failed to list tags", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tags)
+	case http.MethodPatch:
+		var req struct {
+			Name    string `json:"name"`
+			NewName string `json:"new_name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" || strings.TrimSpace(req.NewName) == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := db.RenameTag(req.Name, strings.TrimSpace(req.NewName)); err != nil {
+			tagError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := db.DeleteTag(req.Name); err != nil {
+			tagError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func tagError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errTagNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Failed to update tags", http.StatusInternalServerError)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// apiTokenPrefix starts every API token, so they are easy to recognise in
+// configuration files and secret scanners.
+const apiTokenPrefix = "rss_"
+
+var errTokenNotFound = errors.New("token not found")
+
+// APIToken is a long-lived credential for scripts and clients, sent as
+// "Authorization: Bearer <token>". Only a hash is stored, so Token is set
+// just once, when the token is created.
+type APIToken struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	Token      string `json:"token,omitempty"`
+	CreatedAt  string `json:"created_at"`
+	LastUsedAt string `json:"last_used_at,omitempty"`
+}
+
+func migrateAPITokens(db *sql.DB) error {
+	createTokens := `
+	CREATE TABLE IF NOT EXISTS api_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		created_at TEXT NOT NULL,
+		last_used_at TEXT
+	);`
+	_, err := db.Exec(createTokens)
+	return err
+}
+
+// CreateAPIToken stores a new token for the user and returns it with the
+// secret filled in.
+func (s *sqliteDB) CreateAPIToken(name string) (APIToken, error) {
+	secret, err := randomToken(32)
+	if err != nil {
+		return APIToken{}, err
+	}
+	t := APIToken{Name: name, Token: apiTokenPrefix + secret, CreatedAt: time.Now().UTC().Format(time.RFC3339)}
+	res, err := s.db.Exec("INSERT INTO api_tokens (user_id, name, token_hash, created_at) VALUES (?, ?, ?, ?)",
+		s.userID(), t.Name, hashToken(t.Token), t.CreatedAt)
+	if err != nil {
+		return APIToken{}, err
+	}
+	id, err := res.LastInsertId()
+	t.ID = int(id)
+	return t, err
+}
+
+// RotateAPIToken creates a token like CreateAPIToken and revokes the
+// user's older tokens of that name beyond the newest keep, for tokens that
+// clients create each time they sign in.
+func (s *sqliteDB) RotateAPIToken(name string, keep int) (APIToken, error) {
+	t, err := s.CreateAPIToken(name)
+	if err != nil {
+		return t, err
+	}
+	_, err = s.db.Exec(`DELETE FROM api_tokens WHERE user_id = ? AND name = ? AND id NOT IN
+		(SELECT id FROM api_tokens WHERE user_id = ? AND name = ? ORDER BY id DESC LIMIT ?)`,
+		s.userID(), name, s.userID(), name, keep)
+	return t, err
+}
+
+// ListAPITokens returns the user's tokens, without their secrets.
+func (s *sqliteDB) ListAPITokens() ([]APIToken, error) {
+	rows, err := s.db.Query("SELECT id, name, created_at, COALESCE(last_used_at, '') FROM api_tokens WHERE user_id = ? ORDER BY id", s.userID())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := []APIToken{}
+	for rows.Next() {
+		var t APIToken
+		if err := rows.Scan(&t.ID, &t.Name, &t.CreatedAt, &t.LastUsedAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *sqliteDB) RevokeAPIToken(id int) error {
+	res, err := s.db.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", id, s.userID())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errTokenNotFound
+	}
+	return nil
+}
+
+// APITokenUser returns the user a token belongs to and records its use.
+// Tokens created while no accounts exist belong to defaultUserID; for those
+// the returned user has no Username until the first account is created.
+func (s *sqliteDB) APITokenUser(token string) (User, error) {
+	var u User
+	var username, createdAt sql.NullString
+	var admin sql.NullBool
+	err := s.db.QueryRow(`SELECT api_tokens.user_id, users.username, users.admin, users.created_at
+		FROM api_tokens LEFT JOIN users ON users.id = api_tokens.user_id
+		WHERE api_tokens.token_hash = ?`, hashToken(token)).Scan(&u.ID, &username, &admin, &createdAt)
+	if err == sql.ErrNoRows || (err == nil && !username.Valid && u.ID != defaultUserID) {
+		return User{}, errTokenNotFound
+	}
+	if err != nil {
+		return User{}, err
+	}
+	u.Username, u.Admin, u.CreatedAt = username.String, admin.Bool, createdAt.String
+	// Writing on every request would serialise readers behind SQLite's
+	// write lock; minute precision is plenty for spotting unused tokens.
+	now := time.Now().UTC()
+	_, err = s.db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE token_hash = ? AND (last_used_at IS NULL OR last_used_at < ?)",
+		now.Format(time.RFC3339), hashToken(token), now.Add(-time.Minute).Format(time.RFC3339))
+	return u, err
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header, or
+// of the "GoogleLogin auth=" header used by Google Reader clients.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	switch {
+	case !ok:
+		return "", false
+	case strings.EqualFold(scheme, "GoogleLogin"):
+		if token, ok = strings.CutPrefix(strings.TrimSpace(token), "auth="); !ok {
+			return "", false
+		}
+	case !strings.EqualFold(scheme, "Bearer"):
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// loopbackAddr reports whether a listen address only accepts connections
+// from this machine.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// tokensHandler lists (GET), creates (POST {"name"}) and revokes (DELETE
+// {"id"}) the API tokens of the requesting user.
+func tokensHandler(w http.ResponseWriter, r *http.Request) {
+	db := userDB(r)
+	switch r.Method {
+	case http.MethodGet:
+		tokens, err := db.ListAPITokens()
+		if err != nil {
+			http.Error(w, "Failed to list tokens", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tokens)
+	case http.MethodPost:
+		var req struct {
+			Name string `json:"name"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		req.Name = strings.TrimSpace(req.Name)
+		if err != nil || req.Name == "" {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		t, err := db.CreateAPIToken(req.Name)
+		if err != nil {
+			http.Error(w, "Failed to create token", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(t)
+	case http.MethodDelete:
+		var req struct {
+			ID int `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == 0 {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := db.RevokeAPIToken(req.ID); err != nil {
+			if errors.Is(err, errTokenNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// runToken implements the token command, managing API tokens without the
+// server: token create -name NAME, token list and token revoke ID, each
+// with an optional -user USERNAME (default: the first user).
+func runToken(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: token create|list|revoke [-user USERNAME] ...")
+	}
+	fs := flag.NewFlagSet("token "+args[0], flag.ExitOnError)
+	username := fs.String("user", "", "account the token belongs to (default: the first user)")
+	name := fs.String("name", "", "what the token is used for, e.g. tui")
+	fs.Parse(args[1:])
+
+	d := db
+	if *username != "" {
+		u, _, err := db.UserByName(*username)
+		if err != nil {
+			return err
+		}
+		d = db.ForUser(u.ID)
+	}
+	switch args[0] {
+	case "create":
+		if strings.TrimSpace(*name) == "" {
+			return errors.New("token create needs -name")
+		}
+		t, err := d.CreateAPIToken(strings.TrimSpace(*name))
+		if err != nil {
+			return err
+		}
+		fmt.Println(t.Token)
+		fmt.Fprintln(os.Stderr, "Store this token now; it cannot be shown again.")
+	case "list":
+		tokens, err := d.ListAPITokens()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tCREATED\tLAST USED")
+		for _, t := range tokens {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", t.ID, t.Name, t.CreatedAt, t.LastUsedAt)
+		}
+		return tw.Flush()
+	case "revoke":
+		id, err := strconv.Atoi(fs.Arg(0))
+		if err != nil {
+			return errors.New("usage: token revoke [-user USERNAME] ID")
+		}
+		return d.RevokeAPIToken(id)
+	default:
+		return fmt.Errorf("unknown token command %q", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,682 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/term"
+)
+
+// defaultUserID owns subscriptions and read state while no accounts exist.
+// The first account created gets this ID and so keeps that data.
+const defaultUserID = 1
+
+const (
+	sessionCookie   = "session"
+	sessionLifetime = 30 * 24 * time.Hour
+	minPasswordLen  = 8
+)
+
+var (
+	errUserNotFound   = errors.New("user not found")
+	errUserExists     = errors.New("username is taken")
+	errBadCredentials = errors.New("invalid username or password")
+)
+
+// User is an account. Admins can create and delete other accounts.
+type User struct {
+	ID        int    `json:"id"`
+	Username  string `json:"username"`
+	Admin     bool   `json:"admin"`
+	CreatedAt string `json:"created_at"`
+}
+
+type userKey struct{}
+
+// migrateUsers creates the account tables and gives the per-user tables
+// (subscriptions, read, starred and hidden articles) a user_id column. Rows
+// from before accounts existed belong to defaultUserID.
+func migrateUsers(db *sql.DB) error {
+	createUsers := `
+	CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		password_hash TEXT NOT NULL,
+		admin INTEGER NOT NULL DEFAULT 0,
+		created_at TEXT
+	);
+	CREATE TABLE IF NOT EXISTS sessions (
+		token_hash TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		expires_at TEXT NOT NULL
+	);`
+	if _, err := db.Exec(createUsers); err != nil {
+		return err
+	}
+	if err := rebuildWithUser(db, "hidden_articles", createHidden, "link"); err != nil {
+		return err
+	}
+
+	// feeds.url and the link keys of read_articles and starred_articles are
+	// unique, which SQLite can't relax in place, so the tables are rebuilt.
+	if has, err := hasColumn(db, "feeds", "user_id"); err != nil || has {
+		return err
+	}
+	// Columns added to feeds by later migrations, when present.
+	cols, defs := "", ""
+	for _, col := range []struct{ name, def string }{
+		{"folder_id", "INTEGER REFERENCES folders(id)"},
+	} {
+		has, err := hasColumn(db, "feeds", col.name)
+		if err != nil {
+			return err
+		}
+		if has {
+			cols += ", " + col.name
+			defs += ",\n\t\t" + col.name + " " + col.def
+		}
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	rebuild := `
+	CREATE TABLE feeds_new (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL DEFAULT 1,
+		url TEXT,
+		feed_name TEXT` + defs + `,
+		UNIQUE (user_id, url)
+	);
+	INSERT INTO feeds_new (id, url, feed_name` + cols + `) SELECT id, url, feed_name` + cols + ` FROM feeds;
+	DROP TABLE feeds;
+	ALTER TABLE feeds_new RENAME TO feeds;
+
+	CREATE TABLE read_articles_new (
+		user_id INTEGER NOT NULL DEFAULT 1,
+		link TEXT NOT NULL,
+		PRIMARY KEY (user_id, link)
+	);
+	INSERT INTO read_articles_new (link) SELECT link FROM read_articles;
+	DROP TABLE read_articles;
+	ALTER TABLE read_articles_new RENAME TO read_articles;
+
+	CREATE TABLE starred_articles_new (
+		user_id INTEGER NOT NULL DEFAULT 1,
+		link TEXT NOT NULL,
+		starred_at TEXT,
+		PRIMARY KEY (user_id, link)
+	);
+	INSERT INTO starred_articles_new (link, starred_at) SELECT link, starred_at FROM starred_articles;
+	DROP TABLE starred_articles;
+	ALTER TABLE starred_articles_new RENAME TO starred_articles;`
+	if _, err := tx.Exec(rebuild); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// rebuildWithUser recreates table from create, its current definition, if
+// it has no user_id column yet, copying columns over to defaultUserID. Used
+// where a unique key on link has to become one on (user_id, link).
+func rebuildWithUser(db *sql.DB, table, create, columns string) error {
+	if has, err := hasColumn(db, table, "user_id"); err != nil || has {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		"ALTER TABLE " + table + " RENAME TO " + table + "_old",
+		create,
+		"INSERT INTO " + table + " (" + columns + ") SELECT " + columns + " FROM " + table + "_old",
+		"DROP TABLE " + table + "_old",
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// userID returns the account the DB handle acts for.
+func (s *sqliteDB) userID() int {
+	if s.user == 0 {
+		return defaultUserID
+	}
+	return s.user
+}
+
+// ForUser returns a handle whose subscriptions, read and starred state
+// are those of user id.
+func (s *sqliteDB) ForUser(id int) DB {
+	return &sqliteDB{db: s.db, user: id}
+}
+
+func (s *sqliteDB) CountUsers() (int, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&n)
+	return n, err
+}
+
+// CreateUser adds an account. The first account is an admin and gets
+// defaultUserID.
+func (s *sqliteDB) CreateUser(username, passwordHash string) (User, error) {
+	u := User{Username: username, CreatedAt: time.Now().UTC().Format(time.RFC3339)}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return u, err
+	}
+	defer tx.Rollback()
+	var n int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", username).Scan(&n); err != nil {
+		return u, err
+	}
+	if n > 0 {
+		return u, errUserExists
+	}
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users").Scan(&n); err != nil {
+		return u, err
+	}
+	var res sql.Result
+	if n == 0 {
+		u.Admin = true
+		res, err = tx.Exec("INSERT INTO users (id, username, password_hash, admin, created_at) VALUES (?, ?, ?, 1, ?)",
+			defaultUserID, username, passwordHash, u.CreatedAt)
+	} else {
+		res, err = tx.Exec("INSERT INTO users (username, password_hash, created_at) VALUES (?, ?, ?)",
+			username, passwordHash, u.CreatedAt)
+	}
+	if err != nil {
+		return u, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return u, err
+	}
+	u.ID = int(id)
+	return u, tx.Commit()
+}
+
+// UserByName returns the account and its password hash.
+func (s *sqliteDB) UserByName(username string) (User, string, error) {
+	var u User
+	var hash string
+	var createdAt sql.NullString
+	err := s.db.QueryRow("SELECT id, username, password_hash, admin, created_at FROM users WHERE username = ?", username).
+		Scan(&u.ID, &u.Username, &hash, &u.Admin, &createdAt)
+	if err == sql.ErrNoRows {
+		return u, "", errUserNotFound
+	}
+	u.CreatedAt = createdAt.String
+	return u, hash, err
+}
+
+func (s *sqliteDB) ListUsers() ([]User, error) {
+	rows, err := s.db.Query("SELECT id, username, admin, created_at FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := []User{}
+	for rows.Next() {
+		var u User
+		var createdAt sql.NullString
+		if err := rows.Scan(&u.ID, &u.Username, &u.Admin, &createdAt); err != nil {
+			return nil, err
+		}
+		u.CreatedAt = createdAt.String
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// SetPassword replaces a password hash and signs the user out everywhere.
+func (s *sqliteDB) SetPassword(id int, passwordHash string) error {
+	res, err := s.db.Exec("UPDATE users SET password_hash = ? WHERE id = ?", passwordHash, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errUserNotFound
+	}
+	_, err = s.db.Exec("DELETE FROM sessions WHERE user_id = ?", id)
+	return err
+}
+
+// DeleteUser removes an account with its sessions, API tokens,
+// subscriptions and reading state. Articles of feeds nobody else subscribes to are left for
+// the cache cleanup of RemoveFeed to handle on the next removal.
+func (s *sqliteDB) DeleteUser(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("DELETE FROM users WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errUserNotFound
+	}
+	if _, err := tx.Exec("DELETE FROM alert_matches WHERE alert_id IN (SELECT id FROM alerts WHERE user_id = ?)", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE user_id = ?)", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM article_tags WHERE tag_id IN (SELECT id FROM tags WHERE user_id = ?)", id); err != nil {
+		return err
+	}
+	for _, table := range []string{"sessions", "api_tokens", "fever_keys", "feeds", "read_articles", "starred_articles", "hidden_articles",
+		"read_later", "highlights", "article_notes", "read_operations", "read_history", "folders", "tags", "saved_searches", "rules", "alerts", "notification_channels", "webhooks"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE user_id = ?", id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// CreateSession stores a session for the user; only a hash of the token
+// is kept.
+func (s *sqliteDB) CreateSession(token string, userID int, expires time.Time) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	if _, err := s.db.Exec("DELETE FROM sessions WHERE expires_at < ?", now); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("INSERT INTO sessions (token_hash, user_id, expires_at) VALUES (?, ?, ?)",
+		hashToken(token), userID, expires.UTC().Format(time.RFC3339))
+	return err
+}
+
+// SessionUser returns the user of an unexpired session.
+func (s *sqliteDB) SessionUser(token string) (User, error) {
+	var u User
+	var createdAt sql.NullString
+	err := s.db.QueryRow(`SELECT users.id, users.username, users.admin, users.created_at
+		FROM sessions JOIN users ON users.id = sessions.user_id
+		WHERE sessions.token_hash = ? AND sessions.expires_at > ?`,
+		hashToken(token), time.Now().UTC().Format(time.RFC3339)).Scan(&u.ID, &u.Username, &u.Admin, &createdAt)
+	if err == sql.ErrNoRows {
+		return u, errUserNotFound
+	}
+	u.CreatedAt = createdAt.String
+	return u, err
+}
+
+func (s *sqliteDB) DeleteSession(token string) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE token_hash = ?", hashToken(token))
+	return err
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// currentUser returns the signed-in user of the request, if any.
+func currentUser(r *http.Request) (User, bool) {
+	u, ok := r.Context().Value(userKey{}).(User)
+	return u, ok
+}
+
+// userDB returns the database scoped to the signed-in user, or to
+// defaultUserID for requests that aren't signed in to an account.
+func userDB(r *http.Request) DB {
+	u, _ := currentUser(r)
+	return db.(*sqliteDB).ForUser(u.ID)
+}
+
+// requestUserID is the user whose state a request reads and changes.
+func requestUserID(r *http.Request) int {
+	return userDB(r).(*sqliteDB).userID()
+}
+
+// publicPaths are served without signing in, under /api/v1 as well.
+var publicPaths = []string{"/auth/login", outputFeedPrefix, feverPath, greaderLoginPath, "/openapi.json"}
+
+// withAuth authenticates requests by API token ("Authorization: Bearer")
+// or session cookie and adds the user to the request context. Requests
+// without credentials get 401, except for publicPaths and, when open is
+// set, everything else too, acting as defaultUserID. main only sets open
+// for servers listening on a loopback address.
+func withAuth(next http.Handler, open bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := bearerToken(r); ok {
+			u, err := db.APITokenUser(token)
+			if errors.Is(err, errTokenNotFound) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Invalid API token", http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Failed to check credentials", http.StatusInternalServerError)
+				return
+			}
+			// Tokens made before any account existed act as defaultUserID
+			// without being signed in as anyone.
+			if u.Username != "" {
+				r = r.WithContext(context.WithValue(r.Context(), userKey{}, u))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if c, err := r.Cookie(sessionCookie); err == nil {
+			if u, err := db.SessionUser(c.Value); err == nil {
+				r = r.WithContext(context.WithValue(r.Context(), userKey{}, u))
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if open {
+			next.ServeHTTP(w, r)
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, apiV1Prefix)
+		for _, p := range publicPaths {
+			if path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Sign in or send an API token", http.StatusUnauthorized)
+	})
+}
+
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// registerHandler handles POST /auth/register. It isn't public: the first
+// account needs an API token (or -no-auth), or "user create" on the
+// server; after that only admins can add accounts.
+func registerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req credentials
+	err := json.NewDecoder(r.Body).Decode(&req)
+	req.Username = strings.TrimSpace(req.Username)
+	if err != nil || req.Username == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if len(req.Password) < minPasswordLen {
+		http.Error(w, "Password must have at least 8 characters", http.StatusBadRequest)
+		return
+	}
+	n, err := db.CountUsers()
+	if err != nil {
+		http.Error(w, "Failed to create account", http.StatusInternalServerError)
+		return
+	}
+	if u, ok := currentUser(r); n > 0 && (!ok || !u.Admin) {
+		http.Error(w, "Only admins can create accounts", http.StatusForbidden)
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Failed to create account", http.StatusInternalServerError)
+		return
+	}
+	u, err := db.CreateUser(req.Username, string(hash))
+	if errors.Is(err, errUserExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to create account", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(u)
+}
+
+// loginHandler handles POST /auth/login, setting the session cookie.
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req credentials
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	u, err := checkPassword(req.Username, req.Password)
+	if errors.Is(err, errBadCredentials) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+	token, err := randomToken(32)
+	if err != nil {
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+	expires := time.Now().Add(sessionLifetime)
+	if err := db.CreateSession(token, u.ID, expires); err != nil {
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
+
+// checkPassword returns the user if the password matches. Unknown users
+// cost a bcrypt comparison too, so timing doesn't reveal usernames.
+func checkPassword(username, password string) (User, error) {
+	u, hash, err := db.UserByName(strings.TrimSpace(username))
+	if errors.Is(err, errUserNotFound) {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return u, errBadCredentials
+	}
+	if err != nil {
+		return u, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return u, errBadCredentials
+	}
+	return u, nil
+}
+
+// dummyHash is compared against when a username doesn't exist.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// logoutHandler handles POST /auth/logout.
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		if err := db.DeleteSession(c.Value); err != nil {
+			http.Error(w, "Failed to sign out", http.StatusInternalServerError)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1, HttpOnly: true})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// meHandler handles GET /auth/me, returning the signed-in user.
+func meHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	u, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Not signed in to an account", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
+
+// passwordHandler handles POST /auth/password with {"current", "new"}.
+// All sessions of the user end, including the current one.
+func passwordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	u, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "Sign in required", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		Current string `json:"current"`
+		New     string `json:"new"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if len(req.New) < minPasswordLen {
+		http.Error(w, "Password must have at least 8 characters", http.StatusBadRequest)
+		return
+	}
+	if _, err := checkPassword(u.Username, req.Current); err != nil {
+		http.Error(w, "Current password is wrong", http.StatusForbidden)
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.New), bcrypt.DefaultCost)
+	if err == nil {
+		err = db.SetPassword(u.ID, string(hash))
+	}
+	if err != nil {
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// usersHandler lets admins list (GET) and delete (DELETE {"id"}) accounts.
+// Accounts are created with POST /auth/register.
+func usersHandler(w http.ResponseWriter, r *http.Request) {
+	if u, ok := currentUser(r); !ok || !u.Admin {
+		http.Error(w, "Only admins can manage accounts", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		users, err := db.ListUsers()
+		if err != nil {
+			http.Error(w, "Failed to list users", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(users)
+	case http.MethodDelete:
+		var req struct {
+			ID int `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == 0 {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if u, _ := currentUser(r); req.ID == u.ID {
+			http.Error(w, "Admins cannot delete their own account", http.StatusBadRequest)
+			return
+		}
+		if err := db.DeleteUser(req.ID); err != nil {
+			if errors.Is(err, errUserNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to delete user", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// runUser implements user create, which reads the password from stdin. It
+// is how the first account is made on a server that requires credentials.
+func runUser(args []string) error {
+	if len(args) == 0 || args[0] != "create" {
+		return errors.New("usage: user create -name USERNAME")
+	}
+	fs := flag.NewFlagSet("user create", flag.ExitOnError)
+	name := fs.String("name", "", "username")
+	if rest := parseArgs(fs, args[1:]); len(rest) > 0 || strings.TrimSpace(*name) == "" {
+		return errors.New("usage: user create -name USERNAME")
+	}
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+	if len(password) < minPasswordLen {
+		return errors.New("password must have at least 8 characters")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	u, err := db.CreateUser(strings.TrimSpace(*name), string(hash))
+	if err != nil {
+		return err
+	}
+	role := "user"
+	if u.Admin {
+		role = "admin"
+	}
+	fmt.Printf("Created %s %s (id %d)\n", role, u.Username, u.ID)
+	return nil
+}
+
+// readPassword reads a password from the terminal without echoing it, or
+// the first line of stdin when it is piped.
+func readPassword() (string, error) {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		b, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		return string(b), err
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.New("no password on stdin")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
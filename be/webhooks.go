@@ -0,0 +1,628 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Webhook events.
+const (
+	EventArticleNew     = "article.new"
+	EventArticleUpdated = "article.updated"
+	EventFeedFailed     = "feed.failed"
+	EventFeedAdded      = "feed.added"
+	EventFeedRemoved    = "feed.removed"
+)
+
+var webhookEvents = []string{EventArticleNew, EventArticleUpdated, EventFeedFailed, EventFeedAdded, EventFeedRemoved}
+
+const (
+	// webhookMaxAttempts is how often a delivery is tried before it is
+	// marked failed; with scheduler.webhook_retry (30s by default) doubling
+	// each time the last try is about an hour after the first.
+	webhookMaxAttempts = 8
+	webhookBatchSize   = 20
+	// maxWebhookResponse bounds the response body kept in the delivery log.
+	maxWebhookResponse = 1024
+	maxWebhookLog      = 500
+)
+
+var (
+	errWebhookNotFound  = errors.New("webhook not found")
+	errDeliveryNotFound = errors.New("delivery not found")
+)
+
+// webhookClient is used for webhook deliveries.
+var webhookClient = &http.Client{Timeout: 15 * time.Second}
+
+// webhookWake nudges the delivery worker when events are queued.
+var webhookWake = make(chan struct{}, 1)
+
+// Webhook is a user's subscription to events. Payloads are signed with
+// Secret.
+type Webhook struct {
+	ID     int    `json:"id"`
+	URL    string `json:"url"`
+	Secret string `json:"secret,omitempty"`
+	// Events limits the subscription; empty means all events.
+	Events    []string `json:"events"`
+	Enabled   bool     `json:"enabled"`
+	CreatedAt string   `json:"created_at"`
+}
+
+// WebhookDelivery is one event queued for one webhook, with the result of
+// the latest attempt. Status is pending, retrying, delivered or failed.
+type WebhookDelivery struct {
+	ID            int             `json:"id"`
+	WebhookID     int             `json:"webhook_id"`
+	Event         string          `json:"event"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt string          `json:"next_attempt_at,omitempty"`
+	StatusCode    int             `json:"status_code,omitempty"`
+	Response      string          `json:"response,omitempty"`
+	Error         string          `json:"error,omitempty"`
+	CreatedAt     string          `json:"created_at"`
+	DeliveredAt   string          `json:"delivered_at,omitempty"`
+}
+
+// webhookPayload is the JSON body POSTed to webhooks.
+type webhookPayload struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt string      `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// articleEventData describes the article of article.* events.
+type articleEventData struct {
+	ID          int        `json:"id,omitempty"`
+	Title       string     `json:"title"`
+	Link        string     `json:"link"`
+	Feed        string     `json:"feed"`
+	Author      string     `json:"author,omitempty"`
+	PubDate     string     `json:"pubdate,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Enclosure   *Enclosure `json:"enclosure,omitempty"`
+}
+
+// feedEventData describes the feed of feed.* events.
+type feedEventData struct {
+	URL   string `json:"url"`
+	Name  string `json:"name,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func migrateWebhooks(db *sql.DB) error {
+	createWebhooks := `
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		created_at TEXT
+	);`
+	if _, err := db.Exec(createWebhooks); err != nil {
+		return err
+	}
+	createDeliveries := `
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL,
+		event TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TEXT,
+		status_code INTEGER,
+		response TEXT,
+		error TEXT,
+		created_at TEXT NOT NULL,
+		delivered_at TEXT
+	);
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);`
+	if _, err := db.Exec(createDeliveries); err != nil {
+		return err
+	}
+	// Webhooks from before accounts existed belong to defaultUserID.
+	return addColumn(db, "webhooks", "user_id", "INTEGER NOT NULL DEFAULT 1")
+}
+
+// ListWebhooks returns the user's webhooks.
+func (s *sqliteDB) ListWebhooks() ([]Webhook, error) {
+	return s.listWebhooks("WHERE user_id = ?", s.userID())
+}
+
+// ListAllWebhooks returns the webhooks of every user, for delivery.
+func (s *sqliteDB) ListAllWebhooks() ([]Webhook, error) {
+	return s.listWebhooks("")
+}
+
+func (s *sqliteDB) listWebhooks(where string, args ...interface{}) ([]Webhook, error) {
+	rows, err := s.db.Query("SELECT id, url, secret, events, enabled, created_at FROM webhooks "+where+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hooks := []Webhook{}
+	for rows.Next() {
+		var h Webhook
+		var events string
+		var createdAt sql.NullString
+		if err := rows.Scan(&h.ID, &h.URL, &h.Secret, &events, &h.Enabled, &createdAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(events), &h.Events); err != nil {
+			return nil, err
+		}
+		h.CreatedAt = createdAt.String
+		hooks = append(hooks, h)
+	}
+	return hooks, rows.Err()
+}
+
+func (s *sqliteDB) CreateWebhook(h Webhook) (Webhook, error) {
+	events, err := json.Marshal(h.Events)
+	if err != nil {
+		return h, err
+	}
+	h.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	res, err := s.db.Exec("INSERT INTO webhooks (user_id, url, secret, events, enabled, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		s.userID(), h.URL, h.Secret, string(events), h.Enabled, h.CreatedAt)
+	if err != nil {
+		return h, err
+	}
+	id, err := res.LastInsertId()
+	h.ID = int(id)
+	return h, err
+}
+
+// UpdateWebhook changes a webhook; an empty Secret keeps the current one.
+func (s *sqliteDB) UpdateWebhook(h Webhook) error {
+	events, err := json.Marshal(h.Events)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec("UPDATE webhooks SET url = ?, secret = COALESCE(NULLIF(?, ''), secret), events = ?, enabled = ? WHERE id = ? AND user_id = ?",
+		h.URL, h.Secret, string(events), h.Enabled, h.ID, s.userID())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errWebhookNotFound
+	}
+	return nil
+}
+
+// DeleteWebhook removes one of the user's webhooks and its delivery log.
+func (s *sqliteDB) DeleteWebhook(id int) error {
+	res, err := s.db.Exec("DELETE FROM webhooks WHERE id = ? AND user_id = ?", id, s.userID())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errWebhookNotFound
+	}
+	_, err = s.db.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", id)
+	return err
+}
+
+// QueueWebhookDelivery stores a delivery to be attempted right away.
+func (s *sqliteDB) QueueWebhookDelivery(webhookID int, event string, payload []byte) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err := s.db.Exec(`INSERT INTO webhook_deliveries (webhook_id, event, payload, next_attempt_at, created_at)
+		VALUES (?, ?, ?, ?, ?)`, webhookID, event, string(payload), now, now)
+	return err
+}
+
+// DueWebhookDeliveries returns up to limit deliveries whose next attempt is due.
+func (s *sqliteDB) DueWebhookDeliveries(limit int) ([]WebhookDelivery, error) {
+	return s.webhookDeliveries("WHERE status IN ('pending', 'retrying') AND next_attempt_at <= ? ORDER BY next_attempt_at, id LIMIT ?",
+		time.Now().UTC().Format(time.RFC3339), limit)
+}
+
+// UpdateWebhookDelivery stores the outcome of an attempt.
+func (s *sqliteDB) UpdateWebhookDelivery(d WebhookDelivery) error {
+	_, err := s.db.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_at = NULLIF(?, ''),
+		status_code = NULLIF(?, 0), response = NULLIF(?, ''), error = NULLIF(?, ''), delivered_at = NULLIF(?, '') WHERE id = ?`,
+		d.Status, d.Attempts, d.NextAttemptAt, d.StatusCode, d.Response, d.Error, d.DeliveredAt, d.ID)
+	return err
+}
+
+// RetryWebhookDelivery queues a delivery to one of the user's webhooks
+// again, whatever its status.
+func (s *sqliteDB) RetryWebhookDelivery(id int) error {
+	res, err := s.db.Exec(`UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = ?
+		WHERE id = ? AND webhook_id IN (SELECT id FROM webhooks WHERE user_id = ?)`,
+		time.Now().UTC().Format(time.RFC3339), id, s.userID())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errDeliveryNotFound
+	}
+	return nil
+}
+
+// WebhookDeliveryLog returns the latest deliveries to the user's webhooks,
+// of one webhook or (webhookID 0) all.
+func (s *sqliteDB) WebhookDeliveryLog(webhookID, limit int) ([]WebhookDelivery, error) {
+	where := "WHERE webhook_id IN (SELECT id FROM webhooks WHERE user_id = ?) "
+	args := []interface{}{s.userID()}
+	if webhookID != 0 {
+		where += "AND webhook_id = ? "
+		args = append(args, webhookID)
+	}
+	args = append(args, limit)
+	return s.webhookDeliveries(where+"ORDER BY id DESC LIMIT ?", args...)
+}
+
+func (s *sqliteDB) webhookDeliveries(clause string, args ...interface{}) ([]WebhookDelivery, error) {
+	rows, err := s.db.Query(`SELECT id, webhook_id, event, payload, status, attempts, next_attempt_at,
+		status_code, response, error, created_at, delivered_at FROM webhook_deliveries `+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		var payload string
+		var next, response, errMsg, deliveredAt sql.NullString
+		var statusCode sql.NullInt64
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &payload, &d.Status, &d.Attempts, &next,
+			&statusCode, &response, &errMsg, &d.CreatedAt, &deliveredAt); err != nil {
+			return nil, err
+		}
+		d.Payload = json.RawMessage(payload)
+		d.NextAttemptAt, d.Response, d.Error, d.DeliveredAt = next.String, response.String, errMsg.String, deliveredAt.String
+		d.StatusCode = int(statusCode.Int64)
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// wants reports whether the webhook subscribes to event.
+func (h Webhook) wants(event string) bool {
+	if !h.Enabled {
+		return false
+	}
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// emitEvent queues event for the webhooks of the user of d that subscribe
+// to it. Delivery happens in the background, so callers are never slowed
+// down by receivers; failures to queue are only logged.
+func emitEvent(d DB, event string, data interface{}) {
+	hooks, err := d.ListWebhooks()
+	if err != nil {
+		log.Printf("Failed to list webhooks for %s: %v", event, err)
+		return
+	}
+	queueEvent(hooks, event, data)
+}
+
+// emitFeedEvent queues event, about the feed feedURL or one of its
+// articles, for the webhooks of the users subscribed to the feed.
+func emitFeedEvent(feedURL, event string, data interface{}) {
+	users, err := feedSubscribers(feedURL)
+	if err != nil {
+		log.Printf("Failed to list subscribers for %s: %v", event, err)
+		return
+	}
+	var hooks []Webhook
+	for _, u := range users {
+		userHooks, err := db.ForUser(u).ListWebhooks()
+		if err != nil {
+			log.Printf("Failed to list webhooks for %s: %v", event, err)
+			return
+		}
+		hooks = append(hooks, userHooks...)
+	}
+	queueEvent(hooks, event, data)
+}
+
+// queueEvent queues event for those of hooks that subscribe to it.
+func queueEvent(hooks []Webhook, event string, data interface{}) {
+	queued := false
+	for _, h := range hooks {
+		if !h.wants(event) {
+			continue
+		}
+		id, err := randomToken(16)
+		if err != nil {
+			log.Printf("Failed to queue %s: %v", event, err)
+			return
+		}
+		payload, err := json.Marshal(webhookPayload{ID: id, Event: event, CreatedAt: time.Now().UTC().Format(time.RFC3339), Data: data})
+		if err != nil {
+			log.Printf("Failed to queue %s: %v", event, err)
+			return
+		}
+		if err := db.QueueWebhookDelivery(h.ID, event, payload); err != nil {
+			log.Printf("Failed to queue %s for webhook %d: %v", event, h.ID, err)
+			continue
+		}
+		queued = true
+	}
+	if queued {
+		select {
+		case webhookWake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func articleEvent(p Post, feedURL string) articleEventData {
+	return articleEventData{
+		ID:          p.ID,
+		Title:       p.Title,
+		Link:        p.Link,
+		Feed:        feedURL,
+		Author:      p.Author,
+		PubDate:     p.PubDate,
+		Description: p.Description,
+		Enclosure:   p.Enclosure,
+	}
+}
+
+// signPayload returns the X-Webhook-Signature header value for body.
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// runWebhookDispatcher delivers queued webhook events until the process exits.
+func runWebhookDispatcher() {
+	ticker := time.NewTicker(config.Scheduler.WebhookInterval)
+	defer ticker.Stop()
+	for {
+		if err := deliverDueWebhooks(); err != nil {
+			log.Println("Failed to deliver webhooks:", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-webhookWake:
+		}
+	}
+}
+
+func deliverDueWebhooks() error {
+	for {
+		due, err := db.DueWebhookDeliveries(webhookBatchSize)
+		if err != nil || len(due) == 0 {
+			return err
+		}
+		hooks, err := db.ListAllWebhooks()
+		if err != nil {
+			return err
+		}
+		byID := map[int]Webhook{}
+		for _, h := range hooks {
+			byID[h.ID] = h
+		}
+		for _, d := range due {
+			h, ok := byID[d.WebhookID]
+			if !ok || !h.Enabled {
+				d.Status, d.Error, d.NextAttemptAt = "failed", "webhook disabled or removed", ""
+			} else {
+				attemptWebhookDelivery(h, &d)
+			}
+			if err := db.UpdateWebhookDelivery(d); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// attemptWebhookDelivery POSTs the delivery once and updates d with the
+// result and, on failure, when to try again.
+func attemptWebhookDelivery(h Webhook, d *WebhookDelivery) {
+	d.Attempts++
+	d.StatusCode, d.Response, d.Error = 0, "", ""
+	err := func() error {
+		req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(d.Payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "RSSReaderGo-Webhook")
+		req.Header.Set("X-Webhook-Event", d.Event)
+		req.Header.Set("X-Webhook-Delivery", strconv.Itoa(d.ID))
+		req.Header.Set("X-Webhook-Signature", signPayload(h.Secret, d.Payload))
+		resp, err := webhookClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookResponse))
+		d.StatusCode, d.Response = resp.StatusCode, string(body)
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return nil
+	}()
+
+	now := time.Now().UTC()
+	switch {
+	case err == nil:
+		d.Status, d.NextAttemptAt, d.DeliveredAt = "delivered", "", now.Format(time.RFC3339)
+	case d.Attempts >= webhookMaxAttempts:
+		d.Status, d.Error, d.NextAttemptAt = "failed", err.Error(), ""
+	default:
+		backoff := config.Scheduler.WebhookRetry << (d.Attempts - 1)
+		d.Status, d.Error, d.NextAttemptAt = "retrying", err.Error(), now.Add(backoff).Format(time.RFC3339)
+	}
+}
+
+// validate checks the webhook URL and events.
+func (h *Webhook) validate() error {
+	if !validFeedURL(h.URL) {
+		return errors.New("url must be an http(s) URL")
+	}
+	if h.Events == nil {
+		h.Events = []string{}
+	}
+	for _, e := range h.Events {
+		known := false
+		for _, k := range webhookEvents {
+			known = known || e == k
+		}
+		if !known {
+			return fmt.Errorf("unknown event %q (known: %s)", e, strings.Join(webhookEvents, ", "))
+		}
+	}
+	return nil
+}
+
+// webhooksHandler lists (GET), creates (POST), updates (PATCH) and deletes
+// (DELETE) the user's webhooks. The secret is only returned when a webhook
+// is created.
+func webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	db := userDB(r)
+	switch r.Method {
+	case http.MethodGet:
+		hooks, err := db.ListWebhooks()
+		if err != nil {
+			http.Error(w, "Failed to list webhooks", http.StatusInternalServerError)
+			return
+		}
+		for i := range hooks {
+			hooks[i].Secret = secretPlaceholder
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hooks)
+	case http.MethodPost, http.MethodPatch:
+		h := Webhook{Enabled: true}
+		if err := json.NewDecoder(r.Body).Decode(&h); err != nil || (r.Method == http.MethodPatch && h.ID == 0) {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := h.validate(); err != nil {
+			http.Error(w, "Invalid webhook: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if h.Secret == secretPlaceholder {
+			h.Secret = ""
+		}
+		if r.Method == http.MethodPatch {
+			if err := db.UpdateWebhook(h); err != nil {
+				webhookError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if h.Secret == "" {
+			secret, err := randomToken(32)
+			if err != nil {
+				http.Error(w, "Failed to create webhook", http.StatusInternalServerError)
+				return
+			}
+			h.Secret = secret
+		}
+		h, err := db.CreateWebhook(h)
+		if err != nil {
+			webhookError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(h)
+	case http.MethodDelete:
+		var req struct {
+			ID int `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == 0 {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := db.DeleteWebhook(req.ID); err != nil {
+			webhookError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// webhookDeliveriesHandler lists deliveries to the user's webhooks (GET,
+// with optional webhook_id and limit) and queues one again (POST {"id"}).
+func webhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	db := userDB(r)
+	switch r.Method {
+	case http.MethodGet:
+		webhookID := 0
+		if v := r.URL.Query().Get("webhook_id"); v != "" {
+			var err error
+			if webhookID, err = strconv.Atoi(v); err != nil {
+				http.Error(w, "Invalid webhook_id", http.StatusBadRequest)
+				return
+			}
+		}
+		limit := 100
+		if v := r.URL.Query().Get("limit"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				limit = min(n, maxWebhookLog)
+			}
+		}
+		deliveries, err := db.WebhookDeliveryLog(webhookID, limit)
+		if err != nil {
+			http.Error(w, "Failed to list deliveries", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(deliveries)
+	case http.MethodPost:
+		var req struct {
+			ID int `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == 0 {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := db.RetryWebhookDelivery(req.ID); err != nil {
+			webhookError(w, err)
+			return
+		}
+		select {
+		case webhookWake <- struct{}{}:
+		default:
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func webhookError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errWebhookNotFound) || errors.Is(err, errDeliveryNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Failed to update webhooks", http.StatusInternalServerError)
+}
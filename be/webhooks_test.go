@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestSignPayload(t *testing.T) {
+	tests := []struct {
+		secret, body, want string
+	}{
+		// RFC 4231, test case 2.
+		{"Jefe", "what do ya want for nothing?",
+			"sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"},
+		// The example of GitHub's webhook documentation, which signs the same way.
+		{"It's a Secret to Everybody", "Hello, World!",
+			"sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"},
+		{"secret", "",
+			"sha256=f9e66e179b6747ae54108f82f8ade8b3c25d76fd30afde6c395822c530196169"},
+	}
+	for _, tt := range tests {
+		if got := signPayload(tt.secret, []byte(tt.body)); got != tt.want {
+			t.Errorf("signPayload(%q, %q) = %s, want %s", tt.secret, tt.body, got, tt.want)
+		}
+	}
+}
@@ -4,11 +4,24 @@ import { useStore } from "./utils/store";
 import NavSidebar from "./components/NavSidebar";
 import FeedControls from "./components/FeedControls";
 import Home from "./components/Home";
+import Login from "./components/Login";
+import { AUTH_REQUIRED, signOut } from "./utils/api";
 
 export interface Feed {
   id: number;
   url: string;
   feed_name: string;
+  unread?: number;
+}
+
+// SavedSearch is a saved search, which GET /feeds lists after the feeds
+// marked "type": "search".
+export interface SavedSearch {
+  type: "search";
+  id: number;
+  feed_name: string;
+  query: string;
+  unread: number;
 }
 
 export interface PostResponse {
@@ -35,14 +48,20 @@ export interface Post {
 export default function App() {
   const {
     feeds,
+    searches,
     posts,
     reload,
     addFeedUrl,
     removeFeedUrl,
   } = useStore();
 
+  const [signedOut, setSignedOut] = useState(false);
+
   useEffect(() => {
-    reload();
+    const show = () => setSignedOut(true);
+    window.addEventListener(AUTH_REQUIRED, show);
+    reload().catch(() => {});
+    return () => window.removeEventListener(AUTH_REQUIRED, show);
   }, []);
 
   const grouped: Record<string, Post[]> = {};
@@ -57,6 +76,17 @@ export default function App() {
 
   const [activePage, setActivePage] = useState("home");
 
+  if (signedOut) {
+    return (
+      <Login
+        onSignedIn={() => {
+          setSignedOut(false);
+          reload().catch(() => {});
+        }}
+      />
+    );
+  }
+
   return (
     <div>
       <NavSidebar active={activePage} setActive={setActivePage} />
@@ -69,13 +99,17 @@ export default function App() {
         {activePage === "feeds" && (
           <FeedControls
             feeds={feeds}
+            searches={searches}
             addFeed={addFeedUrl}
             removeFeed={removeFeedUrl}
           />
         )}
         {activePage === "settings" && (
           <div style={{ padding: 32 }}>
-            Settings page (coming soon)
+            <button type="button" onClick={() => signOut()}>
+              Sign out
+            </button>
+            <p>Settings page (coming soon)</p>
             <ul>
               <li>Parse Youtube Links</li>
               <li>Better UI</li>
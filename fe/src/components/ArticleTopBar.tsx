@@ -1,4 +1,5 @@
 import { useRef, useState } from "preact/hooks";
+import { apiFetch } from "../utils/api";
 
 interface ArticleTopBarProps {
   isRead: boolean;
@@ -73,7 +74,7 @@ export default function ArticleTopBar({
     setLoading(true);
     onParsed(null, null);
     try {
-      const res = await fetch(
+      const res = await apiFetch(
         `/parse-article?url=${encodeURIComponent(postLink)}`
       );
       if (!res.ok) throw new Error("Failed to parse article");
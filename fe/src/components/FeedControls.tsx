@@ -1,14 +1,16 @@
 import { useState } from "preact/hooks";
-import { Feed } from "../App";
+import { Feed, SavedSearch } from "../App";
 
 interface FeedControlsProps {
   feeds: Feed[];
+  searches: SavedSearch[];
   addFeed: (url: string, feed_name: string) => void;
   removeFeed: (url: string) => void;
 }
 
 export default function FeedControls({
   feeds,
+  searches,
   addFeed,
   removeFeed,
 }: FeedControlsProps) {
@@ -59,6 +61,18 @@ export default function FeedControls({
           </div>
         ))}
       </div>
+      {searches.length > 0 && (
+        <div id="saved-search-list">
+          <h3>Saved searches</h3>
+          {searches.map((search) => (
+            <div className="feed-url-row" key={`search-${search.id}`}>
+              <span className="feed-url-text">{search.feed_name}</span>
+              <span className="feed-url-text">{search.query}</span>
+              <span className="feed-url-text">{search.unread} unread</span>
+            </div>
+          ))}
+        </div>
+      )}
     </div>
   );
 }
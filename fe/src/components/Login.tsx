@@ -0,0 +1,82 @@
+import { useState } from "preact/hooks";
+import { signIn, setAPIToken } from "../utils/api";
+
+interface LoginProps {
+  onSignedIn: () => void;
+}
+
+// Login signs in with an account, or with an API token for servers
+// without accounts.
+export default function Login({ onSignedIn }: LoginProps) {
+  const [username, setUsername] = useState("");
+  const [password, setPassword] = useState("");
+  const [token, setToken] = useState("");
+  const [withToken, setWithToken] = useState(false);
+  const [error, setError] = useState("");
+
+  return (
+    <div id="login">
+      <h2>Sign in</h2>
+      <form
+        id="login-form"
+        onSubmit={async (e) => {
+          e.preventDefault();
+          if (withToken) {
+            setAPIToken(token);
+            onSignedIn();
+            return;
+          }
+          const err = await signIn(username.trim(), password);
+          if (err) {
+            setError(err);
+          } else {
+            setPassword("");
+            setError("");
+            onSignedIn();
+          }
+        }}
+      >
+        {withToken ? (
+          <input
+            type="password"
+            placeholder="API token"
+            required
+            value={token}
+            onInput={(e) => setToken((e.target as HTMLInputElement).value)}
+          />
+        ) : (
+          <>
+            <input
+              type="text"
+              placeholder="Username"
+              autoComplete="username"
+              required
+              value={username}
+              onInput={(e) => setUsername((e.target as HTMLInputElement).value)}
+            />
+            <input
+              type="password"
+              placeholder="Password"
+              autoComplete="current-password"
+              required
+              value={password}
+              onInput={(e) => setPassword((e.target as HTMLInputElement).value)}
+            />
+          </>
+        )}
+        <button type="submit">Sign in</button>
+      </form>
+      {error && <div className="login-error">{error}</div>}
+      <button
+        type="button"
+        className="login-switch"
+        onClick={() => {
+          setWithToken(!withToken);
+          setError("");
+        }}
+      >
+        {withToken ? "Sign in with an account" : "Use an API token instead"}
+      </button>
+    </div>
+  );
+}
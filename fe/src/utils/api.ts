@@ -1,22 +1,75 @@
-import { Feed, PostResponse } from "../App";
+import { Feed, PostResponse, SavedSearch } from "../App";
 
-export async function fetchFeeds(): Promise<Feed[]> {
-  const res = await fetch("/feeds");
+const TOKEN_KEY = "apiToken";
+
+// AUTH_REQUIRED is dispatched on window when the backend answers 401, so
+// the app can show the sign-in form.
+export const AUTH_REQUIRED = "auth-required";
+
+// apiFetch calls the backend with the API token kept in localStorage, or
+// else with the session cookie of signIn.
+export async function apiFetch(
+  input: string,
+  init: RequestInit = {}
+): Promise<Response> {
+  const headers = new Headers(init.headers);
+  const token = localStorage.getItem(TOKEN_KEY);
+  if (token) headers.set("Authorization", `Bearer ${token}`);
+  const res = await fetch(input, { ...init, headers, credentials: "same-origin" });
+  if (res.status === 401) {
+    window.dispatchEvent(new Event(AUTH_REQUIRED));
+    throw new Error("Sign in required");
+  }
+  return res;
+}
+
+// signIn starts a session with an account's username and password. It
+// returns the server's error message, or null on success.
+export async function signIn(
+  username: string,
+  password: string
+): Promise<string | null> {
+  localStorage.removeItem(TOKEN_KEY);
+  const res = await fetch("/auth/login", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ username, password }),
+    credentials: "same-origin",
+  });
+  return res.ok ? null : (await res.text()).trim();
+}
+
+// setAPIToken authenticates with an API token (created with
+// `go run . token create -name web` in be/) instead of an account.
+export function setAPIToken(token: string) {
+  localStorage.setItem(TOKEN_KEY, token.trim());
+}
+
+export async function signOut() {
+  localStorage.removeItem(TOKEN_KEY);
+  await fetch("/auth/logout", { method: "POST", credentials: "same-origin" });
+  window.dispatchEvent(new Event(AUTH_REQUIRED));
+}
+
+// fetchFeeds returns the subscribed feeds and, listed after them, the
+// saved searches.
+export async function fetchFeeds(): Promise<(Feed | SavedSearch)[]> {
+  const res = await apiFetch("/feeds");
   return await res.json();
 }
 
 export async function fetchReadLinks(): Promise<string[]> {
-  const res = await fetch("/read");
+  const res = await apiFetch("/read");
   return await res.json();
 }
 
 export async function fetchPosts(): Promise<PostResponse> {
-  const res = await fetch("/posts");
+  const res = await apiFetch("/posts");
   return await res.json();
 }
 
 export async function addFeed(url: string, name: string) {
-  await fetch("/feeds", {
+  await apiFetch("/feeds", {
     method: "POST",
     headers: { "Content-Type": "application/json" },
     body: JSON.stringify(name ? { url, name } : { url }),
@@ -24,7 +77,7 @@ export async function addFeed(url: string, name: string) {
 }
 
 export async function removeFeed(url: string) {
-  await fetch("/feeds", {
+  await apiFetch("/feeds", {
     method: "DELETE",
     headers: { "Content-Type": "application/json" },
     body: JSON.stringify({ url }),
@@ -32,7 +85,7 @@ export async function removeFeed(url: string) {
 }
 
 export async function markRead(link: string) {
-  await fetch("/read", {
+  await apiFetch("/read", {
     method: "POST",
     headers: { "Content-Type": "application/json" },
     body: JSON.stringify({ link }),
@@ -40,7 +93,7 @@ export async function markRead(link: string) {
 }
 
 export async function markUnread(link: string) {
-  await fetch("/unread", {
+  await apiFetch("/unread", {
     method: "POST",
     headers: { "Content-Type": "application/json" },
     body: JSON.stringify({ link }),
@@ -1,3 +1,4 @@
+import type { Feed, SavedSearch } from "../App";
 import {
   fetchFeeds,
   fetchReadLinks,
@@ -14,8 +15,11 @@ export async function reloadAll() {
     fetchReadLinks(),
     fetchPosts(),
   ]);
+  const isSearch = (f: Feed | SavedSearch): f is SavedSearch =>
+    "type" in f && f.type === "search";
   return {
-    feeds: feedsFetched,
+    feeds: feedsFetched.filter((f): f is Feed => !isSearch(f)),
+    searches: feedsFetched.filter(isSearch),
     readLinks: new Set(read),
     posts: postsFetched?.articles || [],
   };
@@ -6,10 +6,12 @@ import {
   markRead,
   markUnread,
 } from "./feed-utils";
-import type { Feed, Post } from "../App";
+import { apiFetch } from "./api";
+import type { Feed, Post, SavedSearch } from "../App";
 
 interface StoreState {
   feeds: Feed[];
+  searches: SavedSearch[];
   posts: Post[];
   readLinks: Set<string>;
   selected: Post | null;
@@ -25,6 +27,7 @@ interface StoreState {
 
 export const useStore = create<StoreState>((set, get) => ({
   feeds: [],
+  searches: [],
   posts: [],
   readLinks: new Set(),
   selected: null,
@@ -33,8 +36,8 @@ export const useStore = create<StoreState>((set, get) => ({
   setSelected: (post) => set({ selected: post }),
 
   reload: async () => {
-    const { feeds, readLinks, posts } = await reloadAll();
-    set({ feeds, readLinks, posts });
+    const { feeds, searches, readLinks, posts } = await reloadAll();
+    set({ feeds, searches, readLinks, posts });
 
     // Update selected if needed
     const { selected } = get();
@@ -76,7 +79,7 @@ export const useStore = create<StoreState>((set, get) => ({
   refreshFeeds: async () => {
     set({ refreshing: true });
     try {
-      await fetch("/refresh", { method: "POST" });
+      await apiFetch("/refresh", { method: "POST" });
       await get().reload();
     } catch (e) {
       alert("Failed to refresh feeds");
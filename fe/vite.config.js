@@ -12,6 +12,7 @@ export default defineConfig({
       "/unread": "http://localhost:8080",
       "/refresh": "http://localhost:8080",
       "/parse-article": "http://localhost:8080",
+      "/auth": "http://localhost:8080",
       // Add any other API endpoints you use
     },
   },
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/cookiejar"
+	"net/url"
 	"os"
-	"regexp"
 	"strings"
 
+	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 )
 
@@ -25,48 +30,166 @@ type PostsResponse struct {
 	Articles  []Post `json:"articles"`
 }
 
+// backendURL is the server's base URL, from -url or $RSS_READER_URL.
+var backendURL = "http://localhost:8080"
+
+// setBackendURL picks the server URL from -url, then $RSS_READER_URL, and
+// checks it is an http(s) URL.
+func setBackendURL() error {
+	flagURL := flag.String("url", "", "server URL (default: $RSS_READER_URL, or "+backendURL+")")
+	flag.Parse()
+	if *flagURL != "" {
+		backendURL = *flagURL
+	} else if env := os.Getenv("RSS_READER_URL"); env != "" {
+		backendURL = env
+	}
+	u, err := url.Parse(backendURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid server URL %q: want http(s)://host[:port]", backendURL)
+	}
+	backendURL = strings.TrimSuffix(backendURL, "/")
+	return nil
+}
+
+// client keeps the session cookie of signIn.
+var client = &http.Client{Jar: newJar()}
+
+func newJar() http.CookieJar {
+	jar, _ := cookiejar.New(nil)
+	return jar
+}
+
+// signIn starts a session for $RSS_READER_USER with $RSS_READER_PASSWORD,
+// when they are set and no API token is.
+func signIn() error {
+	user := os.Getenv("RSS_READER_USER")
+	if user == "" || os.Getenv("RSS_READER_TOKEN") != "" {
+		return nil
+	}
+	body, _ := json.Marshal(map[string]string{"username": user, "password": os.Getenv("RSS_READER_PASSWORD")})
+	resp, err := client.Post(backendURL+"/auth/login", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("signing in as %s: %s", user, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// apiRequest sends a request to the backend, authenticated with the API
+// token in $RSS_READER_TOKEN (create one with "go run . token create" in
+// be/) or the session of signIn. A 401 response is returned as an error.
+func apiRequest(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, backendURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token := os.Getenv("RSS_READER_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		return nil, fmt.Errorf("the backend refused the request; set RSS_READER_TOKEN to a valid API token, or RSS_READER_USER and RSS_READER_PASSWORD")
+	}
+	return resp, nil
+}
+
+// fetchArticles loads all cached articles with their content rendered as
+// plain text by the backend.
 func fetchArticles() ([]Post, error) {
-	resp, err := http.Get("http://localhost:8080/posts")
+	resp, err := apiRequest(http.MethodGet, "/posts?format=text", nil)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s", strings.TrimSpace(string(msg)))
+	}
 	var pr PostsResponse
 	err = json.NewDecoder(resp.Body).Decode(&pr)
 	return pr.Articles, err
 }
 
+// QueueItem is an entry in the backend's read-later queue.
+type QueueItem struct {
+	ID      int    `json:"id"`
+	Link    string `json:"link"`
+	Title   string `json:"title"`
+	Content string `json:"content"`
+	Minutes int    `json:"minutes"`
+}
+
+type QueueResponse struct {
+	Items        []QueueItem `json:"items"`
+	TotalMinutes int         `json:"total_minutes"`
+}
+
+// addToQueue appends an article to the read-later queue.
+func addToQueue(link string) error {
+	body, _ := json.Marshal(map[string]string{"link": link})
+	resp, err := apiRequest(http.MethodPost, "/queue", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s", strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// popQueue removes the next item from the read-later queue and returns it
+// with plain-text content. ok is false when the queue is empty.
+func popQueue() (item QueueItem, ok bool, err error) {
+	resp, err := apiRequest(http.MethodPost, "/queue/pop?format=text", nil)
+	if err != nil {
+		return item, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return item, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return item, false, fmt.Errorf("%s", strings.TrimSpace(string(msg)))
+	}
+	err = json.NewDecoder(resp.Body).Decode(&item)
+	return item, err == nil, err
+}
+
+// fetchQueue returns the read-later queue.
+func fetchQueue() (QueueResponse, error) {
+	var qr QueueResponse
+	resp, err := apiRequest(http.MethodGet, "/queue", nil)
+	if err != nil {
+		return qr, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return qr, fmt.Errorf("%s", strings.TrimSpace(string(msg)))
+	}
+	err = json.NewDecoder(resp.Body).Decode(&qr)
+	return qr, err
+}
+
 func pad(s string, padLines int) string {
 	padStr := strings.Repeat("\n", padLines)
 	return padStr + s + padStr
 }
 
-// cleanHTML removes HTML tags and cleans up the text
-func cleanHTML(input string) string {
-	// Remove HTML tags
-	re := regexp.MustCompile(`<[^>]*>`)
-	text := re.ReplaceAllString(input, "")
-
-	// Clean up common HTML entities
-	text = strings.ReplaceAll(text, "&nbsp;", " ")
-	text = strings.ReplaceAll(text, "&amp;", "&")
-	text = strings.ReplaceAll(text, "&lt;", "<")
-	text = strings.ReplaceAll(text, "&gt;", ">")
-	text = strings.ReplaceAll(text, "&quot;", "\"")
-	text = strings.ReplaceAll(text, "&#39;", "'")
-	text = strings.ReplaceAll(text, "&apos;", "'")
-
-	// Remove excessive whitespace
-	re = regexp.MustCompile(`\s+`)
-	text = re.ReplaceAllString(text, " ")
-
-	// Clean up line breaks
-	text = strings.ReplaceAll(text, "\n\n\n", "\n\n")
-	text = strings.TrimSpace(text)
-
-	return text
-}
-
 // wrapText wraps text to fit within specified width
 func wrapText(text string, width int) string {
 	if len(text) <= width {
@@ -97,6 +220,14 @@ func wrapText(text string, width int) string {
 }
 
 func main() {
+	if err := setBackendURL(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := signIn(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	app := tview.NewApplication()
 
 	// Use Table instead of List for better multi-line support
@@ -145,45 +276,111 @@ func main() {
 		row++
 	}
 
+	showArticle := func(title, subtitle, content, link string) {
+		contentView.Clear()
+		fmt.Fprint(contentView, pad(fmt.Sprintf(
+			"[yellow::b]%s[white]\n[gray]%s[white]\n\n%s\n\n[blue]Link: %s",
+			tview.Escape(title), tview.Escape(subtitle), tview.Escape(content), link,
+		), 1))
+		contentView.ScrollToBeginning()
+	}
+	showPost := func(article Post) {
+		content := article.Content
+		if content == "" {
+			content = article.Description
+		}
+		showArticle(article.Title, article.PubDate, content, article.Link)
+	}
+
+	// Status line with the read-later queue and key hints. setStatus runs
+	// on the UI goroutine; it shows msg, in color if one is given, right
+	// away and the queue once it has been fetched in the background.
+	statusView := tview.NewTextView().SetDynamicColors(true)
+	queue := "read later: …"
+	status, statusSeq := "", 0
+	showStatus := func() {
+		statusView.SetText(fmt.Sprintf(" [gray]%s · a: add to read later · n: read next[white] %s", tview.Escape(queue), status))
+	}
+	setStatus := func(color, msg string) {
+		status = tview.Escape(msg)
+		if color != "" && msg != "" {
+			status = "[" + color + "]" + status + "[white]"
+		}
+		showStatus()
+		statusSeq++
+		seq := statusSeq
+		go func() {
+			qr, err := fetchQueue()
+			app.QueueUpdateDraw(func() {
+				if seq != statusSeq {
+					return // a later call fetches the queue again
+				}
+				if err != nil {
+					queue = "queue unavailable: " + err.Error()
+				} else {
+					queue = fmt.Sprintf("read later: %d (~%d min)", len(qr.Items), qr.TotalMinutes)
+				}
+				showStatus()
+			})
+		}()
+	}
+	setStatus("", "")
+
 	// Set selection handler
 	articleTable.SetSelectedFunc(func(row, column int) {
 		if article, exists := articleMap[row]; exists {
-			content := article.Content
-			if content == "" {
-				content = article.Description
-			}
-
-			// Clean HTML from content
-			cleanContent := cleanHTML(content)
+			showPost(article)
+		}
+	})
 
-			contentView.Clear()
-			fmt.Fprint(contentView, pad(fmt.Sprintf(
-				"[yellow::b]%s[white]\n[gray]%s[white]\n\n%s\n\n[blue]Link: %s",
-				article.Title, article.PubDate, cleanContent, article.Link,
-			), 1))
-			contentView.ScrollToBeginning()
+	articleTable.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Rune() {
+		case 'a':
+			row, _ := articleTable.GetSelection()
+			if article, exists := articleMap[row]; exists {
+				go func() {
+					err := addToQueue(article.Link)
+					app.QueueUpdateDraw(func() {
+						if err != nil {
+							setStatus("red", err.Error())
+						} else {
+							setStatus("", "added "+article.Title)
+						}
+					})
+				}()
+			}
+			return nil
+		case 'n':
+			go func() {
+				item, ok, err := popQueue()
+				app.QueueUpdateDraw(func() {
+					switch {
+					case err != nil:
+						setStatus("red", err.Error())
+					case !ok:
+						setStatus("", "nothing left to read")
+					default:
+						showArticle(item.Title, fmt.Sprintf("Read later · %d min", item.Minutes), item.Content, item.Link)
+						setStatus("", "")
+					}
+				})
+			}()
+			return nil
 		}
+		return event
 	})
 
 	// Load first article by default
 	if len(articles) > 0 {
-		content := articles[0].Content
-		if content == "" {
-			content = articles[0].Description
-		}
-		cleanContent := cleanHTML(content)
-
-		contentView.Clear()
-		fmt.Fprint(contentView, pad(fmt.Sprintf(
-			"[yellow::b]%s[white]\n[gray]%s[white]\n\n%s\n\n[blue]Link: %s",
-			articles[0].Title, articles[0].PubDate, cleanContent, articles[0].Link,
-		), 1))
-		contentView.ScrollToBeginning()
+		showPost(articles[0])
 	}
 
-	flex := tview.NewFlex().
+	panes := tview.NewFlex().
 		AddItem(articleTable, 0, 1, true).
 		AddItem(contentView, 0, 2, false)
+	flex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(panes, 0, 1, true).
+		AddItem(statusView, 1, 0, false)
 
 	if err := app.SetRoot(flex, true).EnableMouse(true).Run(); err != nil {
 		panic(err)